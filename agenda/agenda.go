@@ -3,36 +3,35 @@ package agenda
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/gofrs/uuid"
-	yaml "gopkg.in/yaml.v3"
 )
 
-var fileFormats = []string{"mp3", "m4a", "webm"}
+// defaultFileFormats are the audio file formats of an agenda which does not
+// list its own
+var defaultFileFormats = []string{"mp3", "m4a", "webm"}
 
-// New attempts to load an agenda from the given filename
+// New attempts to load an agenda from the given filename.  If the agenda has
+// any errors, the returned error will be a *Report listing all of them.
 func New(filename string) (*Agenda, error) {
-	data, err := os.ReadFile(filename)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read agenda from file: %w", err)
+	a, report := Validate(filename)
+	if report.HasErrors() {
+		return nil, report
 	}
 
-	a := new(Agenda)
-
-	if err := yaml.Unmarshal(data, a); err != nil {
-		return nil, fmt.Errorf("failed to read YAML: %w", err)
-	}
-
-	// If the agenda has its own set of file formats, use them instead of the default
-	if len(a.Formats) > 0 {
-		fileFormats = a.Formats
-	}
+	return a, nil
+}
 
+// prepare populates defaults and generates the IDs of all elements of the
+// agenda, recording any problems in the given report.
+func (a *Agenda) prepare(report *Report) {
 	// If there is no MediaBaseURL, use "media/"
 	if a.MediaBaseURL == "" {
 		a.MediaBaseURL = "/media/"
@@ -41,24 +40,24 @@ func New(filename string) (*Agenda, error) {
 	a.MediaBaseURL = strings.TrimSuffix(a.MediaBaseURL, "/")
 
 	// Generate all IDs
-	for _, c := range a.Cues {
-		if err = c.generateID(); err != nil {
-			return nil, fmt.Errorf("failed to generate cue %s: %w", c.Name, err)
+	for i, c := range a.Cues {
+		if c.Name == "" {
+			report.warnf(path{"cues"}.index(i), "cue has no name; a random one will be generated on each load, so its ID will not be stable")
 		}
-	}
-	for _, r := range a.Rooms {
-		if err = r.generateIDs(a); err != nil {
-			return nil, fmt.Errorf("failed to generate room %s: %w", r.Name, err)
+		if err := c.generateID(); err != nil {
+			report.add(SeverityError, path{"cues"}.index(i), fmt.Errorf("failed to generate cue %s: %w", c.Name, err))
 		}
+	}
+	for i, r := range a.Rooms {
+		r.generateIDs(a, path{"rooms"}.index(i), report)
 
 		r.populateDefaults()
 	}
-	for _, ann := range a.Announcements {
-		if err = ann.generateID(a); err != nil {
-			return nil, fmt.Errorf("failed to generate announcement %s: %w", ann.Name, err)
+	for i, ann := range a.Announcements {
+		if err := ann.generateID(a); err != nil {
+			report.add(SeverityError, path{"announcements"}.index(i).key("track"), fmt.Errorf("failed to generate announcement %s: %w", ann.Name, err))
 		}
 	}
-	return a, err
 }
 
 // Agenda describes the order of service and details of a performance
@@ -97,6 +96,20 @@ type Agenda struct {
 	// server, and so not validation should be performed, and no modifications
 	// of the prefix be made.  This is not recommended.
 	RemoteMedia bool `json:"remoteMedia" yaml:"remoteMedia"`
+
+	// dir is the directory of the agenda file, relative to which the media
+	// files are found
+	dir string
+}
+
+// fileFormats returns the audio file formats of the agenda: its own, if it
+// lists any, or else the default.
+func (a *Agenda) fileFormats() []string {
+	if len(a.Formats) > 0 {
+		return a.Formats
+	}
+
+	return defaultFileFormats
 }
 
 // AllTracks returns the list of all tracks for all rooms and announcements so
@@ -159,6 +172,94 @@ type Cue struct {
 	// should last before the next one.  This is informational only and will be
 	// displayed in the administrative control panel if supplied.
 	ReferenceSeconds int64 `json:"referenceSeconds" yaml:"referenceSeconds"`
+
+	// Schedule optionally triggers the cue automatically, at a given time or
+	// after another cue.
+	Schedule *Schedule `json:"schedule,omitempty" yaml:"schedule"`
+}
+
+// Schedule describes when a cue is triggered automatically, without being
+// received from QLab.  Exactly one of At and After must be given.
+type Schedule struct {
+
+	// At is the time at which the cue is triggered: either a time of day in
+	// the server's local time ("19:55" or "19:55:30"), at which it is
+	// triggered every day, or a date and time in RFC 3339 format
+	// ("2024-05-01T19:55:00-04:00"), at which it is triggered once.
+	At string `json:"at,omitempty" yaml:"at"`
+
+	// After names the cue after which this cue is triggered, once Delay has
+	// elapsed
+	After string `json:"after,omitempty" yaml:"after"`
+
+	// Delay is the time to wait after the After cue is triggered, such as
+	// "90s" or "2m30s"
+	Delay string `json:"delay,omitempty" yaml:"delay"`
+}
+
+// timeOfDayFormats are the accepted formats of a daily Schedule.At
+var timeOfDayFormats = []string{"15:04", "15:04:05"}
+
+// Next returns the first time at or after the given time at which the
+// schedule's At triggers its cue.  It returns false if the schedule has no At,
+// if it is invalid, or if it is a single time which has passed.
+func (s *Schedule) Next(now time.Time) (time.Time, bool) {
+	if s == nil || s.At == "" {
+		return time.Time{}, false
+	}
+
+	if t, err := time.Parse(time.RFC3339, s.At); err == nil {
+		return t, !t.Before(now)
+	}
+
+	for _, format := range timeOfDayFormats {
+		tod, err := time.ParseInLocation(format, s.At, now.Location())
+		if err != nil {
+			continue
+		}
+
+		t := time.Date(now.Year(), now.Month(), now.Day(), tod.Hour(), tod.Minute(), tod.Second(), 0, now.Location())
+		if t.Before(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// Daily indicates whether the schedule's At is a time of day, repeated every
+// day
+func (s *Schedule) Daily() bool {
+	if s == nil {
+		return false
+	}
+
+	for _, format := range timeOfDayFormats {
+		if _, err := time.Parse(format, s.At); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DelayDuration returns the schedule's Delay
+func (s *Schedule) DelayDuration() (time.Duration, error) {
+	if s == nil || s.Delay == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s.Delay)
+	if err != nil {
+		return 0, fmt.Errorf("invalid delay %q; use a duration such as \"90s\" or \"2m30s\"", s.Delay)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("delay %q is negative", s.Delay)
+	}
+
+	return d, nil
 }
 
 // ID returns a unique hex ID for the cue
@@ -216,26 +317,20 @@ type Room struct {
 	Dimensions Dimensions `json:"dimensions" yaml:"dimensions"`
 }
 
-func (r *Room) generateIDs(a *Agenda) error {
-	err := r.generateID()
-	if err != nil {
-		return err
+func (r *Room) generateIDs(a *Agenda, p path, report *Report) {
+	if err := r.generateID(); err != nil {
+		report.add(SeverityError, p, fmt.Errorf("failed to generate room %s: %w", r.Name, err))
 	}
 
-	for _, p := range r.PointsOfInterest {
-		if err := p.generateID(); err != nil {
-			return fmt.Errorf("failed to generate ID for point of interest: %w", err)
+	for i, poi := range r.PointsOfInterest {
+		if err := poi.generateID(); err != nil {
+			report.add(SeverityError, p.key("pointsOfInterest").index(i), fmt.Errorf("failed to generate ID for point of interest: %w", err))
 		}
 	}
 
-	for _, s := range r.Sources {
-		err = s.generateIDs(a)
-		if err != nil {
-			return err
-		}
+	for i, s := range r.Sources {
+		s.generateIDs(a, p.key("sources").index(i), report)
 	}
-
-	return nil
 }
 
 func (r *Room) populateDefaults() {
@@ -371,9 +466,9 @@ type Source struct {
 	Tracks []*Track `json:"tracks" yaml:"tracks"`
 }
 
-func (s *Source) generateIDs(a *Agenda) error {
+func (s *Source) generateIDs(a *Agenda, p path, report *Report) {
 	if err := s.generateID(); err != nil {
-		return err
+		report.add(SeverityError, p, err)
 	}
 
 	if s.AutoTracks != nil {
@@ -394,8 +489,10 @@ func (s *Source) generateIDs(a *Agenda) error {
 					continue
 				}
 
-				return fmt.Errorf("failed to generate track %s for cue %s in source %s: %w",
-					t.AudioFilePrefix, t.Cue, s.Name, err)
+				report.add(SeverityError, p.key("autoTracks"), fmt.Errorf("failed to generate track %s for cue %s in source %s: %w",
+					t.AudioFilePrefix, t.Cue, s.Name, err))
+
+				continue
 			}
 
 			autoTracks = append(autoTracks, t)
@@ -403,16 +500,14 @@ func (s *Source) generateIDs(a *Agenda) error {
 
 		s.Tracks = autoTracks
 
-		return nil
+		return
 	}
 
-	for _, t := range s.Tracks {
+	for i, t := range s.Tracks {
 		if err := t.generateID(a); err != nil {
-			return err
+			report.add(SeverityError, p.key("tracks").index(i), err)
 		}
 	}
-
-	return nil
 }
 
 // Track represents a single set of potentially-cued audio files
@@ -464,7 +559,7 @@ func (t *Track) generateID(a *Agenda) error {
 
 	// Calculate AudioFiles from prefix, if we are given one
 	if t.AudioFilePrefix != "" {
-		for _, f := range fileFormats {
+		for _, f := range a.fileFormats() {
 			t.AudioFiles = append(t.AudioFiles, fmt.Sprintf("%s/%s", a.MediaBaseURL, fmt.Sprintf("%s.%s", strings.TrimSuffix(t.AudioFilePrefix, "."), f)))
 		}
 	}
@@ -475,24 +570,31 @@ func (t *Track) generateID(a *Agenda) error {
 
 	// TODO: attempt to generate required files if they are missing
 
+	t.ID = hashString(fmt.Sprintf("audio-%s", t.AudioFiles[0]))
+
 	// Validate each of the referenced audio files
 	if !a.RemoteMedia {
+		var errs []error
 		for _, fn := range t.AudioFiles {
-			f, err := os.Open(strings.TrimPrefix(fn, "/"))
-			if err != nil {
-				return fmt.Errorf("failed to open track audio file %s: %w", fn, err)
-			}
-			fInfo, err := f.Stat()
-			if err != nil {
-				return fmt.Errorf("failed to stat audio file %s: %w", fn, err)
-			}
-			if fInfo.Size() == 0 {
-				return fmt.Errorf("track audio file %s has no data: %w", fn, err)
+			if err := a.checkAudioFile(fn); err != nil {
+				errs = append(errs, err)
 			}
 		}
+
+		return errors.Join(errs...)
 	}
 
-	t.ID = hashString(fmt.Sprintf("audio-%s", t.AudioFiles[0]))
+	return nil
+}
+
+func (a *Agenda) checkAudioFile(fn string) error {
+	fInfo, err := os.Stat(filepath.Join(a.dir, strings.TrimPrefix(fn, "/")))
+	if err != nil {
+		return fmt.Errorf("failed to stat track audio file %s: %w", fn, err)
+	}
+	if fInfo.Size() == 0 {
+		return fmt.Errorf("track audio file %s has no data", fn)
+	}
 
 	return nil
 }
@@ -0,0 +1,622 @@
+package agenda
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Severity indicates how serious a Problem is
+type Severity string
+
+const (
+	// SeverityError indicates a problem which prevents the agenda from being
+	// used.
+	SeverityError Severity = "error"
+
+	// SeverityWarning indicates a problem which is likely a mistake but which
+	// does not prevent the agenda from being used.
+	SeverityWarning Severity = "warning"
+)
+
+// validSurfaces is the documented set of surface materials for a Room
+var validSurfaces = []string{"brick-bare", "curtain-heavy", "marble", "glass-thin", "grass", "transparent"}
+
+// Problem describes a single issue found while validating an agenda
+type Problem struct {
+
+	// Severity indicates how serious the problem is
+	Severity Severity `json:"severity"`
+
+	// Path is the location of the problem within the agenda, such as
+	// `rooms[0].sources[1].tracks[2].cue`
+	Path string `json:"path,omitempty"`
+
+	// Line is the line number of the YAML node at which the problem was
+	// found.  It is zero if the location is unknown.
+	Line int `json:"line,omitempty"`
+
+	// Column is the column number of the YAML node at which the problem was
+	// found.  It is zero if the location is unknown.
+	Column int `json:"column,omitempty"`
+
+	// Message describes the problem
+	Message string `json:"message"`
+
+	path path
+}
+
+// String returns the problem in the conventional `line:column: severity: message` form
+func (p *Problem) String() string {
+	return p.Describe("")
+}
+
+// Describe returns the problem in the conventional
+// `file:line:column: severity: message` form
+func (p *Problem) Describe(file string) string {
+	loc := file
+	if p.Line > 0 {
+		loc = strings.TrimPrefix(fmt.Sprintf("%s:%d:%d", file, p.Line, p.Column), ":")
+	}
+	if loc != "" {
+		loc += ": "
+	}
+
+	var where string
+	if p.Path != "" {
+		where = fmt.Sprintf(" (%s)", p.Path)
+	}
+
+	return fmt.Sprintf("%s%s: %s%s", loc, p.Severity, p.Message, where)
+}
+
+// Report collects every Problem found while validating an agenda.  It
+// implements the error interface so that it may be returned by New.
+type Report struct {
+
+	// File is the name of the agenda file which was validated
+	File string `json:"file"`
+
+	// Problems is the list of problems found, in document order
+	Problems []*Problem `json:"problems"`
+}
+
+// HasErrors indicates whether the report contains any problems of
+// SeverityError.
+func (r *Report) HasErrors() bool {
+	if r == nil {
+		return false
+	}
+
+	for _, p := range r.Problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Warnings returns the problems of SeverityWarning
+func (r *Report) Warnings() (out []*Problem) {
+	for _, p := range r.Problems {
+		if p.Severity == SeverityWarning {
+			out = append(out, p)
+		}
+	}
+
+	return
+}
+
+// Error implements error, listing every problem in the report, one per line.
+func (r *Report) Error() string {
+	lines := make([]string, 0, len(r.Problems))
+	for _, p := range r.Problems {
+		lines = append(lines, p.Describe(r.File))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// add records an error as a problem at the given path.  Joined errors are
+// recorded as separate problems.
+func (r *Report) add(severity Severity, p path, err error) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			r.add(severity, p, e)
+		}
+
+		return
+	}
+
+	r.Problems = append(r.Problems, &Problem{
+		Severity: severity,
+		Path:     p.String(),
+		Message:  err.Error(),
+		path:     p,
+	})
+}
+
+func (r *Report) errorf(p path, format string, args ...interface{}) {
+	r.add(SeverityError, p, fmt.Errorf(format, args...))
+}
+
+func (r *Report) warnf(p path, format string, args ...interface{}) {
+	r.add(SeverityWarning, p, fmt.Errorf(format, args...))
+}
+
+// locate fills in the line and column of each problem from the given YAML
+// document and sorts the problems into document order.
+func (r *Report) locate(root *yaml.Node) {
+	for _, p := range r.Problems {
+		if p.Line > 0 || len(p.path) < 1 {
+			continue
+		}
+
+		if n := p.path.node(root); n != nil {
+			p.Line = n.Line
+			p.Column = n.Column
+		}
+	}
+
+	slices.SortStableFunc(r.Problems, func(a, b *Problem) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+}
+
+// path identifies a location within the agenda document.  Each element is
+// either a mapping key or a bracketed sequence index, such as "[2]".
+type path []string
+
+func (p path) key(k string) path {
+	return append(p[:len(p):len(p)], k)
+}
+
+func (p path) index(i int) path {
+	return append(p[:len(p):len(p)], fmt.Sprintf("[%d]", i))
+}
+
+// String returns the path in the form `rooms[0].sources[1].tracks[2].cue`
+func (p path) String() string {
+	var sb strings.Builder
+	for _, elem := range p {
+		if sb.Len() > 0 && !strings.HasPrefix(elem, "[") {
+			sb.WriteString(".")
+		}
+		sb.WriteString(elem)
+	}
+
+	return sb.String()
+}
+
+// node returns the YAML node at the path, or the deepest node along the path
+// if the full path does not exist in the document.
+func (p path) node(root *yaml.Node) *yaml.Node {
+	n := resolve(root)
+	if n == nil {
+		return nil
+	}
+
+	for _, elem := range p {
+		var next *yaml.Node
+
+		switch n.Kind {
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(strings.Trim(elem, "[]"))
+			if err == nil && i >= 0 && i < len(n.Content) {
+				next = n.Content[i]
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == elem {
+					next = n.Content[i+1]
+					break
+				}
+			}
+		}
+
+		if next = resolve(next); next == nil {
+			return n
+		}
+		n = next
+	}
+
+	return n
+}
+
+// resolve unwraps document and alias nodes
+func resolve(n *yaml.Node) *yaml.Node {
+	for n != nil {
+		switch n.Kind {
+		case yaml.DocumentNode:
+			if len(n.Content) < 1 {
+				return nil
+			}
+			n = n.Content[0]
+		case yaml.AliasNode:
+			n = n.Alias
+		default:
+			return n
+		}
+	}
+
+	return nil
+}
+
+// Validate loads the agenda from the given file and checks it thoroughly,
+// collecting every problem found rather than stopping at the first.  The
+// returned Agenda is nil if the file could not be read or parsed at all.
+func Validate(filename string) (*Agenda, *Report) {
+	report := &Report{File: filename}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		report.errorf(nil, "failed to read agenda from file: %w", err)
+		return nil, report
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		report.errorf(nil, "failed to read YAML: %w", err)
+		return nil, report
+	}
+
+	a := &Agenda{
+		dir: filepath.Dir(filename),
+	}
+
+	if err := root.Decode(a); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			report.errorf(nil, "failed to read YAML: %w", err)
+			return nil, report
+		}
+
+		for _, msg := range typeErr.Errors {
+			report.errorf(nil, "%s", msg)
+		}
+	}
+
+	checkFields(&root, reflect.TypeOf(a).Elem(), nil, report)
+
+	a.prepare(report)
+
+	a.check(report)
+
+	report.locate(&root)
+
+	return a, report
+}
+
+// checkFields walks the YAML document alongside the Go type into which it is
+// decoded, reporting any mapping keys which do not correspond to a field.
+func checkFields(n *yaml.Node, t reflect.Type, p path, report *Report) {
+	n = resolve(n)
+	if n == nil {
+		return
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, elem := range n.Content {
+			checkFields(elem, t.Elem(), p.index(i), report)
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			checkFields(n.Content[i+1], t.Elem(), p.key(n.Content[i].Value), report)
+		}
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+
+		fields := yamlFields(t)
+
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+
+			if key == "<<" {
+				checkFields(n.Content[i+1], t, p, report)
+				continue
+			}
+
+			ft, ok := fields[key]
+			if !ok {
+				report.Problems = append(report.Problems, &Problem{
+					Severity: SeverityError,
+					Path:     p.key(key).String(),
+					Line:     n.Content[i].Line,
+					Column:   n.Content[i].Column,
+					Message:  unknownFieldMessage(key, t, fields),
+				})
+				continue
+			}
+
+			checkFields(n.Content[i+1], ft, p.key(key), report)
+		}
+	}
+}
+
+// yamlFields returns the set of YAML keys accepted by the given struct type,
+// following the same naming rules as the YAML decoder.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // unexported
+		}
+
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts, _ := strings.Cut(tag, ",")
+
+		if strings.Contains(opts, "inline") {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			for k, v := range yamlFields(ft) {
+				fields[k] = v
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		fields[name] = f.Type
+	}
+
+	return fields
+}
+
+func unknownFieldMessage(key string, t reflect.Type, fields map[string]reflect.Type) string {
+	msg := fmt.Sprintf("unknown field %q in %s", key, strings.ToLower(t.Name()))
+
+	// Suggest a known field which differs only in case or word order
+	normalized := sortedLetters(key)
+	for known := range fields {
+		if sortedLetters(known) == normalized {
+			return fmt.Sprintf("%s; did you mean %q?", msg, known)
+		}
+	}
+
+	return msg
+}
+
+func sortedLetters(s string) string {
+	letters := []rune(strings.ToLower(s))
+	slices.Sort(letters)
+	return string(letters)
+}
+
+// check performs the cross-reference checks of the agenda, which can only be
+// done once all of its elements have been loaded.
+func (a *Agenda) check(report *Report) {
+	cueIDs := make(map[string]int)
+	cueData := make(map[string]int)
+	cueNames := make(map[string]*Cue)
+	for i, c := range a.Cues {
+		p := path{"cues"}.index(i)
+
+		if prev, ok := cueIDs[c.ID]; ok {
+			report.errorf(p.key("name"), "cue %q has the same ID as cues[%d]; cue names must be unique", c.Name, prev)
+		} else {
+			cueIDs[c.ID] = i
+		}
+
+		if c.Data == "" {
+			report.warnf(p, "cue %q has no data, so it cannot be received from QLab", c.Name)
+		} else if prev, ok := cueData[c.Data]; ok {
+			report.warnf(p.key("data"), "cue %q has the same data as cues[%d]; receiving %q cannot distinguish them", c.Name, prev, c.Data)
+		} else {
+			cueData[c.Data] = i
+		}
+
+		cueNames[c.Name] = c
+	}
+
+	for i, c := range a.Cues {
+		if c.Schedule == nil {
+			continue
+		}
+
+		checkSchedule(c, path{"cues"}.index(i).key("schedule"), cueNames, report)
+	}
+	a.checkScheduleCycles(cueNames, report)
+
+	roomIDs := make(map[string]int)
+	roomNames := make(map[string]bool)
+	for i, r := range a.Rooms {
+		p := path{"rooms"}.index(i)
+
+		if prev, ok := roomIDs[r.ID]; ok {
+			report.errorf(p.key("name"), "room %q has the same ID as rooms[%d]; room names must be unique", r.Name, prev)
+		} else {
+			roomIDs[r.ID] = i
+		}
+		roomNames[r.Name] = true
+
+		for _, surface := range []struct{ side, material string }{
+			{"left", r.Surfaces.Left},
+			{"right", r.Surfaces.Right},
+			{"front", r.Surfaces.Front},
+			{"back", r.Surfaces.Back},
+			{"down", r.Surfaces.Down},
+			{"up", r.Surfaces.Up},
+		} {
+			if !slices.Contains(validSurfaces, surface.material) {
+				report.errorf(p.key("surfaces").key(surface.side), "unknown surface %q; valid surfaces are %s", surface.material, strings.Join(validSurfaces, ", "))
+			}
+		}
+
+		for j, s := range r.Sources {
+			sp := p.key("sources").index(j)
+
+			if s.AutoTracks != nil {
+				for k, name := range s.AutoTracks.IgnoreCues {
+					if _, ok := cueNames[name]; !ok {
+						report.warnf(sp.key("autoTracks").key("ignorecues").index(k), "ignored cue %q does not exist", name)
+					}
+				}
+				continue
+			}
+
+			for k, t := range s.Tracks {
+				a.checkTrackCues(t, sp.key("tracks").index(k), cueNames, report)
+			}
+		}
+
+		for j, t := range r.RoomTracks {
+			a.checkTrackCues(t, p.key("roomTracks").index(j), cueNames, report)
+
+			// Room tracks are not loaded through generateID, so their
+			// files are checked here instead.
+			if !a.RemoteMedia {
+				for _, fn := range t.AudioFiles {
+					if err := a.checkAudioFile(fn); err != nil {
+						report.add(SeverityWarning, p.key("roomTracks").index(j), err)
+					}
+				}
+			}
+		}
+	}
+
+	for i, ann := range a.Announcements {
+		p := path{"announcements"}.index(i)
+
+		a.checkTrackCues(&ann.Track, p.key("track"), cueNames, report)
+
+		for j, name := range ann.ExcludeRooms {
+			if !roomNames[name] {
+				report.errorf(p.key("excludeRooms").index(j), "excluded room %q does not exist", name)
+			}
+		}
+	}
+}
+
+// checkSchedule checks the schedule of the given cue
+func checkSchedule(c *Cue, p path, cueNames map[string]*Cue, report *Report) {
+	s := c.Schedule
+
+	switch {
+	case s.At == "" && s.After == "":
+		report.errorf(p, "schedule of cue %q needs either at or after", c.Name)
+	case s.At != "" && s.After != "":
+		report.errorf(p, "schedule of cue %q has both at and after; choose one", c.Name)
+	}
+
+	if s.At != "" && !s.Daily() {
+		if _, err := time.Parse(time.RFC3339, s.At); err != nil {
+			report.errorf(p.key("at"), "invalid schedule time %q; use a time of day such as \"19:55\" or a date and time such as \"2024-05-01T19:55:00-04:00\"", s.At)
+		}
+	}
+
+	if s.After != "" {
+		if _, ok := cueNames[s.After]; !ok {
+			report.errorf(p.key("after"), "cue %q is scheduled after cue %q, which does not exist", c.Name, s.After)
+		} else if s.After == c.Name {
+			report.errorf(p.key("after"), "cue %q is scheduled after itself", c.Name)
+		}
+	}
+
+	if _, err := s.DelayDuration(); err != nil {
+		report.add(SeverityError, p.key("delay"), err)
+	}
+	if s.Delay != "" && s.After == "" {
+		report.errorf(p.key("delay"), "schedule of cue %q has a delay but no after cue", c.Name)
+	}
+}
+
+// checkScheduleCycles reports each cycle of cues scheduled after one another,
+// such as a cue "a" after "b" and "b" after "a", which would trigger each other
+// forever once either was triggered.  A cue scheduled after itself is reported
+// by checkSchedule.
+func (a *Agenda) checkScheduleCycles(cueNames map[string]*Cue, report *Report) {
+	after := func(c *Cue) *Cue {
+		if c.Schedule == nil || c.Schedule.After == "" || c.Schedule.After == c.Name {
+			return nil
+		}
+		return cueNames[c.Schedule.After]
+	}
+
+	reported := make(map[*Cue]bool)
+	for i, c := range a.Cues {
+		if reported[c] {
+			continue
+		}
+
+		// Each cue is scheduled after at most one other, so following them
+		// from the cue either ends, returns to it, or enters a cycle which
+		// does not include it within as many steps as there are cues.
+		var chain []*Cue
+		for next := after(c); next != nil && len(chain) < len(a.Cues); next = after(next) {
+			if next != c {
+				chain = append(chain, next)
+				continue
+			}
+
+			names := make([]string, 0, len(chain))
+			for _, other := range chain {
+				names = append(names, strconv.Quote(other.Name))
+				reported[other] = true
+			}
+			report.errorf(path{"cues"}.index(i).key("schedule").key("after"), "cue %q is scheduled after itself by way of %s", c.Name, strings.Join(names, ", "))
+			break
+		}
+	}
+}
+
+// checkTrackCues checks that each cue referenced by a track exists.  Because
+// the performance timeline records cues by the data received, a reference
+// which matches only a cue's name (and not its data) is flagged as well.
+func (a *Agenda) checkTrackCues(t *Track, p path, cueNames map[string]*Cue, report *Report) {
+	for _, r := range []struct{ key, ref string }{
+		{"cue", t.Cue},
+		{"loadCue", t.LoadCue},
+		{"killCue", t.KillCue},
+	} {
+		key, ref := r.key, r.ref
+		if ref == "" {
+			continue
+		}
+
+		if slices.ContainsFunc(a.Cues, func(c *Cue) bool { return c.Data == ref }) {
+			continue
+		}
+
+		if c, ok := cueNames[ref]; ok {
+			report.warnf(p.key(key), "%s %q names a cue whose data is %q; the track will only respond to the data received", key, ref, c.Data)
+			continue
+		}
+
+		report.errorf(p.key(key), "%s %q does not match any cue", key, ref)
+	}
+}
@@ -0,0 +1,252 @@
+package agenda
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// problem is an expected Problem: its severity and path, and a part of its
+// message
+type problem struct {
+	severity Severity
+	path     string
+	message  string
+}
+
+// validateYAML validates the given agenda document
+func validateYAML(t *testing.T, doc string) *Report {
+	t.Helper()
+
+	fn := filepath.Join(t.TempDir(), "agenda.yaml")
+	if err := os.WriteFile(fn, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, report := Validate(fn)
+
+	return report
+}
+
+// checkProblems fails the test unless the report has exactly the expected
+// problems, in order
+func checkProblems(t *testing.T, report *Report, want []problem) {
+	t.Helper()
+
+	if len(report.Problems) != len(want) {
+		t.Fatalf("got %d problems, want %d:\n%s", len(report.Problems), len(want), report.Error())
+	}
+
+	for i, w := range want {
+		p := report.Problems[i]
+		if p.Severity != w.severity || p.Path != w.path || !strings.Contains(p.Message, w.message) {
+			t.Errorf("problem %d is %q, want %s at %q containing %q", i, p.String(), w.severity, w.path, w.message)
+		}
+	}
+}
+
+func TestCheckFields(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []problem
+	}{
+		{
+			name: "known fields",
+			doc:  "title: Show\ncues:\n  - name: intro\n    data: blah\n",
+		},
+		{
+			name: "unknown top-level field",
+			doc:  "title: Show\nvenue: Hall\n",
+			want: []problem{{SeverityError, "venue", `unknown field "venue" in agenda`}},
+		},
+		{
+			name: "misspelled field",
+			doc:  "titel: Show\n",
+			want: []problem{{SeverityError, "titel", `did you mean "title"?`}},
+		},
+		{
+			name: "miscased field",
+			doc:  "performanceurl: https://example.com/live\n",
+			want: []problem{{SeverityError, "performanceurl", `did you mean "performanceURL"?`}},
+		},
+		{
+			name: "unknown field of a cue",
+			doc:  "cues:\n  - name: intro\n    data: blah\n  - nmae: outro\n    data: bye\n",
+			want: []problem{
+				{SeverityError, "cues[1].nmae", `did you mean "name"?`},
+				{SeverityWarning, "cues[1]", "cue has no name"},
+			},
+		},
+		{
+			name: "unknown field of a schedule",
+			doc:  "cues:\n  - name: intro\n    data: blah\n    schedule:\n      at: \"19:55\"\n      every: day\n",
+			want: []problem{{SeverityError, "cues[0].schedule.every", `unknown field "every" in schedule`}},
+		},
+		{
+			name: "merged fields",
+			doc:  "cues:\n  - &intro\n    name: intro\n    data: blah\n  - <<: *intro\n    name: outro\n    data: bye\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkProblems(t, validateYAML(t, tt.doc), tt.want)
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []problem
+	}{
+		{
+			name: "distinct cues",
+			doc:  "cues:\n  - name: intro\n    data: blah\n  - name: outro\n    data: bye\n",
+		},
+		{
+			name: "duplicate cue names",
+			doc:  "cues:\n  - name: intro\n    data: blah\n  - name: intro\n    data: bye\n",
+			want: []problem{{SeverityError, "cues[1].name", "has the same ID as cues[0]"}},
+		},
+		{
+			name: "cue without data",
+			doc:  "cues:\n  - name: intro\n",
+			want: []problem{{SeverityWarning, "cues[0]", "has no data"}},
+		},
+		{
+			name: "duplicate cue data",
+			doc:  "cues:\n  - name: intro\n    data: blah\n  - name: outro\n    data: blah\n",
+			want: []problem{{SeverityWarning, "cues[1].data", "has the same data as cues[0]"}},
+		},
+		{
+			name: "invalid schedule",
+			doc:  "cues:\n  - name: intro\n    data: blah\n    schedule:\n      after: overture\n",
+			want: []problem{{SeverityError, "cues[0].schedule.after", `cue "overture", which does not exist`}},
+		},
+		{
+			name: "schedule cycle",
+			doc:  "cues:\n  - name: a\n    data: \"1\"\n    schedule:\n      after: b\n  - name: b\n    data: \"2\"\n    schedule:\n      after: a\n",
+			want: []problem{{SeverityError, "cues[0].schedule.after", `cue "a" is scheduled after itself by way of "b"`}},
+		},
+		{
+			name: "longer schedule cycle",
+			doc:  "cues:\n  - name: a\n    data: \"1\"\n    schedule:\n      after: c\n  - name: b\n    data: \"2\"\n    schedule:\n      after: a\n  - name: c\n    data: \"3\"\n    schedule:\n      after: b\n",
+			want: []problem{{SeverityError, "cues[0].schedule.after", `cue "a" is scheduled after itself by way of "c", "b"`}},
+		},
+		{
+			name: "schedule leading into a cycle",
+			doc:  "cues:\n  - name: x\n    data: \"0\"\n    schedule:\n      after: a\n  - name: a\n    data: \"1\"\n    schedule:\n      after: b\n  - name: b\n    data: \"2\"\n    schedule:\n      after: a\n",
+			want: []problem{{SeverityError, "cues[1].schedule.after", `cue "a" is scheduled after itself by way of "b"`}},
+		},
+		{
+			name: "schedule chain",
+			doc:  "cues:\n  - name: a\n    data: \"1\"\n  - name: b\n    data: \"2\"\n    schedule:\n      after: a\n  - name: c\n    data: \"3\"\n    schedule:\n      after: b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkProblems(t, validateYAML(t, tt.doc), tt.want)
+		})
+	}
+}
+
+func TestCheckScheduleCyclePosition(t *testing.T) {
+	doc := "cues:\n  - name: a\n    data: \"1\"\n    schedule:\n      after: b\n  - name: b\n    data: \"2\"\n    schedule:\n      after: a\n"
+
+	report := validateYAML(t, doc)
+	checkProblems(t, report, []problem{{SeverityError, "cues[0].schedule.after", "scheduled after itself"}})
+
+	// The cycle is reported at the after of the first cue in it
+	if p := report.Problems[0]; p.Line != 5 || p.Column != 14 {
+		t.Errorf("got problem at %d:%d, want 5:14", p.Line, p.Column)
+	}
+}
+
+func TestCheckSchedule(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		want     []problem
+	}{
+		{
+			name:     "time of day",
+			schedule: Schedule{At: "19:55"},
+		},
+		{
+			name:     "time of day with seconds",
+			schedule: Schedule{At: "19:55:30"},
+		},
+		{
+			name:     "date and time",
+			schedule: Schedule{At: "2024-05-01T19:55:00-04:00"},
+		},
+		{
+			name:     "after another cue",
+			schedule: Schedule{After: "intro", Delay: "90s"},
+		},
+		{
+			name:     "after another cue at once",
+			schedule: Schedule{After: "intro"},
+		},
+		{
+			name: "neither at nor after",
+			want: []problem{{SeverityError, "schedule", "needs either at or after"}},
+		},
+		{
+			name:     "both at and after",
+			schedule: Schedule{At: "19:55", After: "intro"},
+			want:     []problem{{SeverityError, "schedule", "has both at and after"}},
+		},
+		{
+			name:     "invalid time",
+			schedule: Schedule{At: "7pm"},
+			want:     []problem{{SeverityError, "schedule.at", `invalid schedule time "7pm"`}},
+		},
+		{
+			name:     "after a missing cue",
+			schedule: Schedule{After: "overture"},
+			want:     []problem{{SeverityError, "schedule.after", "which does not exist"}},
+		},
+		{
+			name:     "after itself",
+			schedule: Schedule{After: "outro"},
+			want:     []problem{{SeverityError, "schedule.after", "scheduled after itself"}},
+		},
+		{
+			name:     "invalid delay",
+			schedule: Schedule{After: "intro", Delay: "soon"},
+			want:     []problem{{SeverityError, "schedule.delay", `invalid delay "soon"`}},
+		},
+		{
+			name:     "negative delay",
+			schedule: Schedule{After: "intro", Delay: "-5s"},
+			want:     []problem{{SeverityError, "schedule.delay", "is negative"}},
+		},
+		{
+			name:     "delay without after",
+			schedule: Schedule{At: "19:55", Delay: "5s"},
+			want:     []problem{{SeverityError, "schedule.delay", "has a delay but no after cue"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule := tt.schedule
+			c := &Cue{Name: "outro", Schedule: &schedule}
+			cueNames := map[string]*Cue{
+				"intro": {Name: "intro"},
+				"outro": c,
+			}
+
+			report := new(Report)
+			checkSchedule(c, path{"schedule"}, cueNames, report)
+
+			checkProblems(t, report, tt.want)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AuditEntry is a single record of the audit log
+type AuditEntry struct {
+
+	// Time is the time at which the action occurred
+	Time time.Time `json:"time"`
+
+	// User identifies who performed the action
+	User string `json:"user"`
+
+	// Remote is the address from which the action was requested
+	Remote string `json:"remote"`
+
+	// Action names the action, such as "login" or "trigger"
+	Action string `json:"action"`
+
+	// Detail describes the subject of the action, such as the cue triggered
+	Detail string `json:"detail,omitempty"`
+}
+
+// AuditLog records who performed which administrative actions, as JSON lines.
+// A nil AuditLog discards all records.
+type AuditLog struct {
+	w io.Writer
+
+	mu sync.Mutex
+}
+
+// NewAuditLog opens (or creates) the given file for appending audit records
+func NewAuditLog(filename string) (*AuditLog, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+
+	return &AuditLog{w: f}, nil
+}
+
+// Record adds an entry to the audit log for the authenticated user of the
+// given request.
+func (l *AuditLog) Record(c echo.Context, action, detail string) error {
+	return l.write(&AuditEntry{
+		Time:   time.Now(),
+		User:   Identity(c),
+		Remote: c.RealIP(),
+		Action: action,
+		Detail: detail,
+	})
+}
+
+func (l *AuditLog) write(entry *AuditEntry) error {
+	if l == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// tokenBytes is the number of random bytes in a generated API token
+const tokenBytes = 32
+
+// dummyHash is compared against when an unknown user attempts to log in, so
+// that unknown users take as long to reject as known ones.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("audimance"), bcrypt.DefaultCost) //nolint: errcheck
+
+// ErrNotExist indicates that the users file does not exist
+var ErrNotExist = errors.New("users file does not exist")
+
+// Store describes the set of users and API tokens which may access the
+// protected parts of Audimance.  It is generally loaded from a `users.yaml`
+// file alongside the agenda.
+type Store struct {
+
+	// Users are the people who may log in to the administrative console
+	Users []*User `yaml:"users"`
+
+	// Tokens are the bearer tokens which may be used by scripts and tools
+	// (such as autotrigger) to access the API.
+	Tokens []*Token `yaml:"tokens"`
+
+	// Roles maps role names to the permissions they grant.  These extend
+	// (and, for the same name, replace) the DefaultRoles.
+	Roles map[string][]Permission `yaml:"roles,omitempty"`
+}
+
+// User describes a person who may log in
+type User struct {
+
+	// Name is the unique login name of the user
+	Name string `yaml:"name"`
+
+	// PasswordHash is the bcrypt hash of the user's password
+	PasswordHash string `yaml:"passwordHash"`
+
+	// Role is the name of the role of the user, which determines what the
+	// user may do.  If empty, the user has the DefaultRole, and may only view.
+	Role string `yaml:"role,omitempty"`
+}
+
+// Token describes an API bearer token
+type Token struct {
+
+	// Name is the unique, human-friendly name of the token, which identifies
+	// its user in the audit log.
+	Name string `yaml:"name"`
+
+	// Hash is the hex-encoded SHA-256 hash of the token.  The token itself is
+	// only shown once, when it is created.
+	Hash string `yaml:"hash"`
+
+	// Role is the name of the role of the token, which determines what its
+	// bearer may do.  If empty, the token has the DefaultRole, and may only
+	// view.
+	Role string `yaml:"role,omitempty"`
+}
+
+// Load reads the Store from the given file.  If the file does not exist,
+// ErrNotExist is returned.
+func Load(filename string) (*Store, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users file: %w", err)
+	}
+
+	s := new(Store)
+
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to read YAML: %w", err)
+	}
+
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid users file: %w", err)
+	}
+
+	return s, nil
+}
+
+// Save writes the Store to the given file
+func (s *Store) Save(filename string) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode users: %w", err)
+	}
+
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write users file: %w", err)
+	}
+
+	return nil
+}
+
+// User returns the named user, or nil if there is no such user
+func (s *Store) User(name string) *User {
+	for _, u := range s.Users {
+		if u.Name == name {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// SetRole sets the role of the named user
+func (s *Store) SetRole(name, role string) error {
+	u := s.User(name)
+	if u == nil {
+		return fmt.Errorf("no such user %q", name)
+	}
+
+	if !s.hasRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	u.Role = role
+
+	return nil
+}
+
+// SetPassword sets the password of the named user, adding the user if it
+// does not already exist.
+func (s *Store) SetPassword(name, password string) error {
+	if name == "" {
+		return errors.New("user name must not be empty")
+	}
+	if password == "" {
+		return errors.New("password must not be empty")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	u := s.User(name)
+	if u == nil {
+		u = &User{Name: name}
+		s.Users = append(s.Users, u)
+	}
+
+	u.PasswordHash = string(hash)
+
+	return nil
+}
+
+// NewToken generates a new API token with the given name and role, replacing
+// any existing token of that name.  The returned token is not stored and
+// cannot be recovered later.
+func (s *Store) NewToken(name, role string) (string, error) {
+	if name == "" {
+		return "", errors.New("token name must not be empty")
+	}
+
+	if !s.hasRole(role) {
+		return "", fmt.Errorf("unknown role %q", role)
+	}
+
+	buf := make([]byte, tokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	var t *Token
+	for _, existing := range s.Tokens {
+		if existing.Name == name {
+			t = existing
+		}
+	}
+	if t == nil {
+		t = &Token{Name: name}
+		s.Tokens = append(s.Tokens, t)
+	}
+
+	t.Hash = hashToken(token)
+	t.Role = role
+
+	return token, nil
+}
+
+// Authenticate checks the given user name and password, returning the user
+// if they are valid.
+func (s *Store) Authenticate(name, password string) (*User, bool) {
+	u := s.User(name)
+	if u == nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password)) //nolint: errcheck
+		return nil, false
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return nil, false
+	}
+
+	return u, true
+}
+
+// AuthenticateToken checks the given bearer token, returning the matching
+// Token if it is valid.
+func (s *Store) AuthenticateToken(token string) (*Token, bool) {
+	hash := []byte(hashToken(token))
+
+	for _, t := range s.Tokens {
+		if subtle.ConstantTimeCompare(hash, []byte(t.Hash)) == 1 {
+			return t, true
+		}
+	}
+
+	return nil, false
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,262 @@
+package auth
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// SessionCookie is the name of the cookie which holds the browser session ID
+const SessionCookie = "audimance_session"
+
+// Anonymous is the identity of requests made when authentication is disabled
+const Anonymous = "anonymous"
+
+// identityKey is the echo context key under which the identity of the
+// authenticated user is stored
+const identityKey = "audimance.identity"
+
+// roleKey is the echo context key under which the role of the authenticated
+// user is stored
+const roleKey = "audimance.role"
+
+// TokenIdentityPrefix prefixes the names of API tokens when they are used as
+// identities, to distinguish them from users.
+const TokenIdentityPrefix = "token:"
+
+var metricDenied *prometheus.CounterVec
+
+func init() {
+	metricDenied = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audimance_auth_denied_total",
+		Help: "Total number of requests denied for lack of authentication or permission",
+	}, []string{"permission", "reason"})
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1">
+	<title>Audimance - Log In</title>
+</head>
+<body>
+	<main>
+		<h1>Audimance</h1>
+		{{if .Failed}}<p role="alert">Incorrect user name or password.</p>{{end}}
+		<form method="post" action="/login">
+			<input type="hidden" name="next" value="{{.Next}}">
+			<p><label for="name">User name</label> <input id="name" name="name" autocomplete="username" required autofocus></p>
+			<p><label for="password">Password</label> <input id="password" name="password" type="password" autocomplete="current-password" required></p>
+			<p><button type="submit">Log in</button></p>
+		</form>
+	</main>
+</body>
+</html>
+`))
+
+// Authenticator protects routes, accepting either a browser session (see
+// Login) or an API bearer token.  If it has no Store, authentication is
+// disabled and every request is permitted.
+type Authenticator struct {
+
+	// Store is the set of users and tokens which may authenticate
+	Store *Store
+
+	// Sessions tracks the logged-in browser sessions
+	Sessions *Sessions
+
+	// Audit records logins and other actions
+	Audit *AuditLog
+
+	// Home is the page to which users are sent after logging in, if they did
+	// not ask for another.  The default is /admin.
+	Home string
+}
+
+// Enabled indicates whether authentication is required
+func (a *Authenticator) Enabled() bool {
+	return a != nil && a.Store != nil
+}
+
+// Identity returns the identity of the authenticated user of the request:
+// a user name, an API token name prefixed by TokenIdentityPrefix, or
+// Anonymous.
+func Identity(c echo.Context) string {
+	if id, ok := c.Get(identityKey).(string); ok {
+		return id
+	}
+
+	return Anonymous
+}
+
+// Role returns the role of the authenticated user of the request.  It is
+// empty if authentication is disabled.
+func Role(c echo.Context) string {
+	role, _ := c.Get(roleKey).(string) //nolint: errcheck
+	return role
+}
+
+// Require returns echo middleware which rejects requests which are not
+// authenticated or whose role lacks the given permission.  Browsers
+// requesting pages are redirected to the login page.
+func (a *Authenticator) Require(perm Permission) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !a.Enabled() {
+				return next(c)
+			}
+
+			id, role, ok := a.authenticate(c)
+			if !ok {
+				metricDenied.With(prometheus.Labels{"permission": string(perm), "reason": "unauthenticated"}).Inc()
+
+				if c.Request().Method == http.MethodGet && strings.Contains(c.Request().Header.Get(echo.HeaderAccept), echo.MIMETextHTML) {
+					return c.Redirect(http.StatusSeeOther, "/login?next="+url.QueryEscape(c.Request().RequestURI))
+				}
+
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="audimance"`)
+				return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+			}
+
+			c.Set(identityKey, id)
+			c.Set(roleKey, roleOf(role))
+
+			if !a.Store.Allowed(role, perm) {
+				metricDenied.With(prometheus.Labels{"permission": string(perm), "reason": "forbidden"}).Inc()
+
+				c.Logger().Warnf("denied %s %s to %s (role %s lacks permission %q)", c.Request().Method, c.Request().RequestURI, id, roleOf(role), perm)
+				if err := a.Audit.Record(c, "denied", fmt.Sprintf("%s %s", c.Request().Method, c.Request().RequestURI)); err != nil {
+					c.Logger().Error(err)
+				}
+
+				return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("permission %q required", perm))
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// authenticate returns the identity and role of the user of the request
+func (a *Authenticator) authenticate(c echo.Context) (id string, role string, ok bool) {
+	if bearer, ok := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer "); ok {
+		if t, ok := a.Store.AuthenticateToken(strings.TrimSpace(bearer)); ok {
+			return TokenIdentityPrefix + t.Name, t.Role, true
+		}
+
+		return "", "", false
+	}
+
+	if cookie, err := c.Cookie(SessionCookie); err == nil {
+		if name, ok := a.Sessions.Lookup(cookie.Value); ok {
+			if u := a.Store.User(name); u != nil {
+				return u.Name, u.Role, true
+			}
+		}
+	}
+
+	return "", "", false
+}
+
+// Login serves the login form (GET) and processes login attempts (POST),
+// starting a browser session on success.
+func (a *Authenticator) Login(c echo.Context) error {
+	next := c.FormValue("next")
+	if !localPath(next) {
+		next = a.Home
+		if next == "" {
+			next = "/admin"
+		}
+	}
+
+	if !a.Enabled() {
+		return c.Redirect(http.StatusSeeOther, next)
+	}
+
+	data := struct {
+		Next   string
+		Failed bool
+	}{
+		Next: next,
+	}
+
+	if c.Request().Method != http.MethodPost {
+		return renderLogin(c, http.StatusOK, data)
+	}
+
+	name := c.FormValue("name")
+
+	u, ok := a.Store.Authenticate(name, c.FormValue("password"))
+	if !ok {
+		c.Set(identityKey, name)
+		a.Audit.Record(c, "login-failed", "") //nolint: errcheck
+
+		data.Failed = true
+		return renderLogin(c, http.StatusUnauthorized, data)
+	}
+
+	id, err := a.Sessions.Create(u.Name)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     SessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.IsTLS(),
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	c.Set(identityKey, u.Name)
+	a.Audit.Record(c, "login", "") //nolint: errcheck
+
+	return c.Redirect(http.StatusSeeOther, next)
+}
+
+// localPath indicates whether the given page to go to after logging in is a
+// path on this site.  Browsers treat a backslash as a slash, so "/\evil.com"
+// is another site, as is "//evil.com".
+func localPath(next string) bool {
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil {
+		return false
+	}
+
+	return strings.HasPrefix(next, "/") && !strings.HasPrefix(next, "//") && !strings.HasPrefix(next, "/\\")
+}
+
+// Logout ends the browser session, if there is one
+func (a *Authenticator) Logout(c echo.Context) error {
+	if cookie, err := c.Cookie(SessionCookie); err == nil && a.Enabled() {
+		if user, ok := a.Sessions.Lookup(cookie.Value); ok {
+			c.Set(identityKey, user)
+			a.Audit.Record(c, "logout", "") //nolint: errcheck
+		}
+		a.Sessions.Delete(cookie.Value)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     SessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+func renderLogin(c echo.Context, code int, data interface{}) error {
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMETextHTMLCharsetUTF8)
+	c.Response().WriteHeader(code)
+	return loginPage.Execute(c.Response(), data)
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// newTestAuthenticator returns an authenticator with the stage manager "sm",
+// whose password is "secret", and the tokens "autotrigger", of a stage
+// manager, and "ops", without a role, which it returns in that order
+func newTestAuthenticator(t *testing.T) (*Authenticator, []string) {
+	t.Helper()
+
+	store := new(Store)
+	if err := store.SetPassword("sm", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetRole("sm", "stageManager"); err != nil {
+		t.Fatal(err)
+	}
+
+	var tokens []string
+	for _, name := range []string{"autotrigger", "ops"} {
+		role := ""
+		if name == "autotrigger" {
+			role = "stageManager"
+		}
+
+		token, err := store.NewToken(name, role)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	return &Authenticator{Store: store, Sessions: new(Sessions)}, tokens
+}
+
+// serve runs the given handler for the request, returning the response
+// status and the recorded response
+func serve(h echo.HandlerFunc, req *http.Request) (int, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+
+	err := h(echo.New().NewContext(req, rec))
+
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return he.Code, rec
+	}
+
+	return rec.Code, rec
+}
+
+func TestRequire(t *testing.T) {
+	a, tokens := newTestAuthenticator(t)
+	token := tokens[0]
+
+	session, err := a.Sessions.Create("sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		auth         *Authenticator
+		perm         Permission
+		header       http.Header
+		cookie       string
+		wantCode     int
+		wantIdentity string
+		wantLocation string
+	}{
+		{
+			name:     "no credentials",
+			auth:     a,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "browser without credentials",
+			auth:         a,
+			header:       http.Header{echo.HeaderAccept: {"text/html,application/xhtml+xml"}},
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/login?next=" + url.QueryEscape("/admin?x=1"),
+		},
+		{
+			name:         "token",
+			auth:         a,
+			header:       http.Header{echo.HeaderAuthorization: {"Bearer " + token}},
+			wantCode:     http.StatusOK,
+			wantIdentity: TokenIdentityPrefix + "autotrigger",
+		},
+		{
+			name:     "wrong token",
+			auth:     a,
+			header:   http.Header{echo.HeaderAuthorization: {"Bearer " + token + "x"}},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "wrong token with a session",
+			auth:     a,
+			header:   http.Header{echo.HeaderAuthorization: {"Bearer x"}},
+			cookie:   session,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "session",
+			auth:         a,
+			cookie:       session,
+			wantCode:     http.StatusOK,
+			wantIdentity: "sm",
+		},
+		{
+			name:         "permitted role",
+			auth:         a,
+			perm:         PermTrigger,
+			cookie:       session,
+			wantCode:     http.StatusOK,
+			wantIdentity: "sm",
+		},
+		{
+			name:     "role lacking the permission",
+			auth:     a,
+			perm:     PermEdit,
+			cookie:   session,
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "token role lacking the permission",
+			auth:     a,
+			perm:     PermMix,
+			header:   http.Header{echo.HeaderAuthorization: {"Bearer " + token}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:         "token without a role may view",
+			auth:         a,
+			header:       http.Header{echo.HeaderAuthorization: {"Bearer " + tokens[1]}},
+			wantCode:     http.StatusOK,
+			wantIdentity: TokenIdentityPrefix + "ops",
+		},
+		{
+			name:     "token without a role may not edit",
+			auth:     a,
+			perm:     PermEdit,
+			header:   http.Header{echo.HeaderAuthorization: {"Bearer " + tokens[1]}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "unknown session",
+			auth:     a,
+			cookie:   session + "x",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "disabled",
+			auth:         &Authenticator{},
+			wantCode:     http.StatusOK,
+			wantIdentity: Anonymous,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin?x=1", nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: SessionCookie, Value: tt.cookie})
+			}
+
+			perm := tt.perm
+			if perm == "" {
+				perm = PermView
+			}
+
+			code, rec := serve(tt.auth.Require(perm)(func(c echo.Context) error {
+				return c.String(http.StatusOK, Identity(c))
+			}), req)
+
+			if code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", code, tt.wantCode)
+			}
+			if tt.wantIdentity != "" && rec.Body.String() != tt.wantIdentity {
+				t.Errorf("got identity %q, want %q", rec.Body.String(), tt.wantIdentity)
+			}
+			if loc := rec.Header().Get(echo.HeaderLocation); loc != tt.wantLocation {
+				t.Errorf("got redirect to %q, want %q", loc, tt.wantLocation)
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+
+	tests := []struct {
+		name         string
+		password     string
+		next         string
+		home         string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:     "wrong password",
+			password: "guess",
+			next:     "/admin",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:         "to the page asked for",
+			password:     "secret",
+			next:         "/admin?x=1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin?x=1",
+		},
+		{
+			name:         "to the console by default",
+			password:     "secret",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:         "to the home page by default",
+			password:     "secret",
+			home:         "/main/admin",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/main/admin",
+		},
+		{
+			name:         "not to another site",
+			password:     "secret",
+			next:         "https://example.com/admin",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:         "not to another site without a scheme",
+			password:     "secret",
+			next:         "//example.com/admin",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:         "not to another site by a backslash",
+			password:     "secret",
+			next:         "/\\example.com/admin",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:         "not to another site by a tab",
+			password:     "secret",
+			next:         "/\t/example.com/admin",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+		{
+			name:         "not a relative path",
+			password:     "secret",
+			next:         "admin",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/admin",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.Home = tt.home
+
+			form := url.Values{"name": {"sm"}, "password": {tt.password}, "next": {tt.next}}
+			req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+			code, rec := serve(a.Login, req)
+			if code != tt.wantCode {
+				t.Fatalf("got status %d, want %d", code, tt.wantCode)
+			}
+			if loc := rec.Header().Get(echo.HeaderLocation); loc != tt.wantLocation {
+				t.Errorf("got redirect to %q, want %q", loc, tt.wantLocation)
+			}
+
+			var session string
+			for _, c := range rec.Result().Cookies() {
+				if c.Name == SessionCookie {
+					session = c.Value
+				}
+			}
+
+			user, ok := a.Sessions.Lookup(session)
+			switch {
+			case tt.wantCode == http.StatusSeeOther && (!ok || user != "sm"):
+				t.Errorf("got session for %q, want one for %q", user, "sm")
+			case tt.wantCode != http.StatusSeeOther && session != "":
+				t.Errorf("got a session, want none")
+			}
+		})
+	}
+}
+
+func TestSessions(t *testing.T) {
+	s := new(Sessions)
+
+	id, err := s.Create("sm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := s.Lookup(id); !ok || user != "sm" {
+		t.Errorf("got %q, %v, want %q", user, ok, "sm")
+	}
+
+	s.Delete(id)
+	if _, ok := s.Lookup(id); ok {
+		t.Error("got a session after deleting it")
+	}
+
+	expired := &Sessions{TTL: -time.Second}
+	if id, err = expired.Create("sm"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := expired.Lookup(id); ok {
+		t.Error("got an expired session")
+	}
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Permission names an action which a role may be permitted to perform
+type Permission string
+
+const (
+	// PermView permits viewing the administrative console, cue list, and
+	// audience counts.
+	PermView Permission = "view"
+
+	// PermTrigger permits firing cues and changing the cue history (undo,
+	// rewind, and reset).
+	PermTrigger Permission = "trigger"
+
+	// PermMix permits changing OSC positions and volumes.
+	PermMix Permission = "mix"
+
+	// PermEdit permits changing the agenda.
+	PermEdit Permission = "edit"
+)
+
+// AllPermissions is the list of every Permission
+var AllPermissions = []Permission{PermView, PermTrigger, PermMix, PermEdit}
+
+// AdminRole is the role with every permission
+const AdminRole = "admin"
+
+// DefaultRole is the role of users and tokens which are not assigned one.  It
+// may only view.
+const DefaultRole = "viewer"
+
+// DefaultRoles are the roles available without any configuration.  Roles of
+// the same name in the users file replace these.
+var DefaultRoles = map[string][]Permission{
+	AdminRole:      AllPermissions,
+	"stageManager": {PermView, PermTrigger},
+	"technician":   {PermView, PermMix},
+	DefaultRole:    {PermView},
+}
+
+// roleOf returns the effective role name
+func roleOf(role string) string {
+	if role == "" {
+		return DefaultRole
+	}
+
+	return role
+}
+
+// Permissions returns the permissions of the given role.  An empty role is
+// the DefaultRole.
+func (s *Store) Permissions(role string) []Permission {
+	role = roleOf(role)
+
+	if perms, ok := s.Roles[role]; ok {
+		return perms
+	}
+
+	return DefaultRoles[role]
+}
+
+// Allowed indicates whether the given role has the given permission
+func (s *Store) Allowed(role string, perm Permission) bool {
+	return slices.Contains(s.Permissions(role), perm)
+}
+
+func (s *Store) hasRole(role string) bool {
+	role = roleOf(role)
+
+	_, configured := s.Roles[role]
+	_, builtin := DefaultRoles[role]
+
+	return configured || builtin
+}
+
+// validate checks that every role and permission referenced by the Store
+// exists.
+func (s *Store) validate() error {
+	for role, perms := range s.Roles {
+		for _, p := range perms {
+			if !slices.Contains(AllPermissions, p) {
+				return fmt.Errorf("role %q has unknown permission %q; valid permissions are %v", role, p, AllPermissions)
+			}
+		}
+	}
+
+	for _, u := range s.Users {
+		if !s.hasRole(u.Role) {
+			return fmt.Errorf("user %q has unknown role %q", u.Name, u.Role)
+		}
+	}
+
+	for _, t := range s.Tokens {
+		if !s.hasRole(t.Role) {
+			return fmt.Errorf("token %q has unknown role %q", t.Name, t.Role)
+		}
+	}
+
+	return nil
+}
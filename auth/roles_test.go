@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	s := &Store{
+		Roles: map[string][]Permission{
+			"technician": {PermMix},
+			"editor":     {PermView, PermEdit},
+		},
+	}
+
+	tests := []struct {
+		role string
+		want []Permission
+	}{
+		{"", []Permission{PermView}},
+		{AdminRole, AllPermissions},
+		{"stageManager", []Permission{PermView, PermTrigger}},
+		{"viewer", []Permission{PermView}},
+		{"technician", []Permission{PermMix}},
+		{"editor", []Permission{PermView, PermEdit}},
+		{"unknown", nil},
+	}
+
+	for _, tt := range tests {
+		for _, perm := range AllPermissions {
+			if got, want := s.Allowed(tt.role, perm), slices.Contains(tt.want, perm); got != want {
+				t.Errorf("role %q allowed %q: got %v, want %v", tt.role, perm, got, want)
+			}
+		}
+	}
+}
+
+func TestLoadRoles(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		wantErr string
+	}{
+		{
+			name: "default roles",
+			doc:  "users:\n  - name: sm\n    role: stageManager\ntokens:\n  - name: autotrigger\n    role: viewer\n",
+		},
+		{
+			name: "configured role",
+			doc:  "roles:\n  lights: [view, mix]\nusers:\n  - name: sm\n    role: lights\n",
+		},
+		{
+			name:    "unknown permission",
+			doc:     "roles:\n  lights: [view, dim]\n",
+			wantErr: `role "lights" has unknown permission "dim"`,
+		},
+		{
+			name:    "user with unknown role",
+			doc:     "users:\n  - name: sm\n    role: director\n",
+			wantErr: `user "sm" has unknown role "director"`,
+		},
+		{
+			name:    "token with unknown role",
+			doc:     "tokens:\n  - name: autotrigger\n    role: director\n",
+			wantErr: `token "autotrigger" has unknown role "director"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "users.yaml")
+			if err := os.WriteFile(fn, []byte(tt.doc), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := Load(fn)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("got %v, want success", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
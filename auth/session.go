@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// sessionIDBytes is the number of random bytes in a session ID
+const sessionIDBytes = 32
+
+// DefaultSessionTTL is the default lifetime of a browser session
+var DefaultSessionTTL = 12 * time.Hour
+
+// Sessions tracks the logged-in browser sessions.  Sessions are held only in
+// memory, so users must log in again after a restart.
+type Sessions struct {
+
+	// TTL is the lifetime of a session.  If unset, DefaultSessionTTL is used.
+	TTL time.Duration
+
+	sessions map[string]*session
+
+	mu sync.Mutex
+}
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// Create starts a new session for the given user, returning its ID
+func (s *Sessions) Create(user string) (string, error) {
+	buf := make([]byte, sessionIDBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	id := base64.RawURLEncoding.EncodeToString(buf)
+
+	ttl := s.TTL
+	if ttl == 0 {
+		ttl = DefaultSessionTTL
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions == nil {
+		s.sessions = make(map[string]*session)
+	}
+
+	// Clean out any expired sessions while we are here
+	now := time.Now()
+	for k, v := range s.sessions {
+		if now.After(v.expires) {
+			delete(s.sessions, k)
+		}
+	}
+
+	s.sessions[id] = &session{
+		user:    user,
+		expires: now.Add(ttl),
+	}
+
+	return id, nil
+}
+
+// Lookup returns the user of the given session, if it is valid
+func (s *Sessions) Lookup(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return "", false
+	}
+
+	if time.Now().After(sess.expires) {
+		delete(s.sessions, id)
+		return "", false
+	}
+
+	return sess.user, true
+}
+
+// Delete ends the given session
+func (s *Sessions) Delete(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+}
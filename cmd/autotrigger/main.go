@@ -17,11 +17,13 @@ import (
 var cueName string
 var interval time.Duration
 var baseURL string
+var token string
 
 func init() {
 	flag.DurationVar(&interval, "r", 3*time.Minute, "repeat interval")
 	flag.StringVar(&cueName, "c", "", "name of cue to be triggered")
 	flag.StringVar(&baseURL, "u", "http://localhost:3000", "base URL")
+	flag.StringVar(&token, "t", os.Getenv("AUDIMANCE_TOKEN"), "API token (default from AUDIMANCE_TOKEN)")
 }
 
 func main() {
@@ -93,6 +95,10 @@ func trigger(cueID string) error {
 		return fmt.Errorf("failed to construct PUT request: %w", err)
 	}
 
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("request failed: %w", err)
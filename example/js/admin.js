@@ -1,7 +1,21 @@
-import {TriggerCue,BindCueStatus} from '/app/admin.js'
+import {Autopilot,BindAutopilot,BindReplication,Promote,TriggerCue,UndoCue,RewindTo,ResetTimeline,BindCueStatus,BindConnectionStatus,BindSchedule,BindUnmatched,BindPresence} from '/app/admin.js'
 
 window.triggerCue = TriggerCue
+window.undoCue = UndoCue
+window.rewindTo = RewindTo
+window.resetTimeline = ResetTimeline
+window.promote = Promote
+
+window.autopilot = function(action) {
+   Autopilot(action, {speed: document.getElementById("autopilotSpeed").value})
+}
 
 window.onload = function() {
    BindCueStatus("lastCue", "sinceLastCue")
+   BindConnectionStatus("connectionStatus")
+   BindSchedule("scheduledCues")
+   BindAutopilot("autopilotStatus")
+   BindUnmatched("unmatchedCues")
+   BindReplication("replicationStatus")
+   BindPresence("presence")
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/CyCoreSystems/audimance/showtime"
+)
+
+// exportJournal writes the cue history recorded in a journal as a timeline,
+// which may be replayed with the -playback or -timeline flags of serve
+func exportJournal(args []string) int {
+	var journal, output string
+
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&journal, "journal", "showtime.jsonl", "cue history journal of the performance")
+	flags.StringVar(&output, "o", "", "file to which to write the timeline (default standard output)")
+	flags.Parse(args) //nolint: errcheck
+
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	times, err := showtime.ReadJournal(journal)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if len(times) < 1 {
+		fmt.Fprintf(os.Stderr, "no cues recorded in %s\n", journal)
+		return 1
+	}
+
+	err = writeOutput(output, func(w io.Writer) error {
+		return showtime.ExportTimeline(w, times)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+// writeOutput writes to the named file, or to standard output if no file is
+// named
+func writeOutput(output string, write func(io.Writer) error) error {
+	if output == "" {
+		return write(os.Stdout)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
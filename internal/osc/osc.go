@@ -17,27 +17,16 @@ func SetupPositions(a *agenda.Agenda, roomIndex int, svc string) error {
 		return fmt.Errorf("too many sources (%d); only 32 channels allowed", len(r.Sources))
 	}
 
-	svcPieces := strings.Split(svc, ":")
-	if len(svcPieces) != 2 {
-		return fmt.Errorf("failed to parse OSC service address as <host>:<port>")
-	}
-
-	svcPort, err := strconv.Atoi(svcPieces[1])
+	client, err := newClient(svc)
 	if err != nil {
-		return fmt.Errorf("failed to parse port %q as an integer: %w", svcPieces[1], err)
-		}
-
-		if svcPort < 0 || svcPort > 65535 {
-		return fmt.Errorf("invalid port number %q", svcPort)
-		}
-
-	client := osc.NewClient(svcPieces[0], svcPort)
+		return err
+	}
 
 	for n, s := range r.Sources {
 		msg := osc.NewMessage(fmt.Sprintf("/channel/%d/position", n+1))
 		msg.Append(s.Location.X)
 		msg.Append(s.Location.Y)
-		
+
 		if err := client.Send(msg); err != nil {
 			return fmt.Errorf("failed to set position of source %d (channel %d): %w", n, n+1, err)
 		}
@@ -45,3 +34,59 @@ func SetupPositions(a *agenda.Agenda, roomIndex int, svc string) error {
 
 	return nil
 }
+
+// SetPosition moves the source on the given channel (numbered from 1) of the
+// given OSC service to the given position.
+func SetPosition(svc string, channel int, x, y float64) error {
+	client, err := newClient(svc)
+	if err != nil {
+		return err
+	}
+
+	msg := osc.NewMessage(fmt.Sprintf("/channel/%d/position", channel))
+	msg.Append(x)
+	msg.Append(y)
+
+	if err := client.Send(msg); err != nil {
+		return fmt.Errorf("failed to set position of channel %d: %w", channel, err)
+	}
+
+	return nil
+}
+
+// SetVolume sets the volume of the given channel (numbered from 1) of the given
+// OSC service.
+func SetVolume(svc string, channel int, volume float64) error {
+	client, err := newClient(svc)
+	if err != nil {
+		return err
+	}
+
+	msg := osc.NewMessage(fmt.Sprintf("/channel/%d/volume", channel))
+	msg.Append(volume)
+
+	if err := client.Send(msg); err != nil {
+		return fmt.Errorf("failed to set volume of channel %d: %w", channel, err)
+	}
+
+	return nil
+}
+
+// newClient returns a client of the OSC service with the given address
+func newClient(svc string) (*osc.Client, error) {
+	svcPieces := strings.Split(svc, ":")
+	if len(svcPieces) != 2 {
+		return nil, fmt.Errorf("failed to parse OSC service address as <host>:<port>")
+	}
+
+	svcPort, err := strconv.Atoi(svcPieces[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse port %q as an integer: %w", svcPieces[1], err)
+	}
+
+	if svcPort < 0 || svcPort > 65535 {
+		return nil, fmt.Errorf("invalid port number %q", svcPort)
+	}
+
+	return osc.NewClient(svcPieces[0], svcPort), nil
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"embed"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
@@ -9,17 +13,23 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/CyCoreSystems/audimance/agenda"
+	"github.com/CyCoreSystems/audimance/auth"
 	"github.com/CyCoreSystems/audimance/internal/osc"
 	"github.com/CyCoreSystems/audimance/showtime"
 	"github.com/labstack/echo-contrib/prometheus"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/log"
 	prom "github.com/prometheus/client_golang/prometheus"
 	promauto "github.com/prometheus/client_golang/prometheus/promauto"
-	"github.com/labstack/gommon/log"
 	"golang.org/x/net/websocket"
 )
 
@@ -35,6 +45,45 @@ var addr string
 // qlabAddr is the listen address.
 var qlabAddr string
 
+// showsFile lists the shows to host, if there are several
+var showsFile string
+
+// oscCueAddr is the UDP address on which to listen for OSC cue messages.
+var oscCueAddr string
+
+// tcpCueAddr is the TCP address on which to listen for cues, one per line.
+var tcpCueAddr string
+
+// timelineFile is a file of scripted cues to be delivered at fixed offsets
+// from startup.
+var timelineFile string
+
+// playbackFile is a recorded performance to be replayed to each listener
+// from the time they connect.
+var playbackFile string
+
+// replicaAddr is the TCP address on which to serve standbys.
+var replicaAddr string
+
+// primaryAddr is the replication address of the primary server of which this
+// is a hot standby.
+var primaryAddr string
+
+// publisherAddr is the replication address of the cue publisher which this
+// server follows as a web node.
+var publisherAddr string
+
+// replicationKey is the secret shared by a primary and its standbys and web
+// nodes.
+var replicationKey string
+
+// heartbeatTimeout is the time without a message from the primary after
+// which a standby considers it lost.
+var heartbeatTimeout time.Duration
+
+// autoPromote makes a standby take over when it loses its primary.
+var autoPromote bool
+
 // oscAddr is the destination address of the OSC server.
 var oscAddr string
 
@@ -52,74 +101,274 @@ var (
 func init() {
 	metricRoomEntry = promauto.NewCounterVec(prom.CounterOpts{
 		Name: "audimance_room_load",
-		Help: "Total number of room loads",
-	}, []string{"room"})
+		Help: "Total number of room loads, by the view loaded (spatial or tracks)",
+	}, []string{"show", "room", "view"})
 }
 
-// Template contains an HTML templates for the web service
+// journalFile is the file to which the cue history is journaled
+var journalFile string
+
+// freshStart discards any journaled cue history on startup
+var freshStart bool
+
+// cueLogFile is the file to which every attempt to trigger a cue is recorded
+var cueLogFile string
+
+// audienceFile is the file to which the audience of the current performance
+// is saved, for the show report
+var audienceFile string
+
+// usersFile is the file describing the users and API tokens which may access
+// the administrative console and cue API
+var usersFile string
+
+// insecure permits serving without a users file, leaving the administrative
+// console and cue API unprotected
+var insecure bool
+
+// auditFile is the file to which administrative actions are recorded
+var auditFile string
+
+// unmatchedPolicy is the name of the policy for received cue data which
+// matches no cue in the agenda
+var unmatchedPolicy string
+
+// maxDrops is the number of consecutive announcements which may be dropped
+// for a slow client before it is disconnected
+var maxDrops int
+
+// sessionTimeout is the time for which a listener session's private cues are
+// kept after the listener has gone
+var sessionTimeout time.Duration
+
+// shutdownTimeout is the time allowed for requests in progress to complete
+// when the server is stopped
+var shutdownTimeout time.Duration
+
+// reloadInterval is the interval at which the agenda and views are checked
+// for changes.
+var reloadInterval time.Duration
+
+// Template contains an HTML templates for the web service.  The templates may
+// be replaced at any time by a reload.
 type Template struct {
-	templates *template.Template
+	templates atomic.Pointer[template.Template]
 }
 
 // Render adapts the native template to the echo web server renderer interface
 func (t *Template) Render(w io.Writer, name string, data interface{}, ctx echo.Context) error {
-	return t.templates.ExecuteTemplate(w, name, data)
+	return t.templates.Load().ExecuteTemplate(w, name, data)
+}
+
+// parseViews compiles the user-supplied templates of the show in the given
+// directory, which is served under the given URL prefix.  The templates may
+// link to the pages and assets of the show with the prefix function, as in
+// {{prefix}}/live.
+func parseViews(dir string, prefix string) (*template.Template, error) {
+	return template.New("").Funcs(template.FuncMap{
+		"prefix": func() string { return prefix },
+	}).ParseGlob(filepath.Join(dir, "views", "*.html"))
 }
 
 // CustomContext extends the Echo context to allow for custom data
 type CustomContext struct {
 	echo.Context
 
+	Show *show
+
 	Agenda *agenda.Agenda
 
 	ShowTime *showtime.Service
+
+	Audit *auth.AuditLog
+}
+
+// Render renders the named view of the show
+func (c *CustomContext) Render(code int, name string, data interface{}) error {
+	buf := new(bytes.Buffer)
+	if err := c.Show.renderer.Render(buf, name, data, c); err != nil {
+		return err
+	}
+
+	return c.HTMLBlob(code, buf.Bytes())
 }
 
+// roomData is the data passed to the room templates
+type roomData struct {
+	Announcements []*agenda.Announcement `json:"announcements"`
+	Room          *agenda.Room           `json:"room"`
+}
+
+// command is a subcommand of the audimance binary
+type command struct {
+	// Name is the name by which the command is invoked
+	Name string
+
+	// Summary is a one-line description of the command
+	Summary string
+
+	// Run executes the command with the given arguments, returning the
+	// process exit code.
+	Run func(args []string) int
+}
+
+// commands is the list of available subcommands.  The first is the default,
+// which is run when no subcommand is given.
+var commands []*command
+
 func init() {
-	flag.StringVar(&addr, "addr", ":9000", "TCP Address on which to listen for web requests")
-	flag.StringVar(&qlabAddr, "qlab", ":9001", "UDP Address on which to listen for QLab cues")
-	flag.StringVar(&keyFile, "key", "", "TLS key")
-	flag.StringVar(&certFile, "cert", "", "TLS certificate")
-	flag.BoolVar(&debug, "debug", false, "enable debug logging")
-	flag.StringVar(&oscAddr, "osc", "", "Address (<host>:<port>) of an OSC service to configure")
-	flag.IntVar(&oscRoomIndex, "oscroom", 0, "Index number of room to be used as the OSC room")
+	commands = []*command{
+		{
+			Name:    "serve",
+			Summary: "run the Audimance web and cue service (the default)",
+			Run:     serve,
+		},
+		{
+			Name:    "validate",
+			Summary: "check the agenda, media, views, and app bundle of a show directory",
+			Run:     validate,
+		},
+		{
+			Name:    "export",
+			Summary: "export the cue history of a performance as a timeline, for playback",
+			Run:     exportJournal,
+		},
+		{
+			Name:    "report",
+			Summary: "report the cue timings and audience of a performance, as HTML or CSV",
+			Run:     reportPerformance,
+		},
+		{
+			Name:    "user",
+			Summary: "add a user to the users file or change a user's password",
+			Run:     setUser,
+		},
+		{
+			Name:    "token",
+			Summary: "generate an API token for scripts and tools such as autotrigger",
+			Run:     newToken,
+		},
+	}
 }
 
 func main() {
-	flag.Parse()
+	name := commands[0].Name
+	args := os.Args[1:]
 
-	// Read the Agenda
-	a, err := agenda.New("agenda.yaml")
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
+
+	for _, cmd := range commands {
+		if cmd.Name == name {
+			os.Exit(cmd.Run(args))
+		}
+	}
+
+	if name != "help" {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	}
+
+	fmt.Fprintf(os.Stderr, "Usage: %s [command] [flags]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.Name, cmd.Summary)
+	}
+
+	os.Exit(2)
+}
+
+// serve runs the web server and showtime service
+func serve(args []string) int {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	flags.StringVar(&addr, "addr", ":9000", "TCP Address on which to listen for web requests")
+	flags.StringVar(&showsFile, "shows", "", "file listing several shows to host, each in its own directory with its own cue addresses (overrides the cue, timeline, playback, and OSC flags)")
+	flags.StringVar(&qlabAddr, "qlab", ":9001", "UDP Address on which to listen for QLab cues")
+	flags.StringVar(&oscCueAddr, "osccue", "", "UDP Address on which to listen for OSC cue messages (empty disables)")
+	flags.StringVar(&tcpCueAddr, "tcpcue", "", "TCP Address on which to listen for cues, one per line (empty disables)")
+	flags.StringVar(&timelineFile, "timeline", "", "file of scripted cues to deliver at fixed offsets from startup")
+	flags.StringVar(&playbackFile, "playback", "", "recorded performance (see the export command) to replay to each listener from the time they connect")
+	flags.StringVar(&replicaAddr, "replicate", "", "TCP Address on which to serve the cue history to standby servers (empty disables)")
+	flags.StringVar(&primaryAddr, "standby", "", "replication address (<host>:<port>) of a primary server to follow as a hot standby, receiving no cues until promoted")
+	flags.StringVar(&publisherAddr, "follow", "", "replication address (<host>:<port>) of a cue publisher to follow as one of several web nodes, never receiving cues itself")
+	flags.StringVar(&replicationKey, "replicationkey", os.Getenv("AUDIMANCE_REPLICATION_KEY"), "secret shared by a primary and its standbys and web nodes, required for replication (default from AUDIMANCE_REPLICATION_KEY)")
+	flags.DurationVar(&heartbeatTimeout, "heartbeat", showtime.DefaultHeartbeatTimeout, "time without word from the primary after which a standby considers it lost")
+	flags.BoolVar(&autoPromote, "autopromote", true, "promote a standby to take over when it loses its primary")
+	flags.StringVar(&keyFile, "key", "", "TLS key")
+	flags.StringVar(&certFile, "cert", "", "TLS certificate")
+	flags.BoolVar(&debug, "debug", false, "enable debug logging")
+	flags.StringVar(&oscAddr, "osc", "", "Address (<host>:<port>) of an OSC service to configure")
+	flags.IntVar(&oscRoomIndex, "oscroom", 0, "Index number of room to be used as the OSC room")
+	flags.StringVar(&journalFile, "journal", "showtime.jsonl", "file in which to record cue history, to be restored on restart (empty disables)")
+	flags.BoolVar(&freshStart, "fresh", false, "archive any existing cue history journal and start with no cues")
+	flags.StringVar(&audienceFile, "audience", "audience.json", "file in which to save the peak listener count and room visits of the current performance, for the show report (empty disables)")
+	flags.StringVar(&cueLogFile, "cuelog", "cuelog.jsonl", "file in which to record every attempt to trigger a cue, with its origin and outcome (empty disables)")
+	flags.StringVar(&usersFile, "users", "users.yaml", "file of users and API tokens permitted to access the admin console and cue API")
+	flags.BoolVar(&insecure, "insecure", false, "serve without a users file, leaving the admin console and cue API open to anyone who can reach the server")
+	flags.StringVar(&auditFile, "audit", "audit.jsonl", "file in which to record administrative actions")
+	flags.StringVar(&unmatchedPolicy, "unmatched", string(showtime.UnmatchedWarn), "what to do with received cue data which matches no cue in the agenda: drop, accept, or warn (accept with a warning)")
+	flags.IntVar(&maxDrops, "maxdrops", 10, "number of consecutive announcements which may be dropped for a slow client before it is disconnected to resynchronize (0 never disconnects)")
+	flags.DurationVar(&sessionTimeout, "sessiontimeout", time.Hour, "time for which a listener session's private cues are kept after the listener has gone (0 uses the default of an hour)")
+	flags.DurationVar(&shutdownTimeout, "shutdown", 10*time.Second, "time allowed for requests in progress to complete when the server is stopped")
+	flags.DurationVar(&reloadInterval, "reload", 2*time.Second, "interval at which to check agenda.yaml and views for changes (0 disables reloading)")
+	flags.Parse(args) //nolint: errcheck
+
+	configs := []*showConfig{{
+		Dir:       ".",
+		QLab:      qlabAddr,
+		OSCCue:    oscCueAddr,
+		TCPCue:    tcpCueAddr,
+		Timeline:  timelineFile,
+		Playback:  playbackFile,
+		OSC:       oscAddr,
+		OSCRoom:   oscRoomIndex,
+		Replicate: replicaAddr,
+		Standby:   primaryAddr,
+		Follow:    publisherAddr,
+	}}
+	if showsFile != "" {
+		var err error
+		if configs, err = loadShows(showsFile); err != nil {
+			fmt.Printf("failed to load shows:\n%s\n", err.Error())
+			return 1
+		}
+	}
+
+	authn, err := newAuthenticator()
 	if err != nil {
-		fmt.Printf("failed to read agenda: %s", err.Error())
-		os.Exit(1)
+		fmt.Printf("failed to set up authentication: %s\n", err.Error())
+		return 1
 	}
 
 	// Create web server
 	e := echo.New()
 
-	// Create the showtime service
-	svc := new(showtime.Service)
-	svc.Echo = e
-	go func() {
-		err := svc.Run(qlabAddr)
+	var shows []*show
+	for _, c := range configs {
+		s, err := openShow(c, e)
 		if err != nil {
-			fmt.Printf("showtime service died: %s", err.Error())
-			os.Exit(1)
+			fmt.Printf("failed to open %s: %s\n", c, err.Error())
+			return 1
 		}
-	}()
+		shows = append(shows, s)
+	}
+
+	// Send users who log in without asking for a page to the admin console
+	// of the first show
+	authn.Home = shows[0].config.Prefix() + "/admin"
+
+	// Stop on OS kill signals
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	svcErr := make(chan error, len(shows))
+	for _, s := range shows {
+		go func(svc *showtime.Service) {
+			svcErr <- svc.Run(ctx)
+		}(s.svc)
+	}
 
 	// Attach middleware
-	e.Use(func(h echo.HandlerFunc) echo.HandlerFunc {
-		return func(ctx echo.Context) error {
-			c := &CustomContext{
-				Context:  ctx,
-				Agenda:   a,
-				ShowTime: svc,
-			}
-			return h(c)
-		}
-	})
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
@@ -138,69 +387,93 @@ func main() {
 	if debug {
 		e.Logger.SetLevel(log.DEBUG)
 
-		for _, room := range a.Rooms {
-			e.Logger.Debug("Room:", room.ID)
+		for _, s := range shows {
+			for _, room := range s.Agenda().Rooms {
+				e.Logger.Debugf("Room of %s: %s", s.config, room.ID)
+			}
 		}
 	}
 
-	if oscAddr != "" {
-		if err := osc.SetupPositions(a, oscRoomIndex, oscAddr); err != nil {
-			log.Fatalf("failed to configure OSC positions: %w", err)
+	if reloadInterval > 0 {
+		for _, s := range shows {
+			go s.watch(reloadInterval, e.Logger)
 		}
 	}
 
-	// Compile and attach templates
-	e.Renderer = &Template{
-		templates: template.Must(template.ParseGlob("views/*.html")),
-	}
-
 	// Attach handlers
 
-	// Handle the index
-	e.GET("/", func(c echo.Context) error {
-		return c.Render(200, "index.html", a)
-	})
-
 	// Serve internal javascript files
 	e.GET("/app/*", echo.WrapHandler(http.FileServer(http.FS(content))))
 
-	// Serve user-supplied assets
-	e.Static("/js", "js")
-	e.Static("/css", "css")
-	e.Static("/media", "media")
-
-	e.GET("/admin", admin)
-	e.GET("/live", live)
-	e.GET("/room/:id", enterRoom)
-	e.GET("/tracks/:id", roomTracks)
+	e.GET("/login", authn.Login)
+	e.POST("/login", authn.Login)
+	e.GET("/logout", authn.Logout)
+	e.POST("/logout", authn.Logout)
 
-	// command API for manually triggering cues --
-	// FIXME: this is unprotected, unauthenticated
-	e.PUT("/cues/:id", triggerCue)
-
-	// performanceTime provides a websocket connection which provides time tickers and cues based on realtime performance status
-	e.GET("/ws/performanceTime", performanceTime)
+	// Each show is served under its own prefix, or at the root if there is
+	// only the one
+	for _, s := range shows {
+		s.routes(e.Group(s.config.Prefix(), s.context(authn)), authn)
+	}
 
-	e.GET("/agenda.json", agendaJSON)
+	if showsFile != "" {
+		e.GET("/", listShows(shows))
+	}
 
-	// Listen to OS kill signals
+	serverErr := make(chan error, 1)
 	go func() {
-		sigs := make(chan os.Signal, 1)
-		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-		<-sigs
-		e.Logger.Info("exiting on signal")
-		os.Exit(100)
+		// If we have TLS assets, start the TLS server
+		if certFile != "" && keyFile != "" {
+			e.Logger.Debug("listening on 443")
+			serverErr <- e.StartTLS(":443", certFile, keyFile)
+			return
+		}
+
+		// Listen for connections
+		e.Logger.Debugf("listening on %s\n", addr)
+		serverErr <- e.Start(addr)
 	}()
 
-	// If we have TLS assets, start the TLS server
-	if certFile != "" && keyFile != "" {
-		e.Logger.Debug("listening on 443")
-		e.Logger.Fatal(e.StartTLS(":443", certFile, keyFile))
+	code := 100
+	select {
+	case <-ctx.Done():
+		log.Info("shutting down on signal")
+	case err := <-serverErr:
+		fmt.Printf("web server died: %s\n", err.Error())
+		code = 1
+	case err := <-svcErr:
+		if err != nil {
+			fmt.Printf("showtime service died: %s\n", err.Error())
+			code = 1
+		}
 	}
 
-	// Listen for connections
-	e.Logger.Debugf("listening on %s\n", addr)
-	e.Logger.Fatal(e.Start(addr))
+	// Stop receiving cues
+	stop()
+
+	shutdown(e, shows, shutdownTimeout)
+
+	return code
+}
+
+// shutdown stops the showtime services of the shows, which send clients a
+// final announcement and close their subscriptions, and then the web server,
+// waiting up to the given timeout for requests in progress to complete.
+func shutdown(e *echo.Echo, shows []*show, timeout time.Duration) {
+	for _, s := range shows {
+		if err := s.svc.Shutdown(); err != nil {
+			log.Error(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := e.Shutdown(ctx); err != nil {
+		log.Errorf("failed to shut down web server cleanly: %s", err.Error())
+	}
+
+	log.Info("shut down")
 }
 
 func agendaJSON(c echo.Context) error {
@@ -225,18 +498,19 @@ func enterRoom(c echo.Context) error {
 		return ctx.String(http.StatusNotFound, "no such room")
 	}
 
-	data := struct {
-		Announcements []*agenda.Announcement `json:"announcements"`
-		Room          *agenda.Room           `json:"room"`
-	}{
+	data := &roomData{
 		Announcements: ctx.Agenda.Announcements,
 		Room:          r,
 	}
 
 	metricRoomEntry.With(prom.Labels{
-		"room": "spatial",
+		"show": ctx.Show.config.Name,
+		"room": r.Name,
+		"view": "spatial",
 	}).Add(1)
 
+	ctx.ShowTime.VisitRoom(r.Name)
+
 	return ctx.Render(200, "room.html", data)
 }
 
@@ -255,10 +529,465 @@ func triggerCue(c echo.Context) error {
 		return ctx.String(http.StatusNotFound, "no such cue")
 	}
 
-	ctx.ShowTime.Trigger(cue.Data)
+	if err := ctx.ShowTime.Trigger(cue.Data, "http:"+auth.Identity(ctx)); err != nil {
+		return ctx.String(http.StatusServiceUnavailable, err.Error())
+	}
+
+	if err := ctx.Audit.Record(ctx, "trigger", cue.Name); err != nil {
+		ctx.Logger().Error(err)
+	}
+
 	return ctx.String(http.StatusOK, fmt.Sprintf(`Cue "%s" triggered`, cue.Name))
 }
 
+// triggerSessionCue triggers a cue for the listener session given by the
+// session query parameter alone
+func triggerSessionCue(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	id := ctx.Param("id")
+
+	var cue *agenda.Cue
+	for _, thisCue := range ctx.Agenda.Cues {
+		if thisCue.ID == id {
+			cue = thisCue
+		}
+	}
+	if cue == nil {
+		return ctx.String(http.StatusNotFound, "no such cue")
+	}
+
+	session := ctx.QueryParam("session")
+
+	err := ctx.ShowTime.TriggerSession(session, cue.Data, "session:"+session)
+	switch {
+	case errors.Is(err, showtime.ErrInvalidSession):
+		return ctx.String(http.StatusBadRequest, err.Error())
+	case errors.Is(err, showtime.ErrNoSession), errors.Is(err, showtime.ErrUnknownCue):
+		return ctx.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, showtime.ErrSessionLimit):
+		return ctx.String(http.StatusTooManyRequests, err.Error())
+	case err != nil:
+		return ctx.String(http.StatusServiceUnavailable, err.Error())
+	}
+
+	return ctx.String(http.StatusOK, fmt.Sprintf(`Cue "%s" triggered for this session`, cue.Name))
+}
+
+// resetSession clears the private cues of the listener session given by the
+// session query parameter
+func resetSession(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	err := ctx.ShowTime.ResetSession(ctx.QueryParam("session"))
+	switch {
+	case errors.Is(err, showtime.ErrInvalidSession):
+		return ctx.String(http.StatusBadRequest, err.Error())
+	case errors.Is(err, showtime.ErrNoSession):
+		return ctx.String(http.StatusNotFound, err.Error())
+	case errors.Is(err, showtime.ErrSessionLimit):
+		return ctx.String(http.StatusTooManyRequests, err.Error())
+	case err != nil:
+		return ctx.String(http.StatusServiceUnavailable, err.Error())
+	}
+
+	return ctx.String(http.StatusOK, "Session cue history cleared")
+}
+
+func unmatchedCues(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	return ctx.JSON(http.StatusOK, ctx.ShowTime.UnmatchedCues())
+}
+
+func timeline(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	return ctx.JSON(http.StatusOK, ctx.ShowTime.History())
+}
+
+// exportTimeline downloads the cue history as a timeline, to be replayed with
+// the -playback or -timeline flags
+func exportTimeline(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	w := ctx.Response()
+	w.Header().Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	w.Header().Set(echo.HeaderContentDisposition, `attachment; filename="performance.timeline"`)
+	w.WriteHeader(http.StatusOK)
+
+	return showtime.ExportTimeline(w, ctx.ShowTime.History())
+}
+
+func schedule(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	return ctx.JSON(http.StatusOK, ctx.ShowTime.Scheduled())
+}
+
+func cancelScheduled(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	sc, err := ctx.ShowTime.CancelScheduled(ctx.Param("id"))
+	if errors.Is(err, showtime.ErrNotScheduled) {
+		return ctx.String(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Audit.Record(ctx, "cancel-schedule", fmt.Sprintf("%s (%s)", sc.Cue, sc.Reason)); err != nil {
+		ctx.Logger().Error(err)
+	}
+
+	return ctx.String(http.StatusOK, fmt.Sprintf(`Scheduled cue "%s" (%s) cancelled`, sc.Cue, sc.Reason))
+}
+
+func autopilotStatus(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	return ctx.JSON(http.StatusOK, ctx.ShowTime.Autopilot())
+}
+
+// autopilotControl starts, pauses, resumes, skips, stops, or changes the
+// speed of the autopilot.  Starting takes the optional parameters "from" (the
+// ID of the first cue) and "speed"; changing the speed takes "speed".
+func autopilotControl(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	action := ctx.Param("action")
+
+	speed := 1.0
+	if v := ctx.FormValue("speed"); v != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(v, 64); err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid speed %q", v))
+		}
+	}
+
+	var err error
+	switch action {
+	case "start":
+		err = ctx.ShowTime.StartAutopilot(ctx.FormValue("from"), speed)
+	case "pause":
+		err = ctx.ShowTime.PauseAutopilot()
+	case "resume":
+		err = ctx.ShowTime.ResumeAutopilot()
+	case "skip":
+		err = ctx.ShowTime.SkipAutopilot()
+	case "speed":
+		if ctx.FormValue("speed") == "" {
+			return ctx.String(http.StatusBadRequest, "speed required")
+		}
+		err = ctx.ShowTime.SetAutopilotSpeed(speed)
+	case "stop":
+		ctx.ShowTime.StopAutopilot()
+	default:
+		return ctx.String(http.StatusNotFound, fmt.Sprintf("unknown autopilot action %q", action))
+	}
+	if errors.Is(err, showtime.ErrAutopilotStopped) {
+		return ctx.String(http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, showtime.ErrStandby) {
+		return ctx.String(http.StatusServiceUnavailable, err.Error())
+	}
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	if err := ctx.Audit.Record(ctx, "autopilot-"+action, ctx.FormValue("speed")); err != nil {
+		ctx.Logger().Error(err)
+	}
+
+	return ctx.JSON(http.StatusOK, ctx.ShowTime.Autopilot())
+}
+
+// maxAgendaSize is the size of the largest agenda which may be uploaded
+const maxAgendaSize = 1 << 20
+
+// updateAgenda replaces the show's agenda with the YAML in the request body,
+// if it is valid
+func updateAgenda(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	data, err := io.ReadAll(io.LimitReader(ctx.Request().Body, maxAgendaSize+1))
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, fmt.Sprintf("failed to read agenda: %s", err.Error()))
+	}
+	if len(data) > maxAgendaSize {
+		return ctx.String(http.StatusRequestEntityTooLarge, "agenda too large")
+	}
+
+	if err := ctx.Show.replaceAgenda(data); err != nil {
+		return ctx.String(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	if err := ctx.Audit.Record(ctx, "edit-agenda", fmt.Sprintf("%d bytes", len(data))); err != nil {
+		ctx.Logger().Error(err)
+	}
+
+	return ctx.JSON(http.StatusOK, ctx.Show.Agenda())
+}
+
+// mixChannel changes the position (from the x and y form values) or volume
+// (from the volume form value) of a channel of the show's OSC service
+func mixChannel(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	svc := ctx.Show.config.OSC
+	if svc == "" {
+		return ctx.String(http.StatusNotFound, "no OSC service is configured")
+	}
+
+	channel, err := strconv.Atoi(ctx.Param("channel"))
+	if err != nil || channel < 1 || channel > 32 {
+		return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid channel %q", ctx.Param("channel")))
+	}
+
+	value := func(name string) (float64, error) {
+		v, err := strconv.ParseFloat(ctx.FormValue(name), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", name, ctx.FormValue(name))
+		}
+		return v, nil
+	}
+
+	var detail string
+	switch ctx.Param("control") {
+	case "position":
+		x, err := value("x")
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		y, err := value("y")
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		if err := osc.SetPosition(svc, channel, x, y); err != nil {
+			return ctx.String(http.StatusBadGateway, err.Error())
+		}
+		detail = fmt.Sprintf("channel %d position %g,%g", channel, x, y)
+	case "volume":
+		volume, err := value("volume")
+		if err != nil {
+			return ctx.String(http.StatusBadRequest, err.Error())
+		}
+		if err := osc.SetVolume(svc, channel, volume); err != nil {
+			return ctx.String(http.StatusBadGateway, err.Error())
+		}
+		detail = fmt.Sprintf("channel %d volume %g", channel, volume)
+	default:
+		return ctx.String(http.StatusNotFound, fmt.Sprintf("unknown control %q", ctx.Param("control")))
+	}
+
+	if err := ctx.Audit.Record(ctx, "mix", detail); err != nil {
+		ctx.Logger().Error(err)
+	}
+
+	return ctx.String(http.StatusOK, "Set "+detail)
+}
+
+func undoCue(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	t, err := ctx.ShowTime.Undo()
+	if errors.Is(err, showtime.ErrNoCues) {
+		return ctx.String(http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, showtime.ErrStopped) || errors.Is(err, showtime.ErrStandby) {
+		return ctx.String(http.StatusServiceUnavailable, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Audit.Record(ctx, "undo", t.Cue); err != nil {
+		ctx.Logger().Error(err)
+	}
+
+	return ctx.String(http.StatusOK, fmt.Sprintf(`Cue "%s" undone`, t.Cue))
+}
+
+func rewindCue(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	id := ctx.Param("id")
+
+	var cue *agenda.Cue
+	for _, thisCue := range ctx.Agenda.Cues {
+		if thisCue.ID == id {
+			cue = thisCue
+		}
+	}
+	if cue == nil {
+		return ctx.String(http.StatusNotFound, "no such cue")
+	}
+
+	removed, err := ctx.ShowTime.Rewind(cue.Data)
+	if errors.Is(err, showtime.ErrCueNotTriggered) {
+		return ctx.String(http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, showtime.ErrStopped) || errors.Is(err, showtime.ErrStandby) {
+		return ctx.String(http.StatusServiceUnavailable, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Audit.Record(ctx, "rewind", cue.Name); err != nil {
+		ctx.Logger().Error(err)
+	}
+
+	return ctx.String(http.StatusOK, fmt.Sprintf(`Rewound to cue "%s" (%d cues removed)`, cue.Name, removed))
+}
+
+func resetTimeline(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	if err := ctx.ShowTime.Reset(); err != nil {
+		return ctx.String(http.StatusServiceUnavailable, err.Error())
+	}
+
+	if err := ctx.Audit.Record(ctx, "reset", ""); err != nil {
+		ctx.Logger().Error(err)
+	}
+
+	return ctx.String(http.StatusOK, "Cue history cleared")
+}
+
+// cueLog lists the recent attempts to trigger a cue, most recent first,
+// optionally filtered by the query parameters "origin" (a prefix, such as
+// "udp:"), "outcome", "cue" (a cue name or data), "since" (an RFC 3339 time),
+// and "limit" (default 100)
+func cueLog(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	q := &showtime.CueLogQuery{
+		Origin:  ctx.QueryParam("origin"),
+		Outcome: ctx.QueryParam("outcome"),
+		Cue:     ctx.QueryParam("cue"),
+		Limit:   100,
+	}
+
+	switch q.Outcome {
+	case "", showtime.CueTriggered, showtime.CueDropped, showtime.CueRefused:
+	default:
+		return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid outcome %q", q.Outcome))
+	}
+
+	if v := ctx.QueryParam("since"); v != "" {
+		var err error
+		if q.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid since %q", v))
+		}
+	}
+
+	if v := ctx.QueryParam("limit"); v != "" {
+		var err error
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid limit %q", v))
+		}
+	}
+
+	return ctx.JSON(http.StatusOK, ctx.ShowTime.CueAttempts(q))
+}
+
+// showReport compares the cue history of the current performance with the
+// agenda, in the format given by the query parameter "format": html (the
+// default), csv, or json
+func showReport(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	r := ctx.ShowTime.Report()
+
+	switch ctx.QueryParam("format") {
+	case "", "html":
+		buf := new(bytes.Buffer)
+		if err := r.WriteHTML(buf); err != nil {
+			return err
+		}
+		return ctx.HTMLBlob(http.StatusOK, buf.Bytes())
+	case "csv":
+		buf := new(bytes.Buffer)
+		if err := r.WriteCSV(buf); err != nil {
+			return err
+		}
+		ctx.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="report.csv"`)
+		return ctx.Blob(http.StatusOK, "text/csv", buf.Bytes())
+	case "json":
+		return ctx.JSON(http.StatusOK, r)
+	default:
+		return ctx.String(http.StatusBadRequest, fmt.Sprintf("invalid format %q", ctx.QueryParam("format")))
+	}
+}
+
+// presence lists who is listening now, and in which room
+func presence(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	return ctx.JSON(http.StatusOK, ctx.ShowTime.Presence())
+}
+
+// presenceEvents streams who is listening now, and in which room, as
+// server-sent events, sending the whole presence whenever it changes
+func presenceEvents(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	changes, stop := ctx.ShowTime.WatchPresence()
+	defer stop()
+
+	w := ctx.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable buffering by proxies
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 1000\n\n")
+
+	for {
+		data, err := json.Marshal(ctx.ShowTime.Presence())
+		if err != nil {
+			return fmt.Errorf("failed to encode presence: %w", err)
+		}
+
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			ctx.Logger().Error(fmt.Errorf("failed to send presence: %w", err))
+			return nil
+		}
+		w.Flush()
+
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case _, ok := <-changes:
+			if !ok {
+				return nil
+			}
+		}
+	}
+}
+
+func replication(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	return ctx.JSON(http.StatusOK, ctx.ShowTime.Replication())
+}
+
+// promote makes a standby take over receiving cues from its primary
+func promote(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	if err := ctx.ShowTime.Promote(); err != nil {
+		return ctx.String(http.StatusConflict, err.Error())
+	}
+
+	if err := ctx.Audit.Record(ctx, "promote", ctx.ShowTime.Primary); err != nil {
+		ctx.Logger().Error(err)
+	}
+
+	return ctx.String(http.StatusOK, "Promoted; now receiving cues")
+}
+
 func admin(c echo.Context) error {
 	ctx := c.(*CustomContext)
 	return c.Render(200, "admin.html", ctx.Agenda)
@@ -285,36 +1014,278 @@ func roomTracks(c echo.Context) error {
 		return ctx.String(http.StatusNotFound, "no such room")
 	}
 
-	data := struct {
-		Announcements []*agenda.Announcement `json:"announcements"`
-		Room          *agenda.Room           `json:"room"`
-	}{
+	data := &roomData{
 		Announcements: ctx.Agenda.Announcements,
 		Room:          r,
 	}
 
 	metricRoomEntry.With(prom.Labels{
-		"room": "tracks",
+		"show": ctx.Show.config.Name,
+		"room": r.Name,
+		"view": "tracks",
 	}).Add(1)
 
+	ctx.ShowTime.VisitRoom(r.Name)
+
 	return ctx.Render(200, "tracks.html", data)
 }
 
+// bootID distinguishes this run of the server in the IDs of server-sent
+// events, so that a client resuming after a restart is not mistaken for one
+// which is up to date.
+var bootID = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// performanceTimeEvents streams the same announcements as the performanceTime
+// websocket, using the delta protocol, as server-sent events.  It is for
+// clients whose networks do not permit websockets.  Each event's ID carries
+// its sequence number, so that a client which reconnects (sending the
+// Last-Event-ID header) is only sent a snapshot if it has missed something.
+// A client which finds a gap in the sequence numbers should reconnect afresh.
+func performanceTimeEvents(c echo.Context) error {
+	ctx := c.(*CustomContext)
+
+	opts, err := subscribeOptions(ctx, showtime.ProtocolDelta)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
+	lastID := ctx.Request().Header.Get("Last-Event-ID")
+	opts.Seq, opts.Resume = parseEventID(lastID)
+
+	// The browser reconnects to the same URL, so the start of a replay which
+	// it has been given is recalled by the event ID.
+	if opts.Start.IsZero() {
+		opts.Start = eventStart(lastID)
+	}
+
+	sub := ctx.ShowTime.SubscribeWith(opts)
+	defer sub.Cancel()
+
+	w := ctx.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable buffering by proxies
+	w.WriteHeader(http.StatusOK)
+
+	// Reconnect quickly if the stream is interrupted
+	fmt.Fprint(w, "retry: 1000\n\n")
+	w.Flush()
+
+	for {
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case ann, ok := <-sub.C:
+			if !ok {
+				if sub.Evicted() {
+					ctx.Logger().Warnf("disconnecting event stream client %s which was not keeping up (%d announcements dropped)", ctx.RealIP(), sub.Dropped())
+				}
+				return nil
+			}
+
+			data, err := json.Marshal(ann)
+			if err != nil {
+				return fmt.Errorf("failed to encode announcement: %w", err)
+			}
+
+			id := fmt.Sprintf("%s.%d", bootID, ann.Seq)
+			if ann.Start > 0 {
+				id += "." + strconv.FormatInt(int64(ann.Start), 10)
+			}
+
+			if _, err := fmt.Fprintf(w, "id: %s\ndata: %s\n\n", id, data); err != nil {
+				ctx.Logger().Error(fmt.Errorf("failed to send announcement: %w", err))
+				return nil
+			}
+			w.Flush()
+		}
+	}
+}
+
+// parseEventID returns the sequence number from the ID of an event sent by
+// this run of the server.  The ID is the boot ID, the sequence number, and,
+// for a replay of a recorded performance, the start of the replay, separated
+// by dots.
+func parseEventID(id string) (uint64, bool) {
+	boot, seq, ok := strings.Cut(id, ".")
+	if !ok || boot != bootID {
+		return 0, false
+	}
+	seq, _, _ = strings.Cut(seq, ".")
+
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// subscribeOptions returns the options for a client's subscription to the
+// performance time: the start of its replay of a recorded performance, the
+// listener session whose private cues it follows, and the ID of the room it is
+// in, if it gives them.
+func subscribeOptions(ctx *CustomContext, p showtime.Protocol) (*showtime.SubscribeOptions, error) {
+	opts := &showtime.SubscribeOptions{
+		Protocol: p,
+		Start:    parseMillis(ctx.QueryParam("start")),
+		Session:  ctx.QueryParam("session"),
+		Client:   ctx.RealIP(),
+	}
+
+	if opts.Session != "" && !showtime.ValidSessionID(opts.Session) {
+		return nil, showtime.ErrInvalidSession
+	}
+
+	// A room which is no longer in the agenda is ignored, so that the
+	// client stays connected
+	if id := ctx.QueryParam("room"); id != "" {
+		for _, room := range ctx.Agenda.Rooms {
+			if room.ID == id {
+				opts.Room = room.Name
+				break
+			}
+		}
+	}
+
+	return opts, nil
+}
+
+// eventStart returns the start of the replay of a recorded performance from
+// the ID of an event, which need not have been sent by this run of the
+// server, or the zero time if there is none
+func eventStart(id string) time.Time {
+	parts := strings.Split(id, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	return parseMillis(parts[2])
+}
+
+// parseMillis parses a time given in milliseconds since the UNIX epoch,
+// returning the zero time if it is invalid
+func parseMillis(ms string) time.Time {
+	n, err := strconv.ParseFloat(ms, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(int64(n))
+}
+
+// clientMessage is a message sent by a client over the performanceTime
+// websocket
+type clientMessage struct {
+
+	// Type is the type of message.  A "resync" message asks for a snapshot
+	// of the cue history; a "ping" message asks for a pong, by which the
+	// client measures the offset of its clock and its latency.
+	Type string `json:"type"`
+
+	// ID identifies a ping, to be returned in the pong
+	ID int `json:"id,omitempty"`
+
+	// ClientTime is the time at which a ping was sent, in milliseconds since
+	// the UNIX epoch by the client's clock, to be returned in the pong
+	ClientTime float64 `json:"client_time,omitempty"`
+}
+
+// pong answers a client's ping.  With the time at which it sends the ping
+// (t0) and receives the pong (t3), the client estimates the offset of the
+// server's clock from its own as ((Received - t0) + (Sent - t3)) / 2 and
+// the round-trip delay as (t3 - t0) - (Sent - Received), as in NTP.
+type pong struct {
+	Type string `json:"type"`
+
+	// ID and ClientTime are copied from the ping
+	ID         int     `json:"id"`
+	ClientTime float64 `json:"client_time"`
+
+	// Received is the time at which the server received the ping, in
+	// milliseconds since the UNIX epoch
+	Received float64 `json:"received"`
+
+	// Sent is the time at which the server sent the pong, in milliseconds
+	// since the UNIX epoch
+	Sent float64 `json:"sent"`
+}
+
 func performanceTime(c echo.Context) error {
 	ctx := c.(*CustomContext)
 
+	// Clients ask for the delta protocol with ?v=2; others are sent the whole
+	// cue history with every announcement.
+	protocol := showtime.ProtocolFull
+	if ctx.QueryParam("v") == strconv.Itoa(int(showtime.ProtocolDelta)) {
+		protocol = showtime.ProtocolDelta
+	}
+
+	opts, err := subscribeOptions(ctx, protocol)
+	if err != nil {
+		return ctx.String(http.StatusBadRequest, err.Error())
+	}
+
 	websocket.Handler(func(ws *websocket.Conn) {
 		defer ws.Close() //nolint: errcheck
 
 		// Create a subscription to the showtime service
-		sub := ctx.ShowTime.Subscribe()
+		sub := ctx.ShowTime.SubscribeWith(opts)
 		defer sub.Cancel()
 
+		resync := make(chan struct{}, 1)
+		pongs := make(chan *pong, 1)
+		done := make(chan struct{})
+
+		// Process client messages
+		go func() {
+			defer close(done)
+
+			for {
+				var msg clientMessage
+				if err := websocket.JSON.Receive(ws, &msg); err != nil {
+					return
+				}
+
+				received := showtime.UnixMillis(time.Now())
+
+				switch msg.Type {
+				case "resync":
+					select {
+					case resync <- struct{}{}:
+					default: // already pending
+					}
+				case "ping":
+					select {
+					case pongs <- &pong{Type: "pong", ID: msg.ID, ClientTime: msg.ClientTime, Received: received}:
+					default: // the client is pinging too fast; drop it
+					}
+				}
+			}
+		}()
+
 		for {
 			// Process announcements
-			ann := <-sub.C
+			var out interface{}
+			select {
+			case <-done:
+				return
+			case <-resync:
+				out = sub.Snapshot(showtime.SyncNotification)
+			case p := <-pongs:
+				p.Sent = showtime.UnixMillis(time.Now())
+				out = p
+			case ann, ok := <-sub.C:
+				if !ok {
+					if sub.Evicted() {
+						ctx.Logger().Warnf("disconnecting websocket client %s which was not keeping up (%d announcements dropped)", ctx.RealIP(), sub.Dropped())
+					}
+					return
+				}
+				out = ann
+			}
 
-			err := websocket.JSON.Send(ws, ann)
+			err := websocket.JSON.Send(ws, out)
 			if err != nil {
 				ctx.Logger().Error(fmt.Errorf("failed to send announcement: %w", err))
 				break
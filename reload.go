@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+	"github.com/CyCoreSystems/audimance/internal/osc"
+	"github.com/CyCoreSystems/audimance/showtime"
+	"github.com/labstack/echo/v4"
+)
+
+// show holds the state of a running show: its configuration, its showtime
+// service, and the reloadable agenda and views which render it.
+type show struct {
+	config *showConfig
+
+	agenda atomic.Pointer[agenda.Agenda]
+
+	renderer *Template
+
+	svc *showtime.Service
+
+	// reloading serializes reloads from the watcher and from agenda edits
+	reloading sync.Mutex
+
+	// fingerprint identifies the versions of the files from which the
+	// current agenda and views were loaded
+	fingerprint string
+}
+
+// Agenda returns the current agenda
+func (s *show) Agenda() *agenda.Agenda {
+	return s.agenda.Load()
+}
+
+// watch polls the agenda and views at the given interval, reloading them
+// whenever they change.
+func (s *show) watch(interval time.Duration, logger echo.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fingerprint := showFingerprint(s.config.Dir)
+		if fingerprint == s.fingerprint {
+			continue
+		}
+		s.fingerprint = fingerprint
+
+		if err := s.reload(); err != nil {
+			logger.Errorf("not reloading %s: %s", s.config, err.Error())
+			continue
+		}
+
+		logger.Infof("reloaded agenda and views of %s", s.config)
+	}
+}
+
+// reload loads the agenda and views and, only if they are valid, replaces
+// the current ones and announces the change to all subscribers.
+func (s *show) reload() error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	return s.reloadLocked()
+}
+
+// reloadLocked reloads the agenda and views.  The caller must hold the reload
+// lock.
+func (s *show) reloadLocked() error {
+	a, report := agenda.Validate(s.config.path("agenda.yaml"))
+	if report.HasErrors() {
+		return fmt.Errorf("invalid agenda:\n%s", report.Error())
+	}
+
+	views, err := parseViews(s.config.Dir, s.config.Prefix())
+	if err != nil {
+		return fmt.Errorf("failed to parse views: %w", err)
+	}
+
+	v := new(validation)
+	v.checkViews(views, a)
+	for _, p := range v.Problems {
+		if p.Severity == agenda.SeverityError {
+			return fmt.Errorf("invalid views: %s", p.Describe(p.File))
+		}
+	}
+
+	if s.config.OSC != "" {
+		if err := osc.SetupPositions(a, s.config.OSCRoom, s.config.OSC); err != nil {
+			return fmt.Errorf("failed to configure OSC positions: %w", err)
+		}
+	}
+
+	s.agenda.Store(a)
+	s.renderer.templates.Store(views)
+	s.svc.SetAgenda(a)
+
+	s.svc.Announce(showtime.AgendaNotification)
+
+	return nil
+}
+
+// showFingerprint summarises the contents of the agenda and views of the show
+// in the given directory so that changes to any of them may be detected.  The
+// contents are hashed, rather than their modification times and sizes
+// compared, since an edit which keeps the size of a file within the
+// resolution of the filesystem's modification times would not otherwise be
+// seen.
+func showFingerprint(dir string) string {
+	files, _ := filepath.Glob(filepath.Join(dir, "views", "*.html")) //nolint: errcheck
+
+	h := sha256.New()
+	for _, fn := range append([]string{filepath.Join(dir, "agenda.yaml")}, files...) {
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			fmt.Fprintf(h, "%s:missing;", fn)
+			continue
+		}
+
+		fmt.Fprintf(h, "%s:%d:", fn, len(data))
+		h.Write(data) //nolint: errcheck
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replaceAgenda replaces the agenda file of the show with the given one and
+// reloads it, as when it is edited from the console.  If the new agenda is
+// invalid, or does not suit the views, the file is left as it was and the
+// problems are returned.
+func (s *show) replaceAgenda(data []byte) error {
+	s.reloading.Lock()
+	defer s.reloading.Unlock()
+
+	fn := s.config.path("agenda.yaml")
+
+	old, err := os.ReadFile(fn)
+	if err != nil {
+		return fmt.Errorf("failed to read agenda: %w", err)
+	}
+
+	if err := writeFileAtomic(fn, data); err != nil {
+		return fmt.Errorf("failed to write agenda: %w", err)
+	}
+
+	if reloadErr := s.reloadLocked(); reloadErr != nil {
+		if err := writeFileAtomic(fn, old); err != nil {
+			return fmt.Errorf("failed to restore agenda after %w: %w", reloadErr, err)
+		}
+		return reloadErr
+	}
+
+	return nil
+}
+
+// writeFileAtomic replaces the given file with the given data by writing a new
+// file and moving it into place, so that the watcher never reads a partial
+// one
+func writeFileAtomic(fn string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(fn), "."+filepath.Base(fn)+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fn); err != nil {
+		os.Remove(tmp) //nolint: errcheck
+		return err
+	}
+
+	return nil
+}
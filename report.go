@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+	"github.com/CyCoreSystems/audimance/showtime"
+)
+
+// reportPerformance writes a show report comparing the cue history recorded
+// in a journal with the agenda, along with the audience of the performance, if
+// it was saved
+func reportPerformance(args []string) int {
+	var agendaFile, journal, audience, format, output string
+
+	flags := flag.NewFlagSet("report", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s report [flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&agendaFile, "agenda", "agenda.yaml", "agenda of the show")
+	flags.StringVar(&journal, "journal", "showtime.jsonl", "cue history journal of the performance")
+	flags.StringVar(&audience, "audience", "audience.json", "saved audience of the performance (omitted from the report if missing)")
+	flags.StringVar(&format, "format", "html", "format of the report: html, csv, or json")
+	flags.StringVar(&output, "o", "", "file to which to write the report (default standard output)")
+	flags.Parse(args) //nolint: errcheck
+
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	var write func(*showtime.Report, io.Writer) error
+	switch format {
+	case "html":
+		write = (*showtime.Report).WriteHTML
+	case "csv":
+		write = (*showtime.Report).WriteCSV
+	case "json":
+		write = func(r *showtime.Report, w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(r)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "invalid format %q\n", format)
+		return 2
+	}
+
+	a, report := agenda.Validate(agendaFile)
+	if report.HasErrors() {
+		fmt.Fprintf(os.Stderr, "failed to read agenda:\n%s\n", report.Error())
+		return 1
+	}
+
+	times, err := showtime.ReadJournal(journal)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	aud, err := showtime.ReadAudience(audience)
+	if errors.Is(err, fs.ErrNotExist) {
+		aud, err = nil, nil
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	r := showtime.NewReport(a, times, aud)
+
+	err = writeOutput(output, func(w io.Writer) error {
+		return write(r, w)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	return 0
+}
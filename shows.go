@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+	"github.com/CyCoreSystems/audimance/auth"
+	"github.com/CyCoreSystems/audimance/internal/osc"
+	"github.com/CyCoreSystems/audimance/showtime"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// showConfig describes a show hosted by the server.  A server hosts either the
+// single show in the current directory, configured by flags, or several shows
+// listed in a shows file (see the -shows flag), each in its own directory and
+// served under its own URL prefix:
+//
+//	shows:
+//	  - name: north
+//	    dir: northstage
+//	    qlab: ":9001"
+//	  - name: south
+//	    dir: southstage
+//	    qlab: ":9011"
+//	    osccue: ":9012"
+type showConfig struct {
+
+	// Name identifies the show in its URL prefix, /shows/<name>.  It is empty
+	// for the single show of a server which hosts only one, which is served at
+	// the root.
+	Name string `yaml:"name"`
+
+	// Dir is the show directory, containing its agenda.yaml, views, js, css,
+	// and media.  The files of the show, such as its journal and timeline, are
+	// relative to it.  The default is the name of the show.
+	Dir string `yaml:"dir"`
+
+	// QLab is the UDP address on which to listen for QLab cues
+	QLab string `yaml:"qlab"`
+
+	// OSCCue is the UDP address on which to listen for OSC cue messages, if
+	// any
+	OSCCue string `yaml:"osccue"`
+
+	// TCPCue is the TCP address on which to listen for cues, one per line, if
+	// any
+	TCPCue string `yaml:"tcpcue"`
+
+	// Timeline is a file of scripted cues to deliver at fixed offsets from
+	// startup, if any
+	Timeline string `yaml:"timeline"`
+
+	// Playback is a recorded performance to replay to each listener from the
+	// time they connect, if any
+	Playback string `yaml:"playback"`
+
+	// OSC is the address of an OSC service to configure with the positions
+	// of the sources of the room with the index OSCRoom, if any
+	OSC     string `yaml:"osc"`
+	OSCRoom int    `yaml:"oscRoom"`
+
+	// Replicate is the TCP address on which to serve the cue history to
+	// standby servers, if any
+	Replicate string `yaml:"replicate"`
+
+	// Standby is the Replicate address of the primary server which this one
+	// follows as a hot standby, if any
+	Standby string `yaml:"standby"`
+
+	// Follow is the Replicate address of the cue publisher which this server
+	// follows as one of several web nodes, if any
+	Follow string `yaml:"follow"`
+}
+
+// String describes the show, for logging
+func (c *showConfig) String() string {
+	if c.Name == "" {
+		return "the show"
+	}
+
+	return fmt.Sprintf("show %q", c.Name)
+}
+
+// Prefix returns the URL prefix under which the show is served
+func (c *showConfig) Prefix() string {
+	if c.Name == "" {
+		return ""
+	}
+
+	return "/shows/" + c.Name
+}
+
+// path returns the location of the given file of the show
+func (c *showConfig) path(fn string) string {
+	if filepath.IsAbs(fn) {
+		return fn
+	}
+
+	return filepath.Join(c.Dir, fn)
+}
+
+// showNamePattern matches acceptable show names, which appear in URLs
+var showNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// showList lists the shows hosted by the server
+type showList struct {
+	Shows []*showConfig `yaml:"shows"`
+}
+
+// loadShows reads the list of shows to host from the given file
+func loadShows(filename string) ([]*showConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shows: %w", err)
+	}
+
+	f := new(showList)
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(f); err != nil {
+		return nil, fmt.Errorf("failed to parse shows: %w", err)
+	}
+
+	if len(f.Shows) < 1 {
+		return nil, fmt.Errorf("no shows listed in %s", filename)
+	}
+
+	var errs []error
+	names := make(map[string]bool)
+	for i, c := range f.Shows {
+		switch {
+		case !showNamePattern.MatchString(c.Name):
+			errs = append(errs, fmt.Errorf("shows[%d]: invalid name %q: names may contain only letters, digits, '-', and '_'", i, c.Name))
+		case names[c.Name]:
+			errs = append(errs, fmt.Errorf("shows[%d]: duplicate name %q", i, c.Name))
+		}
+		names[c.Name] = true
+
+		if c.Dir == "" {
+			c.Dir = c.Name
+		}
+
+		// A web node receives no cues
+		if c.QLab == "" && c.Follow == "" {
+			errs = append(errs, fmt.Errorf("shows[%d]: %s has no qlab address", i, c))
+		}
+	}
+
+	return f.Shows, errors.Join(errs...)
+}
+
+// openShow loads the agenda and views of the show with the given configuration
+// and sets up its showtime service, ready to be run.
+func openShow(c *showConfig, e *echo.Echo) (*show, error) {
+	a, report := agenda.Validate(c.path("agenda.yaml"))
+	if report.HasErrors() {
+		return nil, fmt.Errorf("failed to read agenda:\n%s", report.Error())
+	}
+	for _, w := range report.Warnings() {
+		log.Warn(w.Describe(report.File))
+	}
+
+	views, err := parseViews(c.Dir, c.Prefix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse views: %w", err)
+	}
+
+	// Create the showtime service
+	svc := new(showtime.Service)
+	svc.Echo = e
+	svc.Name = c.Name
+	svc.MaxDrops = maxDrops
+	svc.SessionTimeout = sessionTimeout
+	svc.ReplicaAddr = c.Replicate
+	svc.Primary = c.Standby
+	svc.ReplicationKey = replicationKey
+	svc.HeartbeatTimeout = heartbeatTimeout
+	svc.AutoPromote = autoPromote
+	svc.SetAgenda(a)
+
+	if svc.UnmatchedPolicy, err = showtime.ParseUnmatchedPolicy(unmatchedPolicy); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c.Standby != "" && c.Follow != "":
+		return nil, errors.New("a server may be a standby or a web node, but not both")
+	case c.Follow != "":
+		svc.Primary = c.Follow
+		svc.Edge = true
+	}
+
+	if (svc.ReplicaAddr != "" || svc.Primary != "") && replicationKey == "" {
+		return nil, errors.New("replication requires a shared key: pass -replicationkey, or set AUDIMANCE_REPLICATION_KEY, on the primary and each of its followers")
+	}
+
+	if journalFile != "" {
+		if err := svc.OpenJournal(c.path(journalFile), freshStart); err != nil {
+			return nil, fmt.Errorf("failed to open cue history journal: %w", err)
+		}
+	}
+
+	if cueLogFile != "" {
+		if err := svc.OpenCueLog(c.path(cueLogFile)); err != nil {
+			return nil, err
+		}
+	}
+
+	if audienceFile != "" {
+		if err := svc.OpenAudience(c.path(audienceFile)); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.QLab != "" {
+		svc.Sources = append(svc.Sources, &showtime.UDPSource{Addr: c.QLab})
+	}
+
+	if c.OSCCue != "" {
+		svc.Sources = append(svc.Sources, &showtime.OSCSource{Addr: c.OSCCue})
+	}
+
+	if c.TCPCue != "" {
+		svc.Sources = append(svc.Sources, &showtime.TCPSource{Addr: c.TCPCue})
+	}
+
+	if c.Timeline != "" {
+		src, err := showtime.LoadTimeline(c.path(c.Timeline))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load timeline:\n%w", err)
+		}
+		svc.Sources = append(svc.Sources, src)
+	}
+
+	if c.Playback != "" {
+		if svc.Playback, err = showtime.LoadTimeline(c.path(c.Playback)); err != nil {
+			return nil, fmt.Errorf("failed to load recorded performance:\n%w", err)
+		}
+	}
+
+	if c.OSC != "" {
+		if err := osc.SetupPositions(a, c.OSCRoom, c.OSC); err != nil {
+			return nil, fmt.Errorf("failed to configure OSC positions: %w", err)
+		}
+	}
+
+	s := &show{
+		config:      c,
+		renderer:    new(Template),
+		svc:         svc,
+		fingerprint: showFingerprint(c.Dir),
+	}
+	s.agenda.Store(a)
+	s.renderer.templates.Store(views)
+
+	return s, nil
+}
+
+// context returns middleware which gives the show's handlers a CustomContext
+// for the show
+func (s *show) context(authn *auth.Authenticator) echo.MiddlewareFunc {
+	return func(h echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			c := &CustomContext{
+				Context:  ctx,
+				Show:     s,
+				Agenda:   s.Agenda(),
+				ShowTime: s.svc,
+				Audit:    authn.Audit,
+			}
+			return h(c)
+		}
+	}
+}
+
+// routes attaches the pages, assets, and APIs of the show to the given group
+func (s *show) routes(g *echo.Group, authn *auth.Authenticator) {
+	// Handle the index
+	g.GET("/", func(c echo.Context) error {
+		ctx := c.(*CustomContext)
+		return c.Render(200, "index.html", ctx.Agenda)
+	})
+	if prefix := s.config.Prefix(); prefix != "" {
+		g.GET("", func(c echo.Context) error {
+			return c.Redirect(http.StatusMovedPermanently, prefix+"/")
+		})
+	}
+
+	// Serve user-supplied assets
+	g.Static("/js", s.config.path("js"))
+	g.Static("/css", s.config.path("css"))
+	g.Static("/media", s.config.path("media"))
+
+	g.GET("/admin", admin, authn.Require(auth.PermView))
+	g.GET("/live", live)
+	g.GET("/room/:id", enterRoom)
+	g.GET("/tracks/:id", roomTracks)
+
+	// command API for manually triggering cues
+	g.PUT("/cues/:id", triggerCue, authn.Require(auth.PermTrigger))
+
+	// mixing API for changing the positions and volumes of the channels of
+	// the OSC service
+	g.PUT("/osc/channels/:channel/:control", mixChannel, authn.Require(auth.PermMix))
+
+	// agenda API for replacing the agenda from the console
+	g.PUT("/agenda", updateAgenda, authn.Require(auth.PermEdit))
+
+	// cue history API for correcting mistakes and resetting between shows
+	g.GET("/cues/unmatched", unmatchedCues, authn.Require(auth.PermView))
+
+	// cue log API listing every attempt to trigger a cue, with its origin
+	// and outcome, for reconstructing misfires
+	g.GET("/admin/cuelog", cueLog, authn.Require(auth.PermView))
+
+	// show report comparing the performance's cue timings with the agenda
+	g.GET("/admin/report", showReport, authn.Require(auth.PermView))
+
+	// presence API showing who is listening now, and in which room
+	g.GET("/admin/presence", presence, authn.Require(auth.PermView))
+	g.GET("/admin/presence/events", presenceEvents, authn.Require(auth.PermView))
+
+	// schedule API for listing and cancelling cues scheduled to be
+	// triggered automatically
+	g.GET("/schedule", schedule, authn.Require(auth.PermView))
+	g.DELETE("/schedule/:id", cancelScheduled, authn.Require(auth.PermTrigger))
+
+	// autopilot API for rehearsals and tech checks, which steps through the
+	// cues by their reference times
+	g.GET("/autopilot", autopilotStatus, authn.Require(auth.PermView))
+	g.POST("/autopilot/:action", autopilotControl, authn.Require(auth.PermTrigger))
+
+	// replication API for hot standbys, which take over receiving cues when
+	// promoted
+	g.GET("/replication", replication, authn.Require(auth.PermView))
+	g.POST("/replication/promote", promote, authn.Require(auth.PermTrigger))
+
+	g.GET("/timeline", timeline, authn.Require(auth.PermView))
+	g.GET("/timeline/export", exportTimeline, authn.Require(auth.PermView))
+	g.DELETE("/timeline", resetTimeline, authn.Require(auth.PermTrigger))
+	g.DELETE("/timeline/last", undoCue, authn.Require(auth.PermTrigger))
+	g.POST("/timeline/rewind/:id", rewindCue, authn.Require(auth.PermTrigger))
+
+	// session API by which listeners trigger cues for themselves alone, as
+	// for a self-paced tour
+	g.PUT("/session/cues/:id", triggerSessionCue)
+	g.DELETE("/session/timeline", resetSession)
+
+	// performanceTime provides a websocket connection which provides time tickers and cues based on realtime performance status
+	g.GET("/ws/performanceTime", performanceTime)
+
+	// performanceTime events provide the same as server-sent events, for
+	// networks which break websockets
+	g.GET("/events/performanceTime", performanceTimeEvents)
+
+	g.GET("/agenda.json", agendaJSON)
+}
+
+// showListTemplate renders the index of a server which hosts several shows
+var showListTemplate = template.Must(template.New("shows").Parse(`<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Audimance</title>
+</head>
+<body>
+	<h3>Select your show</h3>
+	<ul>
+		{{range .}}
+		<li><a href="{{.Prefix}}/">{{.Title}}</a></li>
+		{{end}}
+	</ul>
+</body>
+</html>
+`))
+
+// listShows returns a handler which lists the given shows, linking to each
+func listShows(shows []*show) echo.HandlerFunc {
+	type entry struct {
+		Prefix string
+		Title  string
+	}
+
+	return func(c echo.Context) error {
+		entries := make([]*entry, 0, len(shows))
+		for _, s := range shows {
+			title := s.Agenda().Title
+			if title == "" {
+				title = s.config.Name
+			}
+			entries = append(entries, &entry{Prefix: s.config.Prefix(), Title: title})
+		}
+
+		buf := new(bytes.Buffer)
+		if err := showListTemplate.Execute(buf, entries); err != nil {
+			return err
+		}
+
+		return c.HTMLBlob(http.StatusOK, buf.Bytes())
+	}
+}
@@ -0,0 +1,260 @@
+package showtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Audience describes the audience of the current performance: since the cue
+// history was last reset, or the service started
+type Audience struct {
+
+	// Since is the time at which the performance's audience began to be
+	// counted
+	Since time.Time `json:"since"`
+
+	// PeakListeners is the greatest number of listeners subscribed at once
+	PeakListeners int `json:"peakListeners"`
+
+	// PeakTime is the time at which the PeakListeners were first reached
+	PeakTime time.Time `json:"peakTime,omitempty"`
+
+	// Rooms is the occupancy of each room which listeners have entered, in
+	// order of name
+	Rooms []*RoomOccupancy `json:"rooms"`
+}
+
+// RoomOccupancy describes the listeners of a room over a performance
+type RoomOccupancy struct {
+
+	// Room is the name of the room
+	Room string `json:"room"`
+
+	// Visits is the number of times listeners have entered the room
+	Visits int `json:"visits"`
+
+	// PeakListeners is the greatest number of listeners in the room at once
+	PeakListeners int `json:"peakListeners"`
+
+	// PeakTime is the time at which the PeakListeners were first reached
+	PeakTime time.Time `json:"peakTime,omitempty"`
+}
+
+// Room returns the occupancy of the room with the given name, or nil if no
+// listener has entered it
+func (a *Audience) Room(name string) *RoomOccupancy {
+	for _, r := range a.Rooms {
+		if r.Room == name {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// audience counts the audience of the current performance.  It is guarded by
+// the service lock.
+type audience struct {
+	since  time.Time
+	peak   int
+	peakAt time.Time
+	visits map[string]int
+
+	// rooms holds the peak number of listeners in each room, by name
+	rooms map[string]*RoomOccupancy
+
+	// file is the file to which the audience is saved, if any (see
+	// OpenAudience)
+	file string
+
+	// dirty indicates that the audience has changed since it was last saved
+	dirty bool
+}
+
+// reset begins counting the audience of a new performance at the given time
+func (a *audience) reset(t time.Time) {
+	a.since = t
+	a.peak = 0
+	a.peakAt = time.Time{}
+	a.visits = nil
+	a.rooms = nil
+	a.dirty = true
+}
+
+// listeners notes the number of listeners subscribed at the given time
+func (a *audience) listeners(n int, t time.Time) {
+	if n > a.peak {
+		a.peak = n
+		a.peakAt = t
+		a.dirty = true
+	}
+}
+
+// roomListeners notes the number of listeners in the room with the given name
+// at the given time
+func (a *audience) roomListeners(room string, n int, t time.Time) {
+	if a.rooms == nil {
+		a.rooms = make(map[string]*RoomOccupancy)
+	}
+
+	r := a.rooms[room]
+	if r == nil {
+		r = &RoomOccupancy{Room: room}
+		a.rooms[room] = r
+	}
+
+	if n > r.PeakListeners {
+		r.PeakListeners = n
+		r.PeakTime = t
+		a.dirty = true
+	}
+}
+
+// visit notes a listener entering the room with the given name
+func (a *audience) visit(room string) {
+	if a.visits == nil {
+		a.visits = make(map[string]int)
+	}
+
+	a.visits[room]++
+	a.dirty = true
+}
+
+// get returns a description of the audience
+func (a *audience) get() *Audience {
+	out := &Audience{
+		Since:         a.since,
+		PeakListeners: a.peak,
+		PeakTime:      a.peakAt,
+		Rooms:         []*RoomOccupancy{},
+	}
+
+	rooms := make(map[string]*RoomOccupancy)
+	room := func(name string) *RoomOccupancy {
+		if rooms[name] == nil {
+			rooms[name] = &RoomOccupancy{Room: name}
+			out.Rooms = append(out.Rooms, rooms[name])
+		}
+		return rooms[name]
+	}
+
+	for name, n := range a.visits {
+		room(name).Visits = n
+	}
+	for name, r := range a.rooms {
+		// Rooms which have never had a listener are left out
+		if r.PeakListeners > 0 {
+			o := room(name)
+			o.PeakListeners = r.PeakListeners
+			o.PeakTime = r.PeakTime
+		}
+	}
+	sort.Slice(out.Rooms, func(i, j int) bool {
+		return out.Rooms[i].Room < out.Rooms[j].Room
+	})
+
+	return out
+}
+
+// set restores the given description of the audience
+func (a *audience) set(in *Audience) {
+	a.since = in.Since
+	a.peak = in.PeakListeners
+	a.peakAt = in.PeakTime
+	a.visits = make(map[string]int, len(in.Rooms))
+	a.rooms = make(map[string]*RoomOccupancy, len(in.Rooms))
+
+	for _, r := range in.Rooms {
+		a.visits[r.Room] = r.Visits
+		a.rooms[r.Room] = &RoomOccupancy{Room: r.Room, PeakListeners: r.PeakListeners, PeakTime: r.PeakTime}
+	}
+}
+
+// Audience returns a description of the audience of the current performance
+func (s *Service) Audience() *Audience {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.audience.get()
+}
+
+// VisitRoom counts a listener entering the room with the given name
+func (s *Service) VisitRoom(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.audience.visit(name)
+}
+
+// OpenAudience saves the audience of the current performance to the given
+// file, so that it may be reported after the show (see ReadAudience), and
+// restores the audience saved there, as when the server is restarted during
+// a performance.
+//
+// OpenAudience should be called before the service is Run.
+func (s *Service) OpenAudience(filename string) error {
+	in, err := ReadAudience(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		in, err = &Audience{Since: time.Now()}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.audience.set(in)
+	s.audience.file = filename
+
+	return nil
+}
+
+// ReadAudience reads the audience of a performance saved in the given file
+// (see OpenAudience)
+func ReadAudience(filename string) (*Audience, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audience: %w", err)
+	}
+
+	a := new(Audience)
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("failed to parse audience: %w", err)
+	}
+
+	return a, nil
+}
+
+// saveAudience writes the audience to its file, if it has one and the
+// audience has changed.  The caller must hold the service lock.
+func (s *Service) saveAudience() error {
+	if s.audience.file == "" || !s.audience.dirty {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(s.audience.get(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode audience: %w", err)
+	}
+
+	// Write a new file and move it into place, so that a reader never sees
+	// a partial one
+	tmp := filepath.Join(filepath.Dir(s.audience.file), "."+filepath.Base(s.audience.file)+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write audience: %w", err)
+	}
+	if err := os.Rename(tmp, s.audience.file); err != nil {
+		return fmt.Errorf("failed to write audience: %w", err)
+	}
+
+	s.audience.dirty = false
+
+	return nil
+}
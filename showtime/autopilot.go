@@ -0,0 +1,317 @@
+package showtime
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AutopilotOrigin is the origin of cues triggered by the autopilot
+const AutopilotOrigin = "autopilot"
+
+// ErrAutopilotStopped indicates that the autopilot is not running
+var ErrAutopilotStopped = errors.New("autopilot is not running")
+
+// ErrInvalidSpeed indicates that an autopilot speed is not positive
+var ErrInvalidSpeed = errors.New("autopilot speed must be greater than zero")
+
+// AutopilotStatus describes the state of the rehearsal autopilot
+type AutopilotStatus struct {
+
+	// Running indicates that the autopilot is stepping through the cues
+	Running bool `json:"running"`
+
+	// Paused indicates that the autopilot is waiting to be resumed.  The
+	// autopilot also pauses at a cue which has no ReferenceSeconds.
+	Paused bool `json:"paused"`
+
+	// Speed is the multiplier applied to the cues' ReferenceSeconds
+	Speed float64 `json:"speed"`
+
+	// Next is the name of the next cue to be triggered
+	Next string `json:"next,omitempty"`
+
+	// Remaining is the number of seconds until the next cue is triggered
+	Remaining float64 `json:"remaining"`
+}
+
+// autopilot steps through the cues of the agenda in order, waiting each
+// cue's ReferenceSeconds before triggering the next, for rehearsals and tech
+// checks without a stage manager.
+type autopilot struct {
+	running bool
+	paused  bool
+	speed   float64
+
+	// next is the index of the next cue of the agenda to be triggered
+	next int
+
+	// remaining is the reference time left before the next cue, unscaled by
+	// speed.  It is only current while paused; otherwise, see due.
+	remaining time.Duration
+
+	// due is the time at which the next cue will be triggered, while not
+	// paused
+	due time.Time
+
+	timer *time.Timer
+
+	// generation identifies the current timer, so that one which fires
+	// after it has been replaced does nothing
+	generation int
+
+	mu sync.Mutex
+}
+
+// StartAutopilot starts the autopilot at the cue with the given ID (or the
+// first cue, if it is empty), which is triggered immediately, at the given
+// speed.  An autopilot which is already running is restarted.
+func (s *Service) StartAutopilot(from string, speed float64) error {
+	if speed <= 0 {
+		return ErrInvalidSpeed
+	}
+
+	s.mu.Lock()
+	standby := s.standby
+	s.mu.Unlock()
+	if standby {
+		return ErrStandby
+	}
+
+	a := s.agenda.Load()
+	if a == nil || len(a.Cues) < 1 {
+		return errors.New("the agenda has no cues")
+	}
+
+	next := 0
+	if from != "" {
+		next = -1
+		for i, c := range a.Cues {
+			if c.ID == from {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return fmt.Errorf("no such cue %q", from)
+		}
+	}
+
+	ap := &s.autopilot
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	ap.stop()
+	ap.running = true
+	ap.speed = speed
+	ap.next = next
+
+	s.Echo.Logger.Infof("starting autopilot at cue %q at speed %g", a.Cues[next].Name, speed)
+	s.autopilotStep()
+
+	return nil
+}
+
+// PauseAutopilot pauses the autopilot, keeping the time remaining before the
+// next cue
+func (s *Service) PauseAutopilot() error {
+	ap := &s.autopilot
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	if !ap.running {
+		return ErrAutopilotStopped
+	}
+	if ap.paused {
+		return nil
+	}
+
+	ap.remaining = time.Duration(float64(time.Until(ap.due)) * ap.speed)
+	ap.pause()
+
+	s.Echo.Logger.Info("pausing autopilot")
+
+	return nil
+}
+
+// ResumeAutopilot resumes a paused autopilot
+func (s *Service) ResumeAutopilot() error {
+	ap := &s.autopilot
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	if !ap.running {
+		return ErrAutopilotStopped
+	}
+	if !ap.paused {
+		return nil
+	}
+
+	s.Echo.Logger.Info("resuming autopilot")
+	s.autopilotWait(ap.remaining)
+
+	return nil
+}
+
+// SkipAutopilot triggers the autopilot's next cue immediately
+func (s *Service) SkipAutopilot() error {
+	ap := &s.autopilot
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	if !ap.running {
+		return ErrAutopilotStopped
+	}
+
+	s.Echo.Logger.Info("skipping to next autopilot cue")
+	s.autopilotStep()
+
+	return nil
+}
+
+// SetAutopilotSpeed changes the multiplier applied to the cues'
+// ReferenceSeconds.  A speed of 2 steps through the show twice as fast.
+func (s *Service) SetAutopilotSpeed(speed float64) error {
+	if speed <= 0 {
+		return ErrInvalidSpeed
+	}
+
+	ap := &s.autopilot
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	if ap.running && !ap.paused {
+		remaining := time.Duration(float64(time.Until(ap.due)) * ap.speed)
+		ap.speed = speed
+		s.autopilotWait(remaining)
+	} else {
+		ap.speed = speed
+	}
+
+	s.Echo.Logger.Infof("setting autopilot speed to %g", speed)
+
+	return nil
+}
+
+// StopAutopilot stops the autopilot
+func (s *Service) StopAutopilot() {
+	ap := &s.autopilot
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	if ap.running {
+		s.Echo.Logger.Info("stopping autopilot")
+	}
+
+	ap.stop()
+}
+
+// Autopilot returns the status of the autopilot
+func (s *Service) Autopilot() *AutopilotStatus {
+	ap := &s.autopilot
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	status := &AutopilotStatus{
+		Running: ap.running,
+		Paused:  ap.paused,
+		Speed:   ap.speed,
+	}
+	if !ap.running {
+		return status
+	}
+
+	if a := s.agenda.Load(); a != nil && ap.next < len(a.Cues) {
+		status.Next = a.Cues[ap.next].Name
+	}
+
+	if ap.paused {
+		status.Remaining = ap.remaining.Seconds() / ap.speed
+	} else {
+		status.Remaining = time.Until(ap.due).Seconds()
+	}
+
+	return status
+}
+
+// autopilotStep triggers the next cue and waits for the one after.  The
+// caller must hold the autopilot lock.
+func (s *Service) autopilotStep() {
+	ap := &s.autopilot
+
+	a := s.agenda.Load()
+	if a == nil || ap.next >= len(a.Cues) {
+		s.Echo.Logger.Info("autopilot finished")
+		ap.stop()
+		return
+	}
+
+	c := a.Cues[ap.next]
+	ap.next++
+
+	if err := s.Trigger(c.Data, AutopilotOrigin); err != nil {
+		ap.stop()
+		return
+	}
+
+	if ap.next >= len(a.Cues) {
+		s.Echo.Logger.Info("autopilot finished")
+		ap.stop()
+		return
+	}
+
+	// Without a reference time, there is no knowing when the next cue is
+	// due, so wait to be resumed.
+	if c.ReferenceSeconds <= 0 {
+		s.Echo.Logger.Infof("pausing autopilot: cue %q has no reference time", c.Name)
+		ap.remaining = 0
+		ap.pause()
+		return
+	}
+
+	s.autopilotWait(time.Duration(c.ReferenceSeconds) * time.Second)
+}
+
+// autopilotWait triggers the next cue after the given reference time, scaled
+// by the speed.  The caller must hold the autopilot lock.
+func (s *Service) autopilotWait(reference time.Duration) {
+	ap := &s.autopilot
+
+	ap.pause()
+	ap.paused = false
+
+	wait := time.Duration(float64(reference) / ap.speed)
+	ap.due = time.Now().Add(wait)
+
+	generation := ap.generation
+	ap.timer = time.AfterFunc(wait, func() {
+		ap.mu.Lock()
+		defer ap.mu.Unlock()
+
+		if ap.generation != generation || !ap.running {
+			return
+		}
+
+		s.autopilotStep()
+	})
+}
+
+// pause stops the timer.  The caller must hold the autopilot lock.
+func (ap *autopilot) pause() {
+	ap.paused = true
+	ap.generation++
+
+	if ap.timer != nil {
+		ap.timer.Stop()
+		ap.timer = nil
+	}
+}
+
+// stop stops the autopilot.  The caller must hold the autopilot lock.
+func (ap *autopilot) stop() {
+	ap.pause()
+	ap.running = false
+	ap.paused = false
+	ap.remaining = 0
+}
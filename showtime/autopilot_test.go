@@ -0,0 +1,116 @@
+package showtime
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+// waitForHistory waits for the cue history to reach the given length
+func waitForHistory(t *testing.T, s *Service, n int) []*Time {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if history := s.History(); len(history) >= n {
+			return history
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("got history %v, want %d cues", cueNames(s.History()), n)
+	return nil
+}
+
+func TestAutopilot(t *testing.T) {
+	s := newTestService(&agenda.Agenda{Cues: []*agenda.Cue{
+		{ID: "a", Name: "a", Data: "1", ReferenceSeconds: 1},
+		{ID: "b", Name: "b", Data: "2"},
+		{ID: "c", Name: "c", Data: "3", ReferenceSeconds: 1},
+	}})
+
+	if err := s.StartAutopilot("missing", 1); err == nil {
+		t.Errorf("started at a missing cue, want an error")
+	}
+	if err := s.StartAutopilot("", 0); !errors.Is(err, ErrInvalidSpeed) {
+		t.Errorf("got %v, want %v", err, ErrInvalidSpeed)
+	}
+
+	// At a thousand times speed, a second of reference time passes in a
+	// millisecond
+	if err := s.StartAutopilot("", 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	// The autopilot pauses after b, which has no reference time
+	history := waitForHistory(t, s, 2)
+	if got := cueNames(history); !slices.Equal(got, []string{"1", "2"}) {
+		t.Fatalf("got history %v, want [1 2]", got)
+	}
+	if history[0].Origin != AutopilotOrigin {
+		t.Errorf("got origin %q, want %q", history[0].Origin, AutopilotOrigin)
+	}
+	if got := s.Autopilot(); !got.Running || !got.Paused || got.Next != "c" {
+		t.Errorf("got %+v, want paused before c", got)
+	}
+
+	if err := s.ResumeAutopilot(); err != nil {
+		t.Fatal(err)
+	}
+	waitForHistory(t, s, 3)
+
+	// It finishes once it has triggered the last cue
+	if got := s.Autopilot(); got.Running {
+		t.Errorf("got %+v, want finished", got)
+	}
+	for name, do := range map[string]func() error{
+		"pause":  s.PauseAutopilot,
+		"resume": s.ResumeAutopilot,
+		"skip":   s.SkipAutopilot,
+	} {
+		if err := do(); !errors.Is(err, ErrAutopilotStopped) {
+			t.Errorf("%s: got %v, want %v", name, err, ErrAutopilotStopped)
+		}
+	}
+}
+
+func TestAutopilotPause(t *testing.T) {
+	s := newTestService(&agenda.Agenda{Cues: []*agenda.Cue{
+		{ID: "a", Name: "a", Data: "1", ReferenceSeconds: 3600},
+		{ID: "b", Name: "b", Data: "2", ReferenceSeconds: 3600},
+		{ID: "c", Name: "c", Data: "3", ReferenceSeconds: 3600},
+	}})
+	defer s.StopAutopilot()
+
+	if err := s.StartAutopilot("b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := cueNames(s.History()); !slices.Equal(got, []string{"2"}) {
+		t.Fatalf("got history %v, want [2]", got)
+	}
+
+	if err := s.PauseAutopilot(); err != nil {
+		t.Fatal(err)
+	}
+	got := s.Autopilot()
+	if !got.Paused || got.Next != "c" {
+		t.Errorf("got %+v, want paused before c", got)
+	}
+	if got.Remaining > 1800 || got.Remaining < 1790 {
+		t.Errorf("got %gs remaining, want half an hour at double speed", got.Remaining)
+	}
+
+	// Skipping triggers the next cue regardless
+	if err := s.SkipAutopilot(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cueNames(s.History()); !slices.Equal(got, []string{"2", "3"}) {
+		t.Errorf("got history %v, want [2 3]", got)
+	}
+	if got := s.Autopilot(); got.Running {
+		t.Errorf("got %+v, want finished", got)
+	}
+}
@@ -0,0 +1,257 @@
+package showtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcomes of attempts to trigger a cue
+const (
+
+	// CueTriggered indicates that the cue was triggered
+	CueTriggered = "triggered"
+
+	// CueDropped indicates that the cue data matched no cue and was
+	// discarded (see UnmatchedPolicy)
+	CueDropped = "dropped"
+
+	// CueRefused indicates that the service refused the cue, as when it is
+	// shutting down or is following its primary
+	CueRefused = "refused"
+)
+
+// maxCueAttempts is the number of recent attempts to trigger a cue which are
+// kept for listing (see CueAttempts)
+const maxCueAttempts = 1000
+
+var metricCueAttemptCount *prometheus.CounterVec
+
+func init() {
+	metricCueAttemptCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "audimance_cue_attempts_total",
+		Help: "Total number of attempts to trigger a cue, by outcome",
+	}, []string{"outcome"})
+}
+
+// CueAttempt records an attempt to trigger a cue, from any source
+type CueAttempt struct {
+
+	// Time is the time at which the attempt was made
+	Time time.Time `json:"time"`
+
+	// Origin describes where the cue came from, such as the address of the
+	// QLab host, the user who triggered it from the admin console, or the
+	// scheduler (see CueSource)
+	Origin string `json:"origin"`
+
+	// Data is the cue data received
+	Data string `json:"data"`
+
+	// Cue is the name of the agenda's cue which the data matched, if any
+	Cue string `json:"cue,omitempty"`
+
+	// Matched indicates that the data matched a cue of the agenda
+	Matched bool `json:"matched"`
+
+	// Outcome is what became of the attempt: CueTriggered, CueDropped, or
+	// CueRefused
+	Outcome string `json:"outcome"`
+
+	// Reason explains an outcome other than CueTriggered
+	Reason string `json:"reason,omitempty"`
+
+	// LatencyMillis is the time taken to trigger the cue and announce it to
+	// every subscriber, in milliseconds
+	LatencyMillis float64 `json:"latencyMs"`
+}
+
+// CueLogQuery selects attempts to trigger a cue from the cue log.  Each field
+// which is set must match.
+type CueLogQuery struct {
+
+	// Origin is a prefix of the origin, such as "udp:" or "http:stagemanager"
+	Origin string
+
+	// Outcome is the outcome of the attempt
+	Outcome string
+
+	// Cue is the name of the matched cue, or the cue data received
+	Cue string
+
+	// Since is the earliest time of an attempt
+	Since time.Time
+
+	// Limit is the greatest number of attempts to return, the most recent
+	Limit int
+}
+
+// match indicates whether the given attempt is selected by the query
+func (q *CueLogQuery) match(a *CueAttempt) bool {
+	switch {
+	case q.Origin != "" && !strings.HasPrefix(a.Origin, q.Origin):
+		return false
+	case q.Outcome != "" && a.Outcome != q.Outcome:
+		return false
+	case q.Cue != "" && a.Cue != q.Cue && a.Data != NormalizeCueData(q.Cue):
+		return false
+	case !q.Since.IsZero() && a.Time.Before(q.Since):
+		return false
+	}
+
+	return true
+}
+
+// cueLog records every attempt to trigger a cue, keeping the most recent in
+// memory and, if it has been opened (see OpenCueLog), appending each to a
+// file as a line of JSON.  It is guarded by the service lock.
+type cueLog struct {
+	file *os.File
+
+	// recent holds the most recent attempts, oldest first
+	recent []*CueAttempt
+}
+
+// OpenCueLog appends a record of every attempt to trigger a cue to the given
+// file, as a line of JSON, and restores the most recent attempts recorded
+// there for listing (see CueAttempts).
+//
+// OpenCueLog should be called before the service is Run.
+func (s *Service) OpenCueLog(filename string) error {
+	recent, err := readCueLog(filename)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open cue log: %w", err)
+	}
+
+	s.mu.Lock()
+	s.cueLog.file = f
+	s.cueLog.recent = recent
+	s.mu.Unlock()
+
+	return nil
+}
+
+// readCueLog reads the most recent attempts recorded in the given cue log
+// file.  A missing file has none.
+func readCueLog(filename string) ([]*CueAttempt, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cue log: %w", err)
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	if len(lines) > maxCueAttempts+1 {
+		lines = lines[len(lines)-(maxCueAttempts+1):]
+	}
+
+	var recent []*CueAttempt
+	for _, line := range lines {
+		a := new(CueAttempt)
+
+		// A partial or corrupted line is skipped
+		if len(line) < 1 || json.Unmarshal(line, a) != nil {
+			continue
+		}
+
+		recent = append(recent, a)
+	}
+
+	return recent, nil
+}
+
+// CueAttempts returns the recent attempts to trigger a cue which are selected
+// by the given query, most recent first.
+func (s *Service) CueAttempts(q *CueLogQuery) []*CueAttempt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := []*CueAttempt{}
+	for i := len(s.cueLog.recent) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(out) >= q.Limit {
+			break
+		}
+
+		if a := s.cueLog.recent[i]; q.match(a) {
+			c := *a
+			out = append(out, &c)
+		}
+	}
+
+	return out
+}
+
+// logAttempt records an attempt, begun at the given time, to trigger the cue
+// with the given data, with its outcome and the reason for it.
+func (s *Service) logAttempt(start time.Time, data string, origin string, outcome string, reason string) {
+	a := &CueAttempt{
+		Time:          start,
+		Origin:        origin,
+		Data:          NormalizeCueData(data),
+		Outcome:       outcome,
+		Reason:        reason,
+		LatencyMillis: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if c := s.findCue(func(c *agenda.Cue) bool { return NormalizeCueData(c.Data) == a.Data }); c != nil {
+		a.Cue = c.Name
+		a.Matched = true
+	}
+
+	metricCueAttemptCount.WithLabelValues(outcome).Inc()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cueLog.recent = append(s.cueLog.recent, a)
+	if len(s.cueLog.recent) > maxCueAttempts {
+		s.cueLog.recent = s.cueLog.recent[len(s.cueLog.recent)-maxCueAttempts:]
+	}
+
+	if s.cueLog.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(a)
+	if err != nil {
+		s.Echo.Logger.Error(fmt.Errorf("failed to encode cue log entry: %w", err))
+		return
+	}
+
+	if _, err := s.cueLog.file.Write(append(line, '\n')); err != nil {
+		s.Echo.Logger.Error(fmt.Errorf("failed to write cue log: %w", err))
+	}
+}
+
+// closeCueLog closes the cue log file, if there is one.  Attempts are still
+// kept in memory.  The caller must hold the service lock.
+func (s *Service) closeCueLog() error {
+	if s.cueLog.file == nil {
+		return nil
+	}
+
+	err := s.cueLog.file.Close()
+	s.cueLog.file = nil
+
+	if err != nil {
+		return fmt.Errorf("failed to close cue log: %w", err)
+	}
+
+	return nil
+}
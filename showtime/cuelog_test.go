@@ -0,0 +1,139 @@
+package showtime
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+// outcomes returns the outcomes of the given attempts
+func outcomes(attempts []*CueAttempt) (out []string) {
+	for _, a := range attempts {
+		out = append(out, a.Outcome)
+	}
+	return out
+}
+
+func TestCueLogOutcomes(t *testing.T) {
+	s := newTestService(&agenda.Agenda{
+		Cues: []*agenda.Cue{{Name: "intro", Data: "1"}},
+	})
+	s.UnmatchedPolicy = UnmatchedDrop
+
+	s.Receive("1", "udp:10.0.0.1")
+	s.Receive("2", "udp:10.0.0.1")
+
+	s.standby = true
+	s.Trigger("1", "http:sm") //nolint: errcheck
+	s.standby = false
+
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	s.Trigger("1", "scheduler") //nolint: errcheck
+
+	attempts := s.CueAttempts(new(CueLogQuery))
+	want := []string{CueRefused, CueRefused, CueDropped, CueTriggered}
+	if got := outcomes(attempts); !slices.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	triggered := attempts[3]
+	if triggered.Cue != "intro" || !triggered.Matched || triggered.Origin != "udp:10.0.0.1" || triggered.Reason != "" {
+		t.Errorf("got %+v, want intro triggered from udp:10.0.0.1", triggered)
+	}
+	if dropped := attempts[2]; dropped.Matched || dropped.Data != "2" || dropped.Reason == "" {
+		t.Errorf("got %+v, want unmatched 2 dropped with a reason", dropped)
+	}
+	if attempts[0].Reason != ErrStopped.Error() || attempts[1].Reason != ErrStandby.Error() {
+		t.Errorf("got reasons %q and %q, want stopped and standby", attempts[0].Reason, attempts[1].Reason)
+	}
+}
+
+func TestCueAttempts(t *testing.T) {
+	start := time.Date(2024, 5, 4, 19, 30, 0, 0, time.UTC)
+
+	s := newTestService(nil)
+	s.cueLog.recent = []*CueAttempt{
+		{Time: start, Origin: "udp:10.0.0.1", Data: "1", Cue: "intro", Outcome: CueTriggered},
+		{Time: start.Add(time.Minute), Origin: "http:sm", Data: "2", Outcome: CueDropped},
+		{Time: start.Add(2 * time.Minute), Origin: "udp:10.0.0.2", Data: "3", Cue: "erste", Outcome: CueTriggered},
+		{Time: start.Add(3 * time.Minute), Origin: "scheduler", Data: "1", Cue: "intro", Outcome: CueRefused},
+	}
+
+	tests := []struct {
+		name  string
+		query CueLogQuery
+		want  []string
+	}{
+		{"everything", CueLogQuery{}, []string{"1", "3", "2", "1"}},
+		{"origin prefix", CueLogQuery{Origin: "udp:"}, []string{"3", "1"}},
+		{"outcome", CueLogQuery{Outcome: CueTriggered}, []string{"3", "1"}},
+		{"cue name", CueLogQuery{Cue: "intro"}, []string{"1", "1"}},
+		{"cue data", CueLogQuery{Cue: "2\n"}, []string{"2"}},
+		{"since", CueLogQuery{Since: start.Add(2 * time.Minute)}, []string{"1", "3"}},
+		{"limit", CueLogQuery{Limit: 3}, []string{"1", "3", "2"}},
+		{"limit after filtering", CueLogQuery{Origin: "udp:", Limit: 1}, []string{"3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, a := range s.CueAttempts(&tt.query) {
+				got = append(got, a.Data)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenCueLog(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "cuelog.jsonl")
+
+	s := newTestService(nil)
+	if err := s.OpenCueLog(fn); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Trigger("a", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Trigger("b", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A partial line, as from a crash, is skipped
+	f, err := os.OpenFile(fn, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"time":"2024-05-04T19:`); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restart restores the recent attempts
+	restarted := newTestService(nil)
+	if err := restarted.OpenCueLog(fn); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Shutdown() //nolint: errcheck
+
+	var got []string
+	for _, a := range restarted.CueAttempts(new(CueLogQuery)) {
+		got = append(got, a.Data)
+	}
+	if !slices.Equal(got, []string{"b", "a"}) {
+		t.Errorf("got %v, want [b a]", got)
+	}
+}
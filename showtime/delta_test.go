@@ -0,0 +1,167 @@
+package showtime
+
+import (
+	"slices"
+	"testing"
+)
+
+// announcements returns the announcements waiting for the subscriber
+func announcements(sub *Subscription) []*Announcement {
+	var out []*Announcement
+	for {
+		select {
+		case ann := <-sub.C:
+			out = append(out, ann)
+		default:
+			return out
+		}
+	}
+}
+
+func TestNewDelta(t *testing.T) {
+	tests := []struct {
+		name       string
+		cause      string
+		times      []*Time
+		wantType   string
+		wantPoints []string
+	}{
+		{"cue", CueNotification, history("a", "b"), CueAnnouncement, []string{"b"}},
+		{"tick", PeriodicNotification, history("a", "b"), TickAnnouncement, []string{"b"}},
+		{"tick before any cue", PeriodicNotification, nil, TickAnnouncement, []string{}},
+		{"undo", UndoNotification, history("a", "b"), SnapshotAnnouncement, []string{"a", "b"}},
+		{"rewind", RewindNotification, history("a"), SnapshotAnnouncement, []string{"a"}},
+		{"reset", ResetNotification, nil, SnapshotAnnouncement, []string{}},
+		{"agenda", AgendaNotification, history("a", "b"), SnapshotAnnouncement, []string{"a", "b"}},
+		{"sync", SyncNotification, history("a", "b"), SnapshotAnnouncement, []string{"a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ann := newDelta(tt.cause, 7, tt.times)
+
+			if ann.Type != tt.wantType || ann.Cause != tt.cause || ann.Seq != 7 {
+				t.Errorf("got %s %s seq %d, want %s %s seq 7", ann.Type, ann.Cause, ann.Seq, tt.wantType, tt.cause)
+			}
+
+			points := []string{}
+			for _, p := range ann.TimePoints {
+				points = append(points, p.Cue)
+			}
+			if !slices.Equal(points, tt.wantPoints) {
+				t.Errorf("got time points %v, want %v", points, tt.wantPoints)
+			}
+		})
+	}
+}
+
+func TestDeltaSequence(t *testing.T) {
+	s := newTestService(nil)
+
+	sub := s.SubscribeProtocol(ProtocolDelta)
+	defer sub.Cancel()
+
+	steps := []struct {
+		name     string
+		do       func() error
+		wantType string
+		wantSeq  uint64
+	}{
+		{"subscribe", func() error { return nil }, SnapshotAnnouncement, 0},
+		{"cue", func() error { return s.Trigger("a", "test") }, CueAnnouncement, 1},
+		{"tick", func() error { s.Announce(PeriodicNotification); return nil }, TickAnnouncement, 1},
+		{"another cue", func() error { return s.Trigger("b", "test") }, CueAnnouncement, 2},
+		{"tick", func() error { s.Announce(PeriodicNotification); return nil }, TickAnnouncement, 2},
+		{"undo", func() error { _, err := s.Undo(); return err }, SnapshotAnnouncement, 3},
+		{"agenda", func() error { s.Announce(AgendaNotification); return nil }, SnapshotAnnouncement, 4},
+	}
+
+	for _, step := range steps {
+		if err := step.do(); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+
+		got := announcements(sub)
+		if len(got) != 1 {
+			t.Fatalf("%s: got %d announcements, want 1", step.name, len(got))
+		}
+		if got[0].Type != step.wantType || got[0].Seq != step.wantSeq {
+			t.Errorf("%s: got %s seq %d, want %s seq %d", step.name, got[0].Type, got[0].Seq, step.wantType, step.wantSeq)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	tests := []struct {
+		name         string
+		seq          uint64
+		wantSnapshot bool
+	}{
+		{"missed nothing", 2, false},
+		{"missed a cue", 1, true},
+		{"never connected", 0, true},
+		{"ahead of a restarted server", 5, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(nil)
+			for _, cue := range []string{"a", "b"} {
+				if err := s.Trigger(cue, "test"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			sub := s.Resume(tt.seq)
+			defer sub.Cancel()
+
+			got := announcements(sub)
+			switch {
+			case !tt.wantSnapshot && len(got) > 0:
+				t.Errorf("got %s, want nothing", got[0].Type)
+			case tt.wantSnapshot && len(got) != 1:
+				t.Fatalf("got %d announcements, want a snapshot", len(got))
+			case tt.wantSnapshot && (got[0].Type != SnapshotAnnouncement || got[0].Cause != SyncNotification || got[0].Seq != 2 || len(got[0].TimePoints) != 2):
+				t.Errorf("got %s %s seq %d with %d time points, want a sync snapshot seq 2 with 2", got[0].Type, got[0].Cause, got[0].Seq, len(got[0].TimePoints))
+			}
+
+			// Whether resynchronized or not, the subscriber continues in
+			// sequence
+			if err := s.Trigger("c", "test"); err != nil {
+				t.Fatal(err)
+			}
+			got = announcements(sub)
+			if len(got) != 1 || got[0].Type != CueAnnouncement || got[0].Seq != 3 {
+				t.Errorf("got %v after a cue, want a cue seq 3", got)
+			}
+		})
+	}
+}
+
+func TestFullProtocolSendsSnapshots(t *testing.T) {
+	s := newTestService(nil)
+
+	sub := s.Subscribe()
+	defer sub.Cancel()
+
+	if got := announcements(sub); len(got) > 0 {
+		t.Errorf("got %d announcements on subscribing, want none", len(got))
+	}
+
+	for _, cue := range []string{"a", "b"} {
+		if err := s.Trigger(cue, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Announce(PeriodicNotification)
+
+	got := announcements(sub)
+	if len(got) != 3 {
+		t.Fatalf("got %d announcements, want 3", len(got))
+	}
+	for i, ann := range got {
+		if ann.Type != SnapshotAnnouncement || len(ann.TimePoints) != min(i+1, 2) {
+			t.Errorf("announcement %d is %s with %d time points, want a snapshot with %d", i, ann.Type, len(ann.TimePoints), min(i+1, 2))
+		}
+	}
+}
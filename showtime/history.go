@@ -0,0 +1,151 @@
+package showtime
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoCues indicates that the cue history is empty
+var ErrNoCues = errors.New("no cues have been triggered")
+
+// ErrCueNotTriggered indicates that the given cue is not in the cue history
+var ErrCueNotTriggered = errors.New("cue has not been triggered")
+
+// ErrStopped indicates that the service has been shut down
+var ErrStopped = errors.New("showtime service is shutting down")
+
+// Undo removes the most recently-triggered cue from the cue history,
+// returning it.
+func (s *Service) Undo() (*Time, error) {
+	s.mu.Lock()
+
+	if s.stopped {
+		s.mu.Unlock()
+		return nil, ErrStopped
+	}
+
+	if s.standby {
+		s.mu.Unlock()
+		return nil, ErrStandby
+	}
+
+	if len(s.Times) < 1 {
+		s.mu.Unlock()
+		return nil, ErrNoCues
+	}
+
+	last := s.Times[len(s.Times)-1]
+
+	s.record(&journalEntry{Op: journalUndo, Received: time.Now()})
+	s.announce(UndoNotification)
+	s.mu.Unlock()
+
+	s.Echo.Logger.Info("undoing cue:", last.Cue)
+
+	return last, nil
+}
+
+// Rewind truncates the cue history back to the most recent occurrence of the
+// given cue, which then becomes the latest cue.  It returns the number of cues
+// removed.
+func (s *Service) Rewind(cue string) (int, error) {
+	s.mu.Lock()
+
+	if s.stopped {
+		s.mu.Unlock()
+		return 0, ErrStopped
+	}
+
+	if s.standby {
+		s.mu.Unlock()
+		return 0, ErrStandby
+	}
+
+	i := lastIndex(s.Times, cue)
+	if i < 0 {
+		s.mu.Unlock()
+		return 0, fmt.Errorf("%w: %q", ErrCueNotTriggered, cue)
+	}
+
+	removed := len(s.Times) - (i + 1)
+
+	s.record(&journalEntry{Op: journalRewind, Cue: cue, Received: time.Now()})
+	s.announce(RewindNotification)
+	s.mu.Unlock()
+
+	s.Echo.Logger.Info("rewinding to cue:", cue)
+
+	return removed, nil
+}
+
+// Reset clears the cue history, as between performances
+func (s *Service) Reset() error {
+	s.mu.Lock()
+
+	if s.stopped {
+		s.mu.Unlock()
+		return ErrStopped
+	}
+
+	if s.standby {
+		s.mu.Unlock()
+		return ErrStandby
+	}
+
+	s.record(&journalEntry{Op: journalReset, Received: time.Now()})
+	s.announce(ResetNotification)
+	s.mu.Unlock()
+
+	s.Echo.Logger.Info("resetting cue history")
+
+	s.cancelFollowers()
+
+	return nil
+}
+
+// record applies the given entry to the cue history, journals it, and sends
+// it to any standbys.  The caller must hold the service lock.
+func (s *Service) record(entry *journalEntry) {
+	s.Times = entry.apply(s.Times)
+
+	// A reset begins a new performance, with a new audience
+	if entry.Op == journalReset {
+		s.audience.reset(entry.Received)
+	}
+
+	s.sendReplicas(entry)
+
+	if err := s.writeJournal(entry); err != nil {
+		s.Echo.Logger.Error(fmt.Errorf("failed to journal change to cue history: %w", err))
+	}
+}
+
+// lastIndex returns the index of the most recent occurrence of the given cue
+// in the history, or -1 if it has not been triggered.
+func lastIndex(times []*Time, cue string) int {
+	for i := len(times) - 1; i >= 0; i-- {
+		if times[i].Cue == cue {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// History returns a copy of the cue history
+func (s *Service) History() []*Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*Time, 0, len(s.Times))
+	for _, t := range s.Times {
+		out = append(out, &Time{
+			Cue:      t.Cue,
+			Received: t.Received,
+			Origin:   t.Origin,
+		})
+	}
+
+	return out
+}
@@ -0,0 +1,82 @@
+package showtime
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestUndoRewindReset(t *testing.T) {
+	s := newTestService(nil)
+	for _, cue := range []string{"a", "b", "c", "b"} {
+		if err := s.Trigger(cue, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		name        string
+		do          func() (int, error)
+		wantErr     error
+		wantRemoved int
+		want        []string
+	}{
+		{
+			name:    "rewind to a cue not triggered",
+			do:      func() (int, error) { return s.Rewind("z") },
+			wantErr: ErrCueNotTriggered,
+			want:    []string{"a", "b", "c", "b"},
+		},
+		{
+			name: "rewind to the latest cue",
+			do:   func() (int, error) { return s.Rewind("b") },
+			want: []string{"a", "b", "c", "b"},
+		},
+		{
+			name:        "rewind",
+			do:          func() (int, error) { return s.Rewind("a") },
+			wantRemoved: 3,
+			want:        []string{"a"},
+		},
+		{
+			name: "undo",
+			do: func() (int, error) {
+				last, err := s.Undo()
+				if err == nil && last.Cue != "a" {
+					t.Errorf("undid %q, want %q", last.Cue, "a")
+				}
+				return 0, err
+			},
+			want: []string{},
+		},
+		{
+			name:    "undo with no cues",
+			do:      func() (int, error) { _, err := s.Undo(); return 0, err },
+			wantErr: ErrNoCues,
+			want:    []string{},
+		},
+		{
+			name: "reset",
+			do: func() (int, error) {
+				if err := s.Trigger("x", "test"); err != nil {
+					return 0, err
+				}
+				return 0, s.Reset()
+			},
+			want: []string{},
+		},
+	}
+
+	for _, step := range steps {
+		removed, err := step.do()
+		if !errors.Is(err, step.wantErr) {
+			t.Errorf("%s: got %v, want %v", step.name, err, step.wantErr)
+		}
+		if removed != step.wantRemoved {
+			t.Errorf("%s: removed %d cues, want %d", step.name, removed, step.wantRemoved)
+		}
+		if got := cueNames(s.History()); !slices.Equal(got, step.want) {
+			t.Errorf("%s: got %v, want %v", step.name, got, step.want)
+		}
+	}
+}
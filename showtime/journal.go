@@ -0,0 +1,165 @@
+package showtime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Journal operations.  An entry with no operation is a trigger.
+const (
+	journalUndo   = "undo"
+	journalRewind = "rewind"
+	journalReset  = "reset"
+)
+
+// journalEntry is a single line of the journal
+type journalEntry struct {
+
+	// Op is the operation performed on the cue history.  It is empty for
+	// a triggered cue.
+	Op string `json:"op,omitempty"`
+
+	// Cue is the triggered cue, or the cue to which the history was rewound
+	Cue string `json:"cue,omitempty"`
+
+	// Received is the timestamp at which the cue or operation was received
+	Received time.Time `json:"received"`
+
+	// Origin describes where a triggered cue came from
+	Origin string `json:"origin,omitempty"`
+}
+
+// apply performs the entry's operation on the given cue history
+func (e *journalEntry) apply(times []*Time) []*Time {
+	switch e.Op {
+	case journalUndo:
+		if len(times) > 0 {
+			times = times[:len(times)-1]
+		}
+	case journalRewind:
+		if i := lastIndex(times, e.Cue); i >= 0 {
+			times = times[:i+1]
+		}
+	case journalReset:
+		times = nil
+	default:
+		times = append(times, &Time{
+			Cue:      e.Cue,
+			Received: e.Received,
+			Origin:   e.Origin,
+		})
+	}
+
+	return times
+}
+
+// cause returns the cause of the announcement of the entry's change to the
+// cue history
+func (e *journalEntry) cause() string {
+	switch e.Op {
+	case journalUndo:
+		return UndoNotification
+	case journalRewind:
+		return RewindNotification
+	case journalReset:
+		return ResetNotification
+	default:
+		return CueNotification
+	}
+}
+
+// OpenJournal restores the cue history from the given journal file and
+// appends every subsequently-triggered cue to it, so that the performance
+// timeline survives a restart of the service.  If fresh is set, any existing
+// journal is archived (renamed with a timestamp suffix) instead of being
+// restored.
+//
+// OpenJournal should be called before the service is Run.
+func (s *Service) OpenJournal(filename string, fresh bool) error {
+	if fresh {
+		archive := fmt.Sprintf("%s.%s", filename, time.Now().Format("20060102T150405"))
+		if err := os.Rename(filename, archive); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to archive journal: %w", err)
+		}
+	}
+
+	times, partial, err := readJournal(filename)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	// Terminate any partial line so that it does not corrupt the next entry
+	if partial {
+		if _, err := f.WriteString("\n"); err != nil {
+			f.Close() //nolint: errcheck
+			return fmt.Errorf("failed to repair journal: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.Times = times
+	s.journal = f
+	s.mu.Unlock()
+
+	if len(times) > 0 {
+		s.Echo.Logger.Infof("restored %d cues from journal; last cue %q received at %s", len(times), times[len(times)-1].Cue, times[len(times)-1].Received)
+	}
+
+	return nil
+}
+
+// readJournal reads the cue history from the given journal file.  A missing
+// journal is an empty history.  It also reports whether the journal ends with
+// a partial line, as would be left by a crash during a write.
+func readJournal(filename string) (times []*Time, partial bool, err error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var entry journalEntry
+
+		// A partial line cannot be recovered, so it is skipped, as is any
+		// other corrupted line.
+		if len(line) < 1 || json.Unmarshal(line, &entry) != nil {
+			continue
+		}
+
+		times = entry.apply(times)
+	}
+
+	return times, len(data) > 0 && data[len(data)-1] != '\n', nil
+}
+
+// writeJournal appends the given entry to the journal, if there is one.  The
+// caller must hold the service lock.
+func (s *Service) writeJournal(entry *journalEntry) error {
+	if s.journal == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+
+	if _, err := s.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	return s.journal.Sync()
+}
@@ -0,0 +1,259 @@
+package showtime
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+	"github.com/labstack/echo/v4"
+)
+
+// cueNames returns the cues of the given history, in order
+func cueNames(times []*Time) []string {
+	names := []string{}
+	for _, t := range times {
+		names = append(names, t.Cue)
+	}
+
+	return names
+}
+
+// history returns a cue history of the given cues, a second apart
+func history(cues ...string) []*Time {
+	start := time.Date(2024, 5, 4, 19, 30, 0, 0, time.UTC)
+
+	var times []*Time
+	for i, c := range cues {
+		times = append(times, &Time{Cue: c, Received: start.Add(time.Duration(i) * time.Second)})
+	}
+
+	return times
+}
+
+// newTestService returns a service, not yet run, with the given agenda
+func newTestService(a *agenda.Agenda) *Service {
+	s := &Service{Echo: echo.New()}
+	s.Echo.Logger.SetOutput(io.Discard)
+	s.SetAgenda(a)
+
+	return s
+}
+
+func TestJournalEntryApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		times []*Time
+		entry journalEntry
+		want  []string
+	}{
+		{
+			name:  "trigger",
+			times: history("a", "b"),
+			entry: journalEntry{Cue: "c"},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "trigger first cue",
+			entry: journalEntry{Cue: "a"},
+			want:  []string{"a"},
+		},
+		{
+			name:  "undo",
+			times: history("a", "b", "c"),
+			entry: journalEntry{Op: journalUndo},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "undo empty history",
+			entry: journalEntry{Op: journalUndo},
+			want:  []string{},
+		},
+		{
+			name:  "rewind",
+			times: history("a", "b", "c", "d"),
+			entry: journalEntry{Op: journalRewind, Cue: "b"},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "rewind to last trigger of repeated cue",
+			times: history("a", "b", "a", "c"),
+			entry: journalEntry{Op: journalRewind, Cue: "a"},
+			want:  []string{"a", "b", "a"},
+		},
+		{
+			name:  "rewind to latest cue",
+			times: history("a", "b"),
+			entry: journalEntry{Op: journalRewind, Cue: "b"},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "rewind to missing cue",
+			times: history("a", "b"),
+			entry: journalEntry{Op: journalRewind, Cue: "z"},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:  "reset",
+			times: history("a", "b"),
+			entry: journalEntry{Op: journalReset},
+			want:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cueNames(tt.entry.apply(tt.times))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadJournal(t *testing.T) {
+	tests := []struct {
+		name        string
+		journal     string
+		want        []string
+		wantPartial bool
+	}{
+		{
+			name: "empty",
+			want: []string{},
+		},
+		{
+			name:    "triggers",
+			journal: `{"cue":"a","received":"2024-05-04T19:30:00Z","origin":"udp:10.0.0.5:53000"}` + "\n" + `{"cue":"b","received":"2024-05-04T19:31:00Z"}` + "\n",
+			want:    []string{"a", "b"},
+		},
+		{
+			name:        "partial last line",
+			journal:     `{"cue":"a","received":"2024-05-04T19:30:00Z"}` + "\n" + `{"cue":"b","rec`,
+			want:        []string{"a"},
+			wantPartial: true,
+		},
+		{
+			name:    "repaired partial line",
+			journal: `{"cue":"a","received":"2024-05-04T19:30:00Z"}` + "\n" + `{"cue":"b","rec` + "\n" + `{"cue":"c","received":"2024-05-04T19:32:00Z"}` + "\n",
+			want:    []string{"a", "c"},
+		},
+		{
+			name:    "undo",
+			journal: `{"cue":"a","received":"2024-05-04T19:30:00Z"}` + "\n" + `{"cue":"b","received":"2024-05-04T19:31:00Z"}` + "\n" + `{"op":"undo","received":"2024-05-04T19:31:05Z"}` + "\n",
+			want:    []string{"a"},
+		},
+		{
+			name:    "rewind then trigger",
+			journal: `{"cue":"a","received":"2024-05-04T19:30:00Z"}` + "\n" + `{"cue":"b","received":"2024-05-04T19:31:00Z"}` + "\n" + `{"cue":"c","received":"2024-05-04T19:32:00Z"}` + "\n" + `{"op":"rewind","cue":"a","received":"2024-05-04T19:32:05Z"}` + "\n" + `{"cue":"d","received":"2024-05-04T19:33:00Z"}` + "\n",
+			want:    []string{"a", "d"},
+		},
+		{
+			name:    "reset then trigger",
+			journal: `{"cue":"a","received":"2024-05-04T19:30:00Z"}` + "\n" + `{"op":"reset","received":"2024-05-04T22:00:00Z"}` + "\n" + `{"cue":"b","received":"2024-05-05T19:30:00Z"}` + "\n",
+			want:    []string{"b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "showtime.jsonl")
+			if err := os.WriteFile(fn, []byte(tt.journal), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			times, partial, err := readJournal(fn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := cueNames(times); !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if partial != tt.wantPartial {
+				t.Errorf("got partial %v, want %v", partial, tt.wantPartial)
+			}
+		})
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		times, partial, err := readJournal(filepath.Join(t.TempDir(), "showtime.jsonl"))
+		if err != nil || len(times) > 0 || partial {
+			t.Errorf("got %v, %v, %v; want an empty history", times, partial, err)
+		}
+	})
+
+	t.Run("restores times and origins", func(t *testing.T) {
+		fn := filepath.Join(t.TempDir(), "showtime.jsonl")
+		if err := os.WriteFile(fn, []byte(`{"cue":"a","received":"2024-05-04T19:30:00Z","origin":"udp:10.0.0.5:53000"}`+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		times, _, err := readJournal(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := &Time{Cue: "a", Received: time.Date(2024, 5, 4, 19, 30, 0, 0, time.UTC), Origin: "udp:10.0.0.5:53000"}
+		if len(times) != 1 || times[0].Cue != want.Cue || !times[0].Received.Equal(want.Received) || times[0].Origin != want.Origin {
+			t.Errorf("got %v, want %v", times, want)
+		}
+	})
+}
+
+func TestOpenJournal(t *testing.T) {
+	open := func(t *testing.T, fn string, fresh bool) *Service {
+		t.Helper()
+
+		s := newTestService(nil)
+		if err := s.OpenJournal(fn, fresh); err != nil {
+			t.Fatal(err)
+		}
+
+		return s
+	}
+
+	dir := t.TempDir()
+	fn := filepath.Join(dir, "showtime.jsonl")
+
+	s := open(t, fn, false)
+	for _, cue := range []string{"a", "b"} {
+		if err := s.Trigger(cue, "test"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The history survives a restart
+	s = open(t, fn, false)
+	if got := cueNames(s.Times); !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("got %v after restarting, want [a b]", got)
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh start archives the journal
+	s = open(t, fn, true)
+	if len(s.Times) > 0 {
+		t.Errorf("got %v after a fresh start, want an empty history", cueNames(s.Times))
+	}
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	archived, err := filepath.Glob(filepath.Join(dir, "showtime.jsonl.*"))
+	if err != nil || len(archived) != 1 {
+		t.Fatalf("got archives %v, want one", archived)
+	}
+	data, err := os.ReadFile(archived[0])
+	if err != nil || strings.Count(string(data), "\n") != 2 {
+		t.Errorf("got archive %q, want the two cues", data)
+	}
+}
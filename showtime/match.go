@@ -0,0 +1,189 @@
+package showtime
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// UnmatchedPolicy describes what to do with received cue data which does not
+// match any cue in the agenda.
+type UnmatchedPolicy string
+
+const (
+	// UnmatchedDrop discards unmatched cue data.
+	UnmatchedDrop UnmatchedPolicy = "drop"
+
+	// UnmatchedAccept triggers unmatched cue data as though it matched.
+	UnmatchedAccept UnmatchedPolicy = "accept"
+
+	// UnmatchedWarn triggers unmatched cue data, logging a warning.  This is
+	// the default.
+	UnmatchedWarn UnmatchedPolicy = "warn"
+)
+
+// maxUnmatchedCues is the number of distinct unmatched values retained for
+// display
+const maxUnmatchedCues = 20
+
+var metricCueUnmatchedCount prometheus.Counter
+
+func init() {
+	metricCueUnmatchedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "audimance_cue_unmatched_total",
+		Help: "Total number of received cues which did not match any cue in the agenda",
+	})
+}
+
+// ParseUnmatchedPolicy parses the name of an UnmatchedPolicy
+func ParseUnmatchedPolicy(name string) (UnmatchedPolicy, error) {
+	p := UnmatchedPolicy(name)
+
+	switch p {
+	case UnmatchedDrop, UnmatchedAccept, UnmatchedWarn:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown unmatched cue policy %q; valid policies are %q, %q, and %q", name, UnmatchedDrop, UnmatchedAccept, UnmatchedWarn)
+	}
+}
+
+// UnmatchedCue describes cue data which was received but did not match any
+// cue in the agenda
+type UnmatchedCue struct {
+
+	// Data is the (normalized) data received
+	Data string `json:"data"`
+
+	// Count is the number of times the data has been received
+	Count int `json:"count"`
+
+	// Last is the time at which the data was most recently received
+	Last time.Time `json:"last"`
+
+	// Triggered indicates whether the data was triggered anyway, according
+	// to the UnmatchedPolicy in effect when it was last received
+	Triggered bool `json:"triggered"`
+}
+
+// unmatchedCues keeps the most recently-received unmatched cue data
+type unmatchedCues struct {
+	list []*UnmatchedCue
+
+	mu sync.Mutex
+}
+
+func (u *unmatchedCues) add(data string, triggered bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	i := slices.IndexFunc(u.list, func(c *UnmatchedCue) bool { return c.Data == data })
+	if i < 0 {
+		u.list = append(u.list, &UnmatchedCue{Data: data})
+		i = len(u.list) - 1
+	}
+
+	c := u.list[i]
+	c.Count++
+	c.Last = time.Now()
+	c.Triggered = triggered
+
+	// Keep the most recent first, and only so many
+	u.list = slices.Delete(u.list, i, i+1)
+	u.list = slices.Insert(u.list, 0, c)
+	if len(u.list) > maxUnmatchedCues {
+		u.list = u.list[:maxUnmatchedCues]
+	}
+}
+
+func (u *unmatchedCues) get() []*UnmatchedCue {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	out := make([]*UnmatchedCue, 0, len(u.list))
+	for _, c := range u.list {
+		copied := *c
+		out = append(out, &copied)
+	}
+
+	return out
+}
+
+// NormalizeCueData trims the whitespace and control characters (such as
+// trailing newlines and NULs) which some senders add to cue data.
+func NormalizeCueData(data string) string {
+	return strings.TrimFunc(data, func(r rune) bool {
+		return r <= ' ' || r == 0x7f
+	})
+}
+
+// SetAgenda sets the agenda against whose cues received cue data is matched,
+// and whose cue schedules are executed.  It may be called again whenever the
+// agenda changes.
+func (s *Service) SetAgenda(a *agenda.Agenda) {
+	s.agenda.Store(a)
+	s.planScheduledAt()
+}
+
+// Receive processes cue data received from an external source, such as QLab.
+// The data is normalized and matched against the Data of the agenda's cues;
+// data which matches no cue is handled according to the UnmatchedPolicy.  The
+// origin describes where the data came from (see CueSource).  It returns
+// whether the cue was triggered.
+func (s *Service) Receive(data string, origin string) bool {
+	start := time.Now()
+	data = NormalizeCueData(data)
+
+	if s.matches(data) {
+		return s.Trigger(data, origin) == nil
+	}
+
+	metricCueUnmatchedCount.Inc()
+
+	policy := s.UnmatchedPolicy
+	if policy == "" {
+		policy = UnmatchedWarn
+	}
+
+	triggered := policy != UnmatchedDrop
+	s.unmatched.add(data, triggered)
+
+	switch policy {
+	case UnmatchedDrop:
+		s.Echo.Logger.Warnf("dropping cue data %q from %s which matches no cue in the agenda", data, origin)
+	case UnmatchedWarn:
+		s.Echo.Logger.Warnf("triggering cue data %q from %s which matches no cue in the agenda", data, origin)
+	}
+
+	if triggered {
+		return s.Trigger(data, origin) == nil
+	}
+
+	s.logAttempt(start, data, origin, CueDropped, "matches no cue in the agenda")
+
+	return false
+}
+
+// UnmatchedCues returns the most recently-received cue data which did not
+// match any cue in the agenda, most recent first.
+func (s *Service) UnmatchedCues() []*UnmatchedCue {
+	return s.unmatched.get()
+}
+
+// matches indicates whether the given data matches a cue in the agenda.  If
+// there is no agenda, all data matches.
+func (s *Service) matches(data string) bool {
+	a := s.agenda.Load()
+	if a == nil {
+		return true
+	}
+
+	return slices.ContainsFunc(a.Cues, func(c *agenda.Cue) bool {
+		return NormalizeCueData(c.Data) == data
+	})
+}
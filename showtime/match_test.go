@@ -0,0 +1,204 @@
+package showtime
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+func TestNormalizeCueData(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unchanged", "blah", "blah"},
+		{"trailing newline", "blah\n", "blah"},
+		{"trailing CRLF", "blah\r\n", "blah"},
+		{"surrounding spaces and tabs", " \tblah, blah\t ", "blah, blah"},
+		{"trailing NULs", "blah\x00\x00", "blah"},
+		{"trailing DEL", "blah\x7f", "blah"},
+		{"inner whitespace kept", "blah,  blah\nblah", "blah,  blah\nblah"},
+		{"unicode kept", "  café ", "café"},
+		{"only whitespace", " \r\n\x00", ""},
+		{"empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeCueData(tt.data); got != tt.want {
+				t.Errorf("NormalizeCueData(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUnmatchedPolicy(t *testing.T) {
+	for _, name := range []string{"drop", "accept", "warn"} {
+		if p, err := ParseUnmatchedPolicy(name); err != nil || string(p) != name {
+			t.Errorf("ParseUnmatchedPolicy(%q) = %q, %v", name, p, err)
+		}
+	}
+
+	for _, name := range []string{"", "Drop", "ignore"} {
+		if _, err := ParseUnmatchedPolicy(name); err == nil {
+			t.Errorf("ParseUnmatchedPolicy(%q) succeeded, want an error", name)
+		}
+	}
+}
+
+func TestReceive(t *testing.T) {
+	a := &agenda.Agenda{
+		Cues: []*agenda.Cue{
+			{Name: "intro", Data: "blah"},
+			{Name: "erste", Data: " blah, blah\n"},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		agenda        *agenda.Agenda
+		policy        UnmatchedPolicy
+		data          string
+		wantTriggered bool
+		wantHistory   []string
+		wantUnmatched bool
+		wantOutcome   string
+	}{
+		{
+			name:          "matched",
+			agenda:        a,
+			policy:        UnmatchedDrop,
+			data:          "blah",
+			wantTriggered: true,
+			wantHistory:   []string{"blah"},
+			wantOutcome:   CueTriggered,
+		},
+		{
+			name:          "matched after normalizing the data",
+			agenda:        a,
+			policy:        UnmatchedDrop,
+			data:          "blah\r\n\x00",
+			wantTriggered: true,
+			wantHistory:   []string{"blah"},
+			wantOutcome:   CueTriggered,
+		},
+		{
+			name:          "matched after normalizing the agenda",
+			agenda:        a,
+			policy:        UnmatchedDrop,
+			data:          "blah, blah",
+			wantTriggered: true,
+			wantHistory:   []string{"blah, blah"},
+			wantOutcome:   CueTriggered,
+		},
+		{
+			name:          "unmatched dropped",
+			agenda:        a,
+			policy:        UnmatchedDrop,
+			data:          "bla\n",
+			wantHistory:   []string{},
+			wantUnmatched: true,
+			wantOutcome:   CueDropped,
+		},
+		{
+			name:          "unmatched accepted",
+			agenda:        a,
+			policy:        UnmatchedAccept,
+			data:          "bla\n",
+			wantTriggered: true,
+			wantHistory:   []string{"bla"},
+			wantUnmatched: true,
+			wantOutcome:   CueTriggered,
+		},
+		{
+			name:          "unmatched warned",
+			agenda:        a,
+			policy:        UnmatchedWarn,
+			data:          "bla",
+			wantTriggered: true,
+			wantHistory:   []string{"bla"},
+			wantUnmatched: true,
+			wantOutcome:   CueTriggered,
+		},
+		{
+			name:          "unmatched with the default policy",
+			agenda:        a,
+			data:          "bla",
+			wantTriggered: true,
+			wantHistory:   []string{"bla"},
+			wantUnmatched: true,
+			wantOutcome:   CueTriggered,
+		},
+		{
+			name:          "anything matches without an agenda",
+			policy:        UnmatchedDrop,
+			data:          "bla",
+			wantTriggered: true,
+			wantHistory:   []string{"bla"},
+			wantOutcome:   CueTriggered,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(tt.agenda)
+			s.UnmatchedPolicy = tt.policy
+
+			if got := s.Receive(tt.data, "test"); got != tt.wantTriggered {
+				t.Errorf("got triggered %v, want %v", got, tt.wantTriggered)
+			}
+
+			if got := cueNames(s.History()); !slices.Equal(got, tt.wantHistory) {
+				t.Errorf("got history %v, want %v", got, tt.wantHistory)
+			}
+
+			unmatched := s.UnmatchedCues()
+			switch {
+			case !tt.wantUnmatched && len(unmatched) > 0:
+				t.Errorf("got unmatched %q, want none", unmatched[0].Data)
+			case tt.wantUnmatched && len(unmatched) != 1:
+				t.Errorf("got %d unmatched, want 1", len(unmatched))
+			case tt.wantUnmatched && (unmatched[0].Data != NormalizeCueData(tt.data) || unmatched[0].Triggered != tt.wantTriggered):
+				t.Errorf("got unmatched %+v, want %q triggered %v", unmatched[0], NormalizeCueData(tt.data), tt.wantTriggered)
+			}
+
+			attempts := s.CueAttempts(new(CueLogQuery))
+			if len(attempts) != 1 || attempts[0].Outcome != tt.wantOutcome {
+				t.Errorf("got cue log %+v, want one attempt %s", attempts, tt.wantOutcome)
+			}
+		})
+	}
+}
+
+func TestUnmatchedCuesKeepsMostRecent(t *testing.T) {
+	s := newTestService(&agenda.Agenda{})
+	s.UnmatchedPolicy = UnmatchedDrop
+
+	for _, data := range []string{"a", "b", "a"} {
+		s.Receive(data, "test")
+	}
+
+	unmatched := s.UnmatchedCues()
+	if len(unmatched) != 2 || unmatched[0].Data != "a" || unmatched[0].Count != 2 || unmatched[1].Data != "b" {
+		t.Fatalf("got %+v, want \"a\" received twice and then \"b\"", unmatched)
+	}
+
+	// "a" and "b" are forgotten once there are more recent values
+	for i := 0; i < maxUnmatchedCues; i++ {
+		s.Receive(string(rune('A'+i)), "test")
+	}
+	s.Receive("a", "test")
+
+	unmatched = s.UnmatchedCues()
+	if len(unmatched) != maxUnmatchedCues {
+		t.Fatalf("got %d unmatched, want %d", len(unmatched), maxUnmatchedCues)
+	}
+	if unmatched[0].Data != "a" || unmatched[0].Count != 1 {
+		t.Errorf("got most recent %+v, want \"a\" received once since it was forgotten", unmatched[0])
+	}
+	if slices.ContainsFunc(unmatched, func(c *UnmatchedCue) bool { return c.Data == "b" }) {
+		t.Errorf("got \"b\", which should have been forgotten")
+	}
+}
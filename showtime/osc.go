@@ -0,0 +1,169 @@
+package showtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+	"github.com/hypebeast/go-osc/osc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+
+	// OSCCueAddress is the OSC address of messages which trigger a cue by
+	// its name (or, failing that, its data).  The first argument is the name.
+	OSCCueAddress = "/audimance/cue"
+
+	// OSCCueIDAddress is the OSC address of messages which trigger a cue by
+	// its ID.  The first argument is the ID.
+	OSCCueIDAddress = "/audimance/cue/id"
+)
+
+var metricCueOSCCount prometheus.Counter
+
+func init() {
+	metricCueOSCCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "audimance_cue_osc_total",
+		Help: "Total number of cue messages received over OSC",
+	})
+}
+
+// OSCSource receives cues as OSC messages.  See OSCCueAddress and
+// OSCCueIDAddress.
+type OSCSource struct {
+
+	// Addr is the UDP address on which to listen
+	Addr string
+}
+
+// String implements CueSource
+func (o *OSCSource) String() string {
+	return "OSC " + o.Addr
+}
+
+// Run implements CueSource
+func (o *OSCSource) Run(ctx context.Context, svc *Service) error {
+	conn, err := net.ListenPacket("udp", o.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on OSC port: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() }) //nolint: errcheck
+	defer stop()
+
+	pc := &peerConn{PacketConn: conn}
+	server := new(osc.Server)
+
+	for {
+		packet, err := server.ReceivePacket(pc)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			var netErr net.Error
+			if errors.As(err, &netErr) {
+				return fmt.Errorf("failed to read from OSC port: %w", err)
+			}
+
+			svc.Echo.Logger.Warnf("ignoring invalid OSC packet from %s: %s", pc.peer, err.Error())
+			continue
+		}
+
+		dispatchOSC(svc, packet, "osc:"+pc.peer.String())
+	}
+}
+
+// peerConn records the address from which the last packet was read, which
+// the go-osc server does not report.
+type peerConn struct {
+	net.PacketConn
+
+	peer net.Addr
+}
+
+func (c *peerConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	c.peer = addr
+	return n, addr, err
+}
+
+// dispatchOSC delivers the messages of an OSC packet to the showtime service.
+// Unlike the go-osc StandardDispatcher, it matches addresses exactly, so that
+// a message to OSCCueAddress is not also taken for one to OSCCueIDAddress.
+// Bundles are processed immediately, regardless of their time tags.
+func dispatchOSC(svc *Service, packet osc.Packet, origin string) {
+	switch p := packet.(type) {
+	case *osc.Message:
+		svc.receiveOSC(p, origin)
+	case *osc.Bundle:
+		for _, msg := range p.Messages {
+			svc.receiveOSC(msg, origin)
+		}
+		for _, b := range p.Bundles {
+			dispatchOSC(svc, b, origin)
+		}
+	}
+}
+
+func (s *Service) receiveOSC(msg *osc.Message, origin string) {
+	s.Echo.Logger.Debugf("received OSC message from %s: %s", origin, msg.String())
+
+	if msg.Address != OSCCueAddress && msg.Address != OSCCueIDAddress {
+		s.Echo.Logger.Warnf("ignoring OSC message to unknown address %q", msg.Address)
+		return
+	}
+
+	if len(msg.Arguments) < 1 {
+		s.Echo.Logger.Warnf("ignoring OSC message to %q with no arguments", msg.Address)
+		return
+	}
+
+	metricCueOSCCount.Inc()
+
+	// Cue names and IDs are commonly numbers, which OSC senders may send as
+	// such
+	arg := NormalizeCueData(fmt.Sprint(msg.Arguments[0]))
+
+	var cue *agenda.Cue
+	if msg.Address == OSCCueIDAddress {
+		if cue = s.findCue(func(c *agenda.Cue) bool { return c.ID == arg }); cue == nil {
+			s.Echo.Logger.Warnf("ignoring OSC message for unknown cue ID %q", arg)
+			s.logAttempt(time.Now(), arg, origin, CueDropped, "matches no cue ID in the agenda")
+			return
+		}
+	} else {
+		cue = s.findCue(func(c *agenda.Cue) bool { return c.Name == arg })
+	}
+
+	// A name which matches no cue is treated as cue data, subject to the
+	// UnmatchedPolicy
+	if cue == nil {
+		s.Receive(arg, origin)
+		return
+	}
+
+	s.Trigger(cue.Data, origin) //nolint: errcheck
+}
+
+// findCue returns the first cue of the agenda for which the given function
+// returns true, or nil if there is none.
+func (s *Service) findCue(f func(*agenda.Cue) bool) *agenda.Cue {
+	a := s.agenda.Load()
+	if a == nil {
+		return nil
+	}
+
+	for _, c := range a.Cues {
+		if f(c) {
+			return c
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package showtime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportTimeline writes the given cue history as a timeline (see
+// LoadTimeline), with the offset of each cue from the first, so that a
+// recorded performance may be replayed.
+func ExportTimeline(w io.Writer, times []*Time) error {
+	bw := bufio.NewWriter(w)
+
+	if len(times) > 0 {
+		fmt.Fprintf(bw, "# performance of %s\n", times[0].Received.Format(time.RFC3339))
+	}
+
+	for _, t := range times {
+		offset := t.Received.Sub(times[0].Received).Round(time.Millisecond).Seconds()
+
+		// Cue data may not span lines in a timeline
+		cue := strings.Join(strings.Fields(t.Cue), " ")
+
+		fmt.Fprintf(bw, "%s %s\n", strconv.FormatFloat(offset, 'f', -1, 64), cue)
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write timeline: %w", err)
+	}
+
+	return nil
+}
+
+// ReadJournal reads the cue history recorded in the given journal file (see
+// OpenJournal) without opening it for writing, as for exporting a recorded
+// performance.
+func ReadJournal(filename string) ([]*Time, error) {
+	times, _, err := readJournal(filename)
+	return times, err
+}
+
+// playback is a subscriber's own replay of the service's Playback timeline
+type playback struct {
+
+	// start is the time at which the replay began
+	start time.Time
+
+	// times records the cues of the timeline which have been reached
+	times []*Time
+
+	timer *time.Timer
+
+	// stopped indicates that the subscription has been removed, so that a
+	// timer which has already fired does nothing
+	stopped bool
+}
+
+// startPlayback begins the subscriber's own replay of the Playback timeline
+// at the given time, or now, if it is zero or in the future.  The caller must
+// hold the service lock.
+func (s *Service) startPlayback(sub *Subscription, start time.Time) {
+	if now := time.Now(); start.IsZero() || start.After(now) {
+		start = now
+	}
+
+	sub.playback = &playback{
+		start: start,
+	}
+
+	s.advancePlayback(sub, false)
+}
+
+// advancePlayback records the cues of the Playback timeline which the
+// subscriber's replay has reached, announcing them to the subscriber if
+// announce is set, and waits for the next.  The caller must hold the service
+// lock.
+func (s *Service) advancePlayback(sub *Subscription, announce bool) {
+	pb := sub.playback
+
+	if pb.timer != nil {
+		pb.timer.Stop()
+		pb.timer = nil
+	}
+
+	reached := len(pb.times)
+
+	now := time.Now()
+	for len(pb.times) < len(s.Playback.Steps) {
+		step := s.Playback.Steps[len(pb.times)]
+
+		at := pb.start.Add(step.Offset)
+		if at.After(now) {
+			pb.timer = time.AfterFunc(at.Sub(now), func() {
+				s.mu.Lock()
+				defer s.mu.Unlock()
+
+				if !pb.stopped {
+					s.advancePlayback(sub, true)
+				}
+			})
+			break
+		}
+
+		pb.times = append(pb.times, &Time{
+			Cue:      NormalizeCueData(step.Cue),
+			Received: at,
+			Origin:   "playback:" + s.Playback.Name,
+		})
+	}
+
+	if !announce {
+		return
+	}
+
+	// Cues which fall due together are announced together
+	var ann *Announcement
+	switch n := len(pb.times) - reached; {
+	case n == 0:
+		return
+	case n == 1 && sub.Protocol == ProtocolDelta:
+		ann = sub.delta(CueNotification)
+	default:
+		ann = sub.snapshot(CueNotification)
+	}
+
+	if !s.send(sub, ann) {
+		s.evict(sub)
+	}
+}
+
+// stopPlayback stops the subscriber's replay, if it has one.  The caller must
+// hold the service lock.
+func (sub *Subscription) stopPlayback() {
+	if sub.playback == nil {
+		return
+	}
+
+	sub.playback.stopped = true
+	if sub.playback.timer != nil {
+		sub.playback.timer.Stop()
+	}
+}
@@ -0,0 +1,94 @@
+package showtime
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestExportTimeline(t *testing.T) {
+	start := time.Date(2024, 5, 1, 19, 55, 0, 0, time.UTC)
+	times := []*Time{
+		{Cue: "intro", Received: start},
+		{Cue: "erste", Received: start.Add(12500 * time.Millisecond)},
+		{Cue: "zweite\nteil", Received: start.Add(90 * time.Second)},
+	}
+
+	var buf bytes.Buffer
+	if err := ExportTimeline(&buf, times); err != nil {
+		t.Fatal(err)
+	}
+
+	fn := filepath.Join(t.TempDir(), "timeline.txt")
+	if err := os.WriteFile(fn, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := LoadTimeline(fn)
+	if err != nil {
+		t.Fatalf("failed to load exported timeline %q: %v", buf.String(), err)
+	}
+
+	want := []*TimelineStep{
+		{Offset: 0, Cue: "intro"},
+		{Offset: 12500 * time.Millisecond, Cue: "erste"},
+		{Offset: 90 * time.Second, Cue: "zweite teil"},
+	}
+	if !slices.EqualFunc(src.Steps, want, func(a, b *TimelineStep) bool { return *a == *b }) {
+		t.Errorf("got steps %v, want %v", src.Steps, want)
+	}
+}
+
+func TestPlayback(t *testing.T) {
+	s := newTestService(nil)
+	s.Playback = &TimelineSource{
+		Name: "rehearsal",
+		Steps: []*TimelineStep{
+			{Offset: 0, Cue: "intro"},
+			{Offset: 50 * time.Millisecond, Cue: "erste"},
+			{Offset: time.Hour, Cue: "finale"},
+		},
+	}
+
+	// A new listener starts the replay from the beginning
+	sub := s.SubscribeWith(&SubscribeOptions{Protocol: ProtocolDelta})
+	defer sub.Cancel()
+
+	ann := <-sub.C
+	if ann.Type != SnapshotAnnouncement || len(ann.TimePoints) != 1 || ann.TimePoints[0].Cue != "intro" {
+		t.Fatalf("got %+v, want a snapshot of intro", ann)
+	}
+
+	// The cue history does not affect the replay
+	if err := s.Trigger("other", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ann = <-sub.C:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the replay's next cue")
+	}
+	if ann.Type != CueAnnouncement || len(ann.TimePoints) != 1 || ann.TimePoints[0].Cue != "erste" {
+		t.Errorf("got %+v, want erste", ann)
+	}
+	if got := announcements(sub); len(got) > 0 {
+		t.Errorf("got %d more announcements, want none", len(got))
+	}
+
+	// A listener who reconnects continues where it left off
+	resumed := s.SubscribeWith(&SubscribeOptions{Protocol: ProtocolDelta, Start: time.Now().Add(-time.Minute)})
+	defer resumed.Cancel()
+
+	ann = <-resumed.C
+	var cues []string
+	for _, p := range ann.TimePoints {
+		cues = append(cues, p.Cue)
+	}
+	if !slices.Equal(cues, []string{"intro", "erste"}) {
+		t.Errorf("got %v, want [intro erste]", cues)
+	}
+}
@@ -0,0 +1,204 @@
+package showtime
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var metricRoomListenersCount *prometheus.GaugeVec
+
+func init() {
+	metricRoomListenersCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "audimance_room_listeners_count",
+		Help: "Current number of listeners in each room",
+	}, []string{"show", "room"})
+}
+
+// Presence describes who is listening now, and in which room
+type Presence struct {
+
+	// Time is the time at which the presence was taken
+	Time time.Time `json:"time"`
+
+	// Listeners is the number of listeners subscribed, in any room or none
+	Listeners int `json:"listeners"`
+
+	// Rooms lists each room of the agenda, and any other in which there are
+	// listeners, with the listeners in it.  Listeners who are not in a room,
+	// such as on the index page or the admin console, are listed under a
+	// room with an empty name, first.
+	Rooms []*RoomPresence `json:"rooms"`
+}
+
+// RoomPresence describes the listeners in a room now
+type RoomPresence struct {
+
+	// Room is the name of the room
+	Room string `json:"room"`
+
+	// Count is the number of listeners in the room
+	Count int `json:"count"`
+
+	// Listeners lists the listeners in the room, longest-present first
+	Listeners []*Listener `json:"listeners"`
+}
+
+// Listener describes a subscriber listening now
+type Listener struct {
+
+	// Session tells the listener's session apart from others, if it gave
+	// one (see sessionTag).  It is not the session ID, which would let a
+	// viewer trigger cues for the session.
+	Session string `json:"session,omitempty"`
+
+	// Client describes the client, such as its address
+	Client string `json:"client,omitempty"`
+
+	// Since is the time at which the listener subscribed
+	Since time.Time `json:"since"`
+}
+
+// presenceWatcher is notified of changes to the presence (see WatchPresence)
+type presenceWatcher struct {
+	C      chan struct{}
+	closed bool
+}
+
+// Presence returns a description of who is listening now, and in which room
+func (s *Service) Presence() *Presence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.presence()
+}
+
+// presence returns a description of who is listening now.  The caller must
+// hold the service lock.
+func (s *Service) presence() *Presence {
+	p := &Presence{
+		Time:      time.Now(),
+		Listeners: len(s.subs),
+		Rooms:     []*RoomPresence{},
+	}
+
+	rooms := make(map[string]*RoomPresence)
+	room := func(name string) *RoomPresence {
+		if rooms[name] == nil {
+			rooms[name] = &RoomPresence{Room: name, Listeners: []*Listener{}}
+			p.Rooms = append(p.Rooms, rooms[name])
+		}
+		return rooms[name]
+	}
+
+	room("")
+	if a := s.agenda.Load(); a != nil {
+		for _, r := range a.Rooms {
+			room(r.Name)
+		}
+	}
+
+	for _, sub := range s.subs {
+		r := room(sub.room)
+		r.Count++
+		r.Listeners = append(r.Listeners, &Listener{
+			Session: sessionTag(sub.sessionID),
+			Client:  sub.client,
+			Since:   sub.since,
+		})
+	}
+
+	for _, r := range p.Rooms {
+		sort.Slice(r.Listeners, func(i, j int) bool {
+			return r.Listeners[i].Since.Before(r.Listeners[j].Since)
+		})
+	}
+
+	return p
+}
+
+// sessionTag returns a short one-way hash of the given session ID, by which a
+// session may be recognized without revealing its ID, or nothing if the ID
+// is empty
+func sessionTag(id string) string {
+	if id == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(id))
+
+	return hex.EncodeToString(sum[:4])
+}
+
+// WatchPresence returns a channel on which the service signals each change to
+// who is listening, or in which room (see Presence), and a function to stop
+// watching.  Changes in quick succession may be signalled once.  The channel
+// is closed when the service is shut down.
+func (s *Service) WatchPresence() (<-chan struct{}, func()) {
+	w := &presenceWatcher{C: make(chan struct{}, 1)}
+
+	s.mu.Lock()
+	if s.stopped {
+		close(w.C)
+		w.closed = true
+	} else {
+		s.presenceWatchers = append(s.presenceWatchers, w)
+	}
+	s.mu.Unlock()
+
+	return w.C, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		for i, wi := range s.presenceWatchers {
+			if wi == w {
+				s.presenceWatchers = append(s.presenceWatchers[:i], s.presenceWatchers[i+1:]...)
+				break
+			}
+		}
+
+		if !w.closed {
+			close(w.C)
+			w.closed = true
+		}
+	}
+}
+
+// updatePresence updates the count of listeners in each room, and the peak of
+// the performance's audience in each, and signals the change to watchers.  The
+// caller must hold the service lock.
+func (s *Service) updatePresence() {
+	now := time.Now()
+
+	for _, r := range s.presence().Rooms {
+		if r.Room == "" {
+			continue
+		}
+
+		metricRoomListenersCount.WithLabelValues(s.Name, r.Room).Set(float64(r.Count))
+		s.audience.roomListeners(r.Room, r.Count, now)
+	}
+
+	for _, w := range s.presenceWatchers {
+		select {
+		case w.C <- struct{}{}:
+		default: // already pending
+		}
+	}
+}
+
+// stopPresence closes the channels of the watchers of the presence.  The
+// caller must hold the service lock.
+func (s *Service) stopPresence() {
+	for _, w := range s.presenceWatchers {
+		if !w.closed {
+			close(w.C)
+			w.closed = true
+		}
+	}
+	s.presenceWatchers = nil
+}
@@ -0,0 +1,120 @@
+package showtime
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+// roomCounts returns the number of listeners in each room of the presence
+func roomCounts(p *Presence) map[string]int {
+	out := make(map[string]int, len(p.Rooms))
+	for _, r := range p.Rooms {
+		out[r.Room] = r.Count
+	}
+	return out
+}
+
+func TestPresence(t *testing.T) {
+	s := newTestService(&agenda.Agenda{
+		Rooms: []*agenda.Room{{Name: "stage"}, {Name: "lobby"}},
+	})
+
+	changes, stop := s.WatchPresence()
+	defer stop()
+
+	changed := func() bool {
+		select {
+		case <-changes:
+			return true
+		default:
+			return false
+		}
+	}
+
+	p := s.Presence()
+	if p.Listeners != 0 || len(p.Rooms) != 3 || p.Rooms[0].Room != "" || p.Rooms[1].Room != "stage" || p.Rooms[2].Room != "lobby" {
+		t.Fatalf("got %+v, want no listeners in the agenda's rooms", p)
+	}
+
+	// Listeners join
+	first := s.SubscribeWith(&SubscribeOptions{Room: "stage", Client: "10.0.0.1", Session: "abc"})
+	second := s.SubscribeWith(&SubscribeOptions{Room: "stage", Client: "10.0.0.2"})
+	other := s.SubscribeWith(&SubscribeOptions{Room: "balcony"})
+	admin := s.SubscribeWith(&SubscribeOptions{})
+
+	if !changed() {
+		t.Errorf("got no change signalled when listeners joined")
+	}
+
+	p = s.Presence()
+	want := map[string]int{"": 1, "stage": 2, "lobby": 0, "balcony": 1}
+	if got := roomCounts(p); p.Listeners != 4 || !maps.Equal(got, want) {
+		t.Errorf("got %d listeners in %v, want 4 in %v", p.Listeners, got, want)
+	}
+
+	stage := p.Rooms[1]
+	if stage.Listeners[0].Client != "10.0.0.1" || stage.Listeners[0].Session != sessionTag("abc") || stage.Listeners[1].Client != "10.0.0.2" {
+		t.Errorf("got stage listeners %+v %+v, want the first to join first", stage.Listeners[0], stage.Listeners[1])
+	}
+
+	// The session ID is not given away
+	if tag := stage.Listeners[0].Session; tag == "abc" || len(tag) != 8 {
+		t.Errorf("got session %q, want a tag of 8 hex digits", tag)
+	}
+	if tag := stage.Listeners[1].Session; tag != "" {
+		t.Errorf("got session %q for a listener without one, want none", tag)
+	}
+
+	// Listeners leave
+	first.Cancel()
+	other.Cancel()
+
+	if !changed() {
+		t.Errorf("got no change signalled when listeners left")
+	}
+
+	p = s.Presence()
+	if got := roomCounts(p); p.Listeners != 2 || got["stage"] != 1 || got["balcony"] != 0 {
+		t.Errorf("got %d listeners in %v, want one left on stage and none on the balcony", p.Listeners, got)
+	}
+
+	// The audience of the performance keeps the peak of each room
+	if occ := s.Audience().Room("stage"); occ == nil || occ.PeakListeners != 2 {
+		t.Errorf("got stage occupancy %+v, want a peak of 2", occ)
+	}
+
+	second.Cancel()
+	admin.Cancel()
+
+	// Watchers are told of the shutdown by their channel closing
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	for range changes {
+	}
+}
+
+func TestWatchPresenceStop(t *testing.T) {
+	s := newTestService(nil)
+
+	changes, stop := s.WatchPresence()
+	stop()
+
+	if _, ok := <-changes; ok {
+		t.Errorf("got a change after stopping, want the channel closed")
+	}
+
+	// Stopping again, or after the service has shut down, does nothing
+	stop()
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, stop = s.WatchPresence()
+	if _, ok := <-changes; ok {
+		t.Errorf("got a change after shutting down, want the channel closed")
+	}
+	stop()
+}
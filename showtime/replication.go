@@ -0,0 +1,657 @@
+package showtime
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Replication messages, sent by a primary to its followers (standbys and web
+// nodes) as lines of JSON
+const (
+
+	// replicationSync carries the whole cue history, which a follower adopts
+	// in place of its own.  It is sent when a follower connects.
+	replicationSync = "sync"
+
+	// replicationEntry carries a single change to the cue history, as it is
+	// journaled (see OpenJournal)
+	replicationEntry = "entry"
+
+	// replicationHeartbeat shows that the primary is alive when there are no
+	// changes to send
+	replicationHeartbeat = "heartbeat"
+
+	// replicationChallenge begins the handshake, by which each side proves
+	// to the other that it has the ReplicationKey.  The primary sends a
+	// nonce when a follower connects, and the follower answers with the MAC
+	// of it and a nonce of its own, which the primary answers in turn.
+	replicationChallenge = "challenge"
+)
+
+// replicationNonceSize is the number of random bytes in a handshake nonce
+const replicationNonceSize = 16
+
+// DefaultHeartbeatTimeout is the time without a message from the primary
+// after which a standby considers it lost, if the service does not set one
+const DefaultHeartbeatTimeout = 3 * time.Second
+
+// replicaBufferSize is the number of changes which may be queued for a
+// follower before it is disconnected, to reconnect and resynchronize
+const replicaBufferSize = 100
+
+// ErrStandby indicates that the service is following its primary (as a
+// standby or a web node), and so accepts no cues or changes of its own
+var ErrStandby = errors.New("showtime service is following its primary, which receives the cues")
+
+// ErrNotStandby indicates that a service which is not a standby was asked to
+// be promoted
+var ErrNotStandby = errors.New("showtime service is not a standby")
+
+// ErrReplicationKey indicates that the other side of a replication link does
+// not have the same ReplicationKey
+var ErrReplicationKey = errors.New("replication key does not match")
+
+// ErrEdge indicates that a web node (see Service.Edge) was asked to be
+// promoted
+var ErrEdge = errors.New("showtime service is a web node, which cannot be promoted")
+
+var (
+	metricStandby          *prometheus.GaugeVec
+	metricReplicasCount    *prometheus.GaugeVec
+	metricPrimaryConnected *prometheus.GaugeVec
+)
+
+func init() {
+	metricStandby = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "audimance_standby",
+		Help: "Whether the showtime service is a standby (1) or receives cues itself (0)",
+	}, []string{"show"})
+
+	metricReplicasCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "audimance_replicas_count",
+		Help: "Current number of standbys and web nodes following the cue history",
+	}, []string{"show"})
+
+	metricPrimaryConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "audimance_primary_connected",
+		Help: "Whether a standby or web node is connected to its primary (1) or not (0)",
+	}, []string{"show"})
+}
+
+// replicationMessage is a message from a primary to a follower
+type replicationMessage struct {
+	Type string `json:"type"`
+
+	// Times is the cue history, for a sync
+	Times []*Time `json:"times,omitempty"`
+
+	// Entry is the change to the cue history, for an entry
+	Entry *journalEntry `json:"entry,omitempty"`
+
+	// Nonce is the challenge to the other side, and MAC the answer to the
+	// other side's challenge, for a challenge
+	Nonce string `json:"nonce,omitempty"`
+	MAC   string `json:"mac,omitempty"`
+}
+
+// ReplicationStatus describes the part of the service in replication
+type ReplicationStatus struct {
+
+	// Standby indicates that the service is a standby, following the cue
+	// history of its primary
+	Standby bool `json:"standby"`
+
+	// Edge indicates that the service is a web node, following the cue
+	// history of its primary to serve more listeners
+	Edge bool `json:"edge"`
+
+	// Primary is the address of the primary which the service follows, or
+	// followed before it was promoted
+	Primary string `json:"primary,omitempty"`
+
+	// Connected indicates that a standby or web node is connected to its
+	// primary
+	Connected bool `json:"connected"`
+
+	// LastHeartbeat is the time at which a standby or web node last heard
+	// from its primary
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
+
+	// AutoPromote indicates that a standby will promote itself if it loses
+	// its primary
+	AutoPromote bool `json:"autoPromote"`
+
+	// Replicas are the addresses of the standbys and web nodes following the
+	// service
+	Replicas []string `json:"replicas"`
+}
+
+// replica is a follower connected to the service
+type replica struct {
+	addr string
+
+	// C carries the changes to be sent to the follower.  It is closed when
+	// the follower is disconnected for not keeping up.
+	C chan *replicationMessage
+
+	closed bool
+}
+
+// replication holds the replication state of the Service.  It is guarded by
+// the service lock.
+type replication struct {
+
+	// replicas are the followers of the service
+	replicas []*replica
+
+	// connected indicates that the follower is connected to its primary
+	connected bool
+
+	// linked indicates that the standby has been connected to its primary
+	// at some time, so that losing it is a failure rather than a primary
+	// which has not yet started
+	linked bool
+
+	lastHeartbeat time.Time
+
+	// promoted is closed when the standby is promoted
+	promoted chan struct{}
+
+	// cancel stops following the primary
+	cancel context.CancelFunc
+}
+
+// Replication returns the part of the service in replication
+func (s *Service) Replication() *ReplicationStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := &ReplicationStatus{
+		Standby:     s.standby && !s.Edge,
+		Edge:        s.Edge,
+		Primary:     s.Primary,
+		Connected:   s.replication.connected,
+		AutoPromote: s.AutoPromote && !s.Edge,
+		Replicas:    make([]string, 0, len(s.replication.replicas)),
+	}
+
+	if !s.replication.lastHeartbeat.IsZero() {
+		last := s.replication.lastHeartbeat
+		status.LastHeartbeat = &last
+	}
+
+	for _, r := range s.replication.replicas {
+		status.Replicas = append(status.Replicas, r.addr)
+	}
+
+	return status
+}
+
+// Promote makes a standby take over from its primary: it stops following the
+// primary and starts its own cue sources and schedule.  It fails with
+// ErrNotStandby if the service is not a standby, or ErrEdge if it is a web
+// node.
+func (s *Service) Promote() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Edge {
+		return ErrEdge
+	}
+
+	if !s.standby {
+		return ErrNotStandby
+	}
+
+	s.standby = false
+	s.replication.connected = false
+	s.replication.cancel()
+	close(s.replication.promoted)
+
+	metricStandby.WithLabelValues(s.Name).Set(0)
+	metricPrimaryConnected.WithLabelValues(s.Name).Set(0)
+
+	s.Echo.Logger.Warnf("promoted from standby of %s; now receiving cues", s.Primary)
+
+	return nil
+}
+
+// follow begins following the Primary, if the service has one, returning a
+// channel which is closed when the service should receive cues itself: at
+// once, for a service which is not a standby, or else when it is promoted.
+func (s *Service) follow(ctx context.Context) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	promoted := make(chan struct{})
+	s.replication.promoted = promoted
+
+	if s.Primary == "" {
+		close(promoted)
+		return promoted
+	}
+
+	ctx, s.replication.cancel = context.WithCancel(ctx)
+	s.standby = true
+
+	if !s.Edge {
+		metricStandby.WithLabelValues(s.Name).Set(1)
+	}
+	metricPrimaryConnected.WithLabelValues(s.Name).Set(0)
+
+	go s.followPrimary(ctx)
+
+	return promoted
+}
+
+// followPrimary keeps the follower connected to its primary until the
+// context is cancelled, promoting a standby if it loses the primary and
+// AutoPromote is set.  A web node reconnects, serving the last cue history it
+// was sent meanwhile.
+func (s *Service) followPrimary(ctx context.Context) {
+	for {
+		err := s.replicate(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		s.mu.Lock()
+		linked := s.replication.linked
+		s.replication.connected = false
+		s.mu.Unlock()
+
+		metricPrimaryConnected.WithLabelValues(s.Name).Set(0)
+
+		if linked {
+			s.Echo.Logger.Errorf("lost primary %s: %s", s.Primary, err.Error())
+
+			if s.AutoPromote && !s.Edge {
+				if err := s.Promote(); err != nil && !errors.Is(err, ErrNotStandby) {
+					s.Echo.Logger.Error(err)
+				}
+				return
+			}
+		} else {
+			s.Echo.Logger.Warnf("failed to reach primary %s: %s", s.Primary, err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// replicate connects to the primary and applies the changes it sends to the
+// cue history until the connection fails or the context is cancelled.
+func (s *Service) replicate(ctx context.Context) error {
+	timeout := s.heartbeatTimeout()
+
+	var d net.Dialer
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	conn, err := d.DialContext(dialCtx, "tcp", s.Primary)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() }) //nolint: errcheck
+	defer stop()
+	defer conn.Close() //nolint: errcheck
+
+	dec := json.NewDecoder(conn)
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+	if err := s.answerChallenge(json.NewEncoder(conn), dec); err != nil {
+		return err
+	}
+	if err := conn.SetWriteDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+
+		msg := new(replicationMessage)
+		if err := dec.Decode(msg); err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return fmt.Errorf("no heartbeat for %s", timeout)
+			}
+			return err
+		}
+
+		if err := s.applyReplication(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// answerChallenge completes the follower's side of the handshake with the
+// primary, proving that it has the ReplicationKey and that the primary has it
+// too.
+func (s *Service) answerChallenge(enc *json.Encoder, dec *json.Decoder) error {
+	challenge := new(replicationMessage)
+	if err := dec.Decode(challenge); err != nil {
+		return fmt.Errorf("failed to read challenge: %w", err)
+	}
+	if challenge.Type != replicationChallenge {
+		return fmt.Errorf("expected challenge from primary %s, got %q", s.Primary, challenge.Type)
+	}
+
+	nonce, err := newReplicationNonce()
+	if err != nil {
+		return err
+	}
+
+	if err := enc.Encode(&replicationMessage{
+		Type:  replicationChallenge,
+		Nonce: nonce,
+		MAC:   s.replicationMAC("follower", challenge.Nonce),
+	}); err != nil {
+		return fmt.Errorf("failed to answer challenge: %w", err)
+	}
+
+	answer := new(replicationMessage)
+	if err := dec.Decode(answer); err != nil {
+		return fmt.Errorf("failed to read answer to challenge: %w", err)
+	}
+	if answer.Type != replicationChallenge || !hmac.Equal([]byte(answer.MAC), []byte(s.replicationMAC("primary", nonce))) {
+		return fmt.Errorf("primary %s failed the challenge: %w", s.Primary, ErrReplicationKey)
+	}
+
+	return nil
+}
+
+// challengeFollower completes the primary's side of the handshake with a
+// follower, proving that the follower has the ReplicationKey and that the
+// primary has it too.
+func (s *Service) challengeFollower(enc *json.Encoder, dec *json.Decoder) error {
+	nonce, err := newReplicationNonce()
+	if err != nil {
+		return err
+	}
+
+	if err := enc.Encode(&replicationMessage{Type: replicationChallenge, Nonce: nonce}); err != nil {
+		return fmt.Errorf("failed to send challenge: %w", err)
+	}
+
+	answer := new(replicationMessage)
+	if err := dec.Decode(answer); err != nil {
+		return fmt.Errorf("failed to read answer to challenge: %w", err)
+	}
+	if answer.Type != replicationChallenge || !hmac.Equal([]byte(answer.MAC), []byte(s.replicationMAC("follower", nonce))) {
+		return ErrReplicationKey
+	}
+
+	if err := enc.Encode(&replicationMessage{
+		Type: replicationChallenge,
+		MAC:  s.replicationMAC("primary", answer.Nonce),
+	}); err != nil {
+		return fmt.Errorf("failed to answer challenge: %w", err)
+	}
+
+	return nil
+}
+
+// replicationMAC returns the MAC, by the ReplicationKey, of the given nonce
+// as answered by the given side of the link, so that one side's answer cannot
+// be replayed as the other's
+func (s *Service) replicationMAC(side string, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(s.ReplicationKey))
+	mac.Write([]byte(side + ":" + nonce)) //nolint: errcheck
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newReplicationNonce returns a random nonce for the replication handshake
+func newReplicationNonce() (string, error) {
+	b := make([]byte, replicationNonceSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// applyReplication applies a message from the primary to the cue history of
+// the follower, which is journaled, announced to its subscribers, and passed
+// on to any followers of its own.
+func (s *Service) applyReplication(msg *replicationMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Changes which arrive after a standby has been promoted are its own
+	// business
+	if !s.standby {
+		return ErrNotStandby
+	}
+
+	if !s.replication.connected {
+		s.Echo.Logger.Infof("following primary %s", s.Primary)
+		metricPrimaryConnected.WithLabelValues(s.Name).Set(1)
+	}
+	s.replication.connected = true
+	s.replication.linked = true
+	s.replication.lastHeartbeat = time.Now()
+
+	switch msg.Type {
+	case replicationSync:
+		if slices.EqualFunc(s.Times, msg.Times, func(a, b *Time) bool {
+			return a.Cue == b.Cue && a.Origin == b.Origin && a.Received.Equal(b.Received)
+		}) {
+			return nil
+		}
+
+		s.adopt(msg.Times)
+		s.announce(SyncNotification)
+
+		s.Echo.Logger.Infof("adopted cue history of %d cues from primary %s", len(msg.Times), s.Primary)
+	case replicationEntry:
+		if msg.Entry == nil {
+			return fmt.Errorf("replicated entry missing from primary %s", s.Primary)
+		}
+
+		s.record(msg.Entry)
+		s.announce(msg.Entry.cause())
+
+		s.Echo.Logger.Infof("replicated %s %q from primary %s", msg.Entry.cause(), msg.Entry.Cue, s.Primary)
+	}
+
+	return nil
+}
+
+// adopt replaces the cue history of the follower with its primary's, which is
+// journaled and passed on whole to any followers of its own.  Unlike a reset,
+// it does not begin a new performance: the audience, which the follower counts
+// for itself, is kept.  The caller must hold the service lock.
+func (s *Service) adopt(times []*Time) {
+	var entries []*journalEntry
+	if len(s.Times) > 0 {
+		entries = append(entries, &journalEntry{Op: journalReset, Received: time.Now()})
+	}
+	for _, t := range times {
+		entries = append(entries, &journalEntry{Cue: t.Cue, Received: t.Received, Origin: t.Origin})
+	}
+
+	for _, entry := range entries {
+		s.Times = entry.apply(s.Times)
+
+		if err := s.writeJournal(entry); err != nil {
+			s.Echo.Logger.Error(fmt.Errorf("failed to journal change to cue history: %w", err))
+		}
+	}
+
+	s.sendReplicasMessage(&replicationMessage{
+		Type:  replicationSync,
+		Times: slices.Clone(s.Times),
+	})
+}
+
+// heartbeatTimeout returns the time without a message from the primary after
+// which a follower considers it lost
+func (s *Service) heartbeatTimeout() time.Duration {
+	if s.HeartbeatTimeout > 0 {
+		return s.HeartbeatTimeout
+	}
+
+	return DefaultHeartbeatTimeout
+}
+
+// serveReplicas accepts followers on the ReplicaAddr until the context is
+// cancelled, sending each the cue history and then every change to it.
+func (s *Service) serveReplicas(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.ReplicaAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for followers: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { ln.Close() }) //nolint: errcheck
+	defer stop()
+
+	s.Echo.Logger.Infof("serving followers on %s", s.ReplicaAddr)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept follower: %w", err)
+		}
+
+		go s.serveReplica(ctx, conn)
+	}
+}
+
+// serveReplica sends the cue history and then every change to it to the
+// follower on the given connection, with heartbeats between, until the
+// connection fails or the context is cancelled.
+func (s *Service) serveReplica(ctx context.Context, conn net.Conn) {
+	defer conn.Close() //nolint: errcheck
+
+	addr := conn.RemoteAddr().String()
+
+	enc := json.NewEncoder(conn)
+
+	if err := conn.SetDeadline(time.Now().Add(s.heartbeatTimeout())); err != nil {
+		return
+	}
+	if err := s.challengeFollower(enc, json.NewDecoder(conn)); err != nil {
+		s.Echo.Logger.Warnf("refusing follower %s: %s", addr, err.Error())
+		return
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	r := &replica{
+		addr: addr,
+		C:    make(chan *replicationMessage, replicaBufferSize),
+	}
+
+	s.mu.Lock()
+	sync := &replicationMessage{
+		Type:  replicationSync,
+		Times: slices.Clone(s.Times),
+	}
+	s.replication.replicas = append(s.replication.replicas, r)
+	metricReplicasCount.WithLabelValues(s.Name).Set(float64(len(s.replication.replicas)))
+	s.mu.Unlock()
+
+	defer s.removeReplica(r)
+
+	s.Echo.Logger.Infof("follower %s connected", r.addr)
+
+	interval := s.heartbeatTimeout() / 3
+
+	send := func(msg *replicationMessage) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(s.heartbeatTimeout())); err != nil {
+			return err
+		}
+		return enc.Encode(msg)
+	}
+
+	if err := send(sync); err != nil {
+		s.Echo.Logger.Errorf("failed to send cue history to follower %s: %s", r.addr, err.Error())
+		return
+	}
+
+	heartbeat := time.NewTicker(interval)
+	defer heartbeat.Stop()
+
+	for {
+		var msg *replicationMessage
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-r.C:
+			if !ok {
+				s.Echo.Logger.Warnf("disconnecting follower %s which was not keeping up", r.addr)
+				return
+			}
+			msg = m
+		case <-heartbeat.C:
+			msg = &replicationMessage{Type: replicationHeartbeat}
+		}
+
+		if err := send(msg); err != nil {
+			s.Echo.Logger.Errorf("lost follower %s: %s", r.addr, err.Error())
+			return
+		}
+	}
+}
+
+// removeReplica forgets the given follower
+func (s *Service) removeReplica(r *replica) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replication.replicas = slices.DeleteFunc(s.replication.replicas, func(other *replica) bool {
+		return other == r
+	})
+	metricReplicasCount.WithLabelValues(s.Name).Set(float64(len(s.replication.replicas)))
+
+	s.Echo.Logger.Infof("follower %s disconnected", r.addr)
+}
+
+// sendReplicas sends the given change to the cue history to every follower.
+// A follower which is not keeping up is disconnected, to reconnect and
+// resynchronize.  The caller must hold the service lock.
+func (s *Service) sendReplicas(entry *journalEntry) {
+	s.sendReplicasMessage(&replicationMessage{Type: replicationEntry, Entry: entry})
+}
+
+// sendReplicasMessage sends the given message to every follower, as
+// sendReplicas.  The caller must hold the service lock.
+func (s *Service) sendReplicasMessage(msg *replicationMessage) {
+	for _, r := range s.replication.replicas {
+		if r.closed {
+			continue
+		}
+
+		select {
+		case r.C <- msg:
+		default:
+			r.closed = true
+			close(r.C)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package showtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address on which nothing is listening
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close() //nolint: errcheck
+
+	return ln.Addr().String()
+}
+
+// runService runs the given service until the test ends
+func runService(t *testing.T, s *Service) context.CancelFunc {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx) //nolint: errcheck
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return cancel
+}
+
+// waitFor waits for the given condition to hold
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplication(t *testing.T) {
+	primary := newTestService(nil)
+	primary.ReplicaAddr = freeAddr(t)
+	primary.ReplicationKey = "secret"
+	if err := primary.Trigger("a", "test"); err != nil {
+		t.Fatal(err)
+	}
+	runService(t, primary)
+
+	standby := newTestService(nil)
+	standby.Primary = primary.ReplicaAddr
+	standby.ReplicationKey = "secret"
+	standby.HeartbeatTimeout = time.Second
+	if err := standby.Trigger("stale", "test"); err != nil {
+		t.Fatal(err)
+	}
+	runService(t, standby)
+
+	// The standby adopts the primary's cue history, and then follows its
+	// changes
+	waitFor(t, "the standby to sync", func() bool {
+		return slices.Equal(cueNames(standby.History()), []string{"a"})
+	})
+	if got := standby.Replication(); !got.Standby || !got.Connected {
+		t.Errorf("got %+v, want a connected standby", got)
+	}
+	waitFor(t, "the primary to list its standby", func() bool {
+		return len(primary.Replication().Replicas) == 1
+	})
+
+	if err := primary.Trigger("b", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := primary.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if err := primary.Trigger("c", "test"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the standby to follow", func() bool {
+		return slices.Equal(cueNames(standby.History()), []string{"a", "c"})
+	})
+
+	// A standby accepts no cues of its own until it is promoted
+	if err := standby.Trigger("d", "test"); !errors.Is(err, ErrStandby) {
+		t.Errorf("got %v, want %v", err, ErrStandby)
+	}
+
+	if err := standby.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.Promote(); !errors.Is(err, ErrNotStandby) {
+		t.Errorf("got %v promoting again, want %v", err, ErrNotStandby)
+	}
+	if err := standby.Trigger("d", "test"); err != nil {
+		t.Errorf("got %v, want success once promoted", err)
+	}
+
+	// The promoted standby no longer follows the primary
+	if err := primary.Trigger("e", "test"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := cueNames(standby.History()); !slices.Equal(got, []string{"a", "c", "d"}) {
+		t.Errorf("got %v, want [a c d]", got)
+	}
+}
+
+func TestAutoPromote(t *testing.T) {
+	// The primary sends its cue history, and then falls silent
+	primary := newTestService(nil)
+	primary.ReplicationKey = "secret"
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close() //nolint: errcheck
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close() //nolint: errcheck
+
+		if err := primary.challengeFollower(json.NewEncoder(conn), json.NewDecoder(conn)); err != nil {
+			return
+		}
+		conn.Write([]byte(`{"type":"sync","times":[{"cue":"a","received":"2024-05-04T19:30:00Z"}]}` + "\n")) //nolint: errcheck
+		<-done
+	}()
+
+	standby := newTestService(nil)
+	standby.Primary = ln.Addr().String()
+	standby.ReplicationKey = "secret"
+	standby.HeartbeatTimeout = 100 * time.Millisecond
+	standby.AutoPromote = true
+	runService(t, standby)
+
+	waitFor(t, "the standby to sync", func() bool {
+		return slices.Equal(cueNames(standby.History()), []string{"a"})
+	})
+
+	// Having missed its heartbeats, it takes over
+	waitFor(t, "the standby to promote itself", func() bool {
+		return !standby.Replication().Standby
+	})
+	if err := standby.Trigger("b", "test"); err != nil {
+		t.Errorf("got %v, want success once promoted", err)
+	}
+}
+
+func TestEdge(t *testing.T) {
+	addr := freeAddr(t)
+
+	primary := newTestService(nil)
+	primary.ReplicaAddr = addr
+	primary.ReplicationKey = "secret"
+	if err := primary.Trigger("a", "test"); err != nil {
+		t.Fatal(err)
+	}
+	stopPrimary := runService(t, primary)
+
+	edge := newTestService(nil)
+	edge.Primary = addr
+	edge.ReplicationKey = "secret"
+	edge.Edge = true
+	edge.AutoPromote = true
+	edge.HeartbeatTimeout = 100 * time.Millisecond
+	runService(t, edge)
+
+	waitFor(t, "the web node to sync", func() bool {
+		return slices.Equal(cueNames(edge.History()), []string{"a"})
+	})
+	if got := edge.Replication(); !got.Edge || got.Standby || got.AutoPromote {
+		t.Errorf("got %+v, want a web node", got)
+	}
+
+	if err := edge.Promote(); !errors.Is(err, ErrEdge) {
+		t.Errorf("got %v, want %v", err, ErrEdge)
+	}
+
+	// Losing its primary, a web node keeps serving the last cue history it
+	// was sent, rather than taking over
+	stopPrimary()
+	waitFor(t, "the web node to lose its primary", func() bool {
+		return !edge.Replication().Connected
+	})
+	if err := edge.Trigger("b", "test"); !errors.Is(err, ErrStandby) {
+		t.Errorf("got %v, want %v", err, ErrStandby)
+	}
+	if got := cueNames(edge.History()); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("got %v, want [a]", got)
+	}
+
+	// It follows the primary again once the primary is back
+	restarted := newTestService(nil)
+	restarted.ReplicaAddr = addr
+	restarted.ReplicationKey = "secret"
+	if err := restarted.Trigger("c", "test"); err != nil {
+		t.Fatal(err)
+	}
+	runService(t, restarted)
+
+	waitFor(t, "the web node to follow the restarted primary", func() bool {
+		return slices.Equal(cueNames(edge.History()), []string{"c"})
+	})
+}
+
+func TestReplicationHandshake(t *testing.T) {
+	tests := []struct {
+		name        string
+		followerKey string
+		wantErr     bool
+	}{
+		{"same key", "secret", false},
+		{"different key", "guess", true},
+		{"no key", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := newTestService(nil)
+			primary.ReplicationKey = "secret"
+
+			follower := newTestService(nil)
+			follower.ReplicationKey = tt.followerKey
+
+			primaryConn, followerConn := net.Pipe()
+			defer followerConn.Close() //nolint: errcheck
+
+			primaryErr := make(chan error, 1)
+			go func() {
+				defer primaryConn.Close() //nolint: errcheck
+				primaryErr <- primary.challengeFollower(json.NewEncoder(primaryConn), json.NewDecoder(primaryConn))
+			}()
+
+			err := follower.answerChallenge(json.NewEncoder(followerConn), json.NewDecoder(followerConn))
+			if got := err != nil; got != tt.wantErr {
+				t.Errorf("follower got %v, want error %v", err, tt.wantErr)
+			}
+
+			// The primary refuses a follower whose MAC does not match
+			err = <-primaryErr
+			switch {
+			case tt.wantErr && !errors.Is(err, ErrReplicationKey):
+				t.Errorf("primary got %v, want %v", err, ErrReplicationKey)
+			case !tt.wantErr && err != nil:
+				t.Errorf("primary got %v, want success", err)
+			}
+		})
+	}
+}
+
+func TestReplicationKeyMismatch(t *testing.T) {
+	// A follower with the wrong key is never given the cue history
+	primary := newTestService(nil)
+	primary.ReplicaAddr = freeAddr(t)
+	primary.ReplicationKey = "secret"
+	if err := primary.Trigger("a", "test"); err != nil {
+		t.Fatal(err)
+	}
+	runService(t, primary)
+
+	standby := newTestService(nil)
+	standby.Primary = primary.ReplicaAddr
+	standby.ReplicationKey = "guess"
+	standby.HeartbeatTimeout = 100 * time.Millisecond
+	runService(t, standby)
+
+	time.Sleep(300 * time.Millisecond)
+	if got := standby.History(); len(got) > 0 {
+		t.Errorf("got history %v, want none", cueNames(got))
+	}
+	if got := standby.Replication(); got.Connected || !got.Standby {
+		t.Errorf("got %+v, want a disconnected standby", got)
+	}
+}
+
+func TestReplicationKeepsAudience(t *testing.T) {
+	primary := newTestService(nil)
+	primary.ReplicaAddr = freeAddr(t)
+	primary.ReplicationKey = "secret"
+	if err := primary.Trigger("a", "test"); err != nil {
+		t.Fatal(err)
+	}
+	runService(t, primary)
+
+	// The standby has listeners of its own, and a cue history which differs
+	// from the primary's
+	standby := newTestService(nil)
+	standby.Primary = primary.ReplicaAddr
+	standby.ReplicationKey = "secret"
+	if err := standby.Trigger("stale", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	standby.audience.reset(time.Now().Add(-time.Hour))
+
+	sub := standby.SubscribeWith(&SubscribeOptions{Room: "stage"})
+	defer sub.Cancel()
+	standby.VisitRoom("stage")
+	before := standby.Audience()
+
+	runService(t, standby)
+	waitFor(t, "the standby to sync", func() bool {
+		return slices.Equal(cueNames(standby.History()), []string{"a"})
+	})
+
+	// Adopting the primary's cue history does not begin a new performance
+	after := standby.Audience()
+	if !after.Since.Equal(before.Since) || after.PeakListeners != 1 {
+		t.Errorf("got audience since %s with a peak of %d, want since %s with a peak of 1", after.Since, after.PeakListeners, before.Since)
+	}
+	if occ := after.Room("stage"); occ == nil || occ.Visits != 1 || occ.PeakListeners != 1 {
+		t.Errorf("got stage occupancy %+v, want 1 visit and a peak of 1", occ)
+	}
+
+	// The primary resetting its cue history does
+	if err := primary.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the standby to reset", func() bool {
+		return len(standby.History()) == 0
+	})
+	if got := standby.Audience(); got.Since.Equal(before.Since) || got.Room("stage") != nil {
+		t.Errorf("got audience %+v, want a new one", got)
+	}
+}
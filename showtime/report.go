@@ -0,0 +1,354 @@
+package showtime
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+// Report compares the cue history of a performance with its agenda, as for a
+// stage manager's show report
+type Report struct {
+
+	// Title is the title of the show
+	Title string `json:"title"`
+
+	// Generated is the time at which the report was made
+	Generated time.Time `json:"generated"`
+
+	// Start and End are the times of the first and last cues of the
+	// performance
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// Cues describes each cue of the agenda, in order
+	Cues []*CueReport `json:"cues"`
+
+	// Missed and Repeated count the cues of the agenda which were never
+	// triggered and those which were triggered more than once
+	Missed   int `json:"missed"`
+	Repeated int `json:"repeated"`
+
+	// Unexpected lists the triggered cue data which matched no cue of the
+	// agenda
+	Unexpected []*Time `json:"unexpected"`
+
+	// Audience describes the audience of the performance, if it is known
+	Audience *Audience `json:"audience,omitempty"`
+
+	// Rooms is the occupancy of each room of the agenda, if the audience is
+	// known, along with any other rooms which listeners entered
+	Rooms []*RoomOccupancy `json:"rooms,omitempty"`
+}
+
+// CueReport describes the performance of a cue of the agenda
+type CueReport struct {
+
+	// ID, Name, and Data identify the cue
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Data string `json:"data"`
+
+	// Triggered lists the wall-clock times at which the cue was triggered
+	Triggered []time.Time `json:"triggered"`
+
+	// OffsetSeconds is the time from the start of the performance at which
+	// the cue was first triggered
+	OffsetSeconds float64 `json:"offsetSeconds"`
+
+	// ActualSeconds is the time from when the cue was first triggered until
+	// another cue was, if one was
+	ActualSeconds *float64 `json:"actualSeconds"`
+
+	// ReferenceSeconds is the time the cue should last before the next one,
+	// from the agenda, if given
+	ReferenceSeconds int64 `json:"referenceSeconds"`
+
+	// DifferenceSeconds is the ActualSeconds less the ReferenceSeconds, if
+	// both are known
+	DifferenceSeconds *float64 `json:"differenceSeconds"`
+}
+
+// Status describes the cue as "missed", "repeated", or "ok"
+func (c *CueReport) Status() string {
+	switch {
+	case len(c.Triggered) < 1:
+		return "missed"
+	case len(c.Triggered) > 1:
+		return "repeated"
+	default:
+		return "ok"
+	}
+}
+
+// NewReport compares the given cue history of a performance with the given
+// agenda.  The audience is included if it is not nil.
+func NewReport(a *agenda.Agenda, times []*Time, aud *Audience) *Report {
+	r := &Report{
+		Generated:  time.Now(),
+		Cues:       []*CueReport{},
+		Unexpected: []*Time{},
+		Audience:   aud,
+	}
+
+	if len(times) > 0 {
+		r.Start = times[0].Received
+		r.End = times[len(times)-1].Received
+	}
+
+	if a == nil {
+		a = new(agenda.Agenda)
+	}
+	r.Title = a.Title
+
+	expected := make(map[string]bool)
+	for _, c := range a.Cues {
+		cr := &CueReport{
+			ID:               c.ID,
+			Name:             c.Name,
+			Data:             c.Data,
+			Triggered:        []time.Time{},
+			ReferenceSeconds: c.ReferenceSeconds,
+		}
+		r.Cues = append(r.Cues, cr)
+
+		data := NormalizeCueData(c.Data)
+		expected[data] = true
+
+		first := -1
+		for i, t := range times {
+			if t.Cue != data {
+				continue
+			}
+			if first < 0 {
+				first = i
+			}
+			cr.Triggered = append(cr.Triggered, t.Received)
+		}
+
+		switch cr.Status() {
+		case "missed":
+			r.Missed++
+			continue
+		case "repeated":
+			r.Repeated++
+		}
+
+		cr.OffsetSeconds = roundSeconds(times[first].Received.Sub(r.Start))
+
+		// The cue lasts until another is triggered; repeats of it do not end
+		// it
+		for _, t := range times[first+1:] {
+			if t.Cue == data {
+				continue
+			}
+
+			gap := t.Received.Sub(times[first].Received)
+
+			actual := roundSeconds(gap)
+			cr.ActualSeconds = &actual
+
+			if cr.ReferenceSeconds > 0 {
+				diff := roundSeconds(gap - time.Duration(cr.ReferenceSeconds)*time.Second)
+				cr.DifferenceSeconds = &diff
+			}
+			break
+		}
+	}
+
+	for _, t := range times {
+		if !expected[t.Cue] {
+			r.Unexpected = append(r.Unexpected, t)
+		}
+	}
+
+	if aud != nil {
+		rooms := make(map[string]bool)
+		for _, room := range a.Rooms {
+			rooms[room.Name] = true
+
+			occ := &RoomOccupancy{Room: room.Name}
+			if o := aud.Room(room.Name); o != nil {
+				occ = o
+			}
+			r.Rooms = append(r.Rooms, occ)
+		}
+
+		for _, o := range aud.Rooms {
+			if !rooms[o.Room] {
+				r.Rooms = append(r.Rooms, o)
+			}
+		}
+	}
+
+	return r
+}
+
+// roundSeconds returns the given duration in seconds, to the millisecond
+func roundSeconds(d time.Duration) float64 {
+	return d.Round(time.Millisecond).Seconds()
+}
+
+// Report compares the current cue history with the agenda, along with the
+// audience of the current performance
+func (s *Service) Report() *Report {
+	return NewReport(s.agenda.Load(), s.History(), s.Audience())
+}
+
+// reportTemplate renders a Report as a standalone page
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"clock": func(t time.Time) string {
+		if t.IsZero() {
+			return "—"
+		}
+		return t.Local().Format("15:04:05")
+	},
+	"seconds": func(v *float64) string {
+		if v == nil {
+			return "—"
+		}
+		return strconv.FormatFloat(*v, 'f', 1, 64)
+	},
+}).Parse(`<!doctype html>
+<html lang="en">
+<head>
+	<meta charset="utf-8">
+	<title>Show report{{with .Title}}: {{.}}{{end}}</title>
+	<style>
+		body { font-family: sans-serif; }
+		table { border-collapse: collapse; margin-bottom: 1.5em; }
+		th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+		tr.missed { background: #fdd; }
+		tr.repeated { background: #ffd; }
+	</style>
+</head>
+<body>
+	<h2>Show report{{with .Title}}: {{.}}{{end}}</h2>
+	<p>
+		{{if .Start.IsZero}}No cues were triggered.{{else}}Performance from {{clock .Start}} to {{clock .End}} on {{.Start.Local.Format "Monday, January 2, 2006"}}.{{end}}
+		{{.Missed}} cues missed; {{.Repeated}} repeated.
+		Generated {{.Generated.Local.Format "2006-01-02 15:04:05"}}.
+	</p>
+
+	<h3>Cues</h3>
+	<table>
+		<tr>
+			<th>Cue</th><th>Triggered</th><th>Offset (s)</th><th>Actual (s)</th><th>Reference (s)</th><th>Difference (s)</th><th>Status</th>
+		</tr>
+		{{range .Cues}}
+		<tr class="{{.Status}}">
+			<td>{{.Name}}</td>
+			<td>{{range $i, $t := .Triggered}}{{if $i}}, {{end}}{{clock $t}}{{else}}—{{end}}</td>
+			<td>{{if .Triggered}}{{printf "%.1f" .OffsetSeconds}}{{else}}—{{end}}</td>
+			<td>{{seconds .ActualSeconds}}</td>
+			<td>{{if .ReferenceSeconds}}{{.ReferenceSeconds}}{{else}}—{{end}}</td>
+			<td>{{seconds .DifferenceSeconds}}</td>
+			<td>{{.Status}}</td>
+		</tr>
+		{{end}}
+	</table>
+
+	{{if .Unexpected}}
+	<h3>Unexpected cue data</h3>
+	<table>
+		<tr><th>Data</th><th>Triggered</th><th>Origin</th></tr>
+		{{range .Unexpected}}
+		<tr><td>{{.Cue}}</td><td>{{clock .Received}}</td><td>{{.Origin}}</td></tr>
+		{{end}}
+	</table>
+	{{end}}
+
+	<h3>Audience</h3>
+	{{with .Audience}}
+	<p>Peak of {{.PeakListeners}} listeners{{if not .PeakTime.IsZero}} at {{clock .PeakTime}}{{end}}.</p>
+	{{else}}
+	<p>The audience of this performance was not recorded.</p>
+	{{end}}
+	{{if .Rooms}}
+	<table>
+		<tr><th>Room</th><th>Visits</th><th>Peak listeners</th><th>Peak at</th></tr>
+		{{range .Rooms}}
+		<tr><td>{{.Room}}</td><td>{{.Visits}}</td><td>{{.PeakListeners}}</td><td>{{clock .PeakTime}}</td></tr>
+		{{end}}
+	</table>
+	{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a standalone HTML page
+func (r *Report) WriteHTML(w io.Writer) error {
+	if err := reportTemplate.Execute(w, r); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	return nil
+}
+
+// WriteCSV writes the report as CSV: a row for each cue of the agenda, then
+// one for each unexpected cue, then, if the audience is known, the peak
+// number of listeners and a row for each room, each section with a header
+// and separated from the last by an empty row.
+func (r *Report) WriteCSV(w io.Writer) error {
+	formatSeconds := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+
+	records := [][]string{
+		{"cue", "id", "data", "triggered", "offset_seconds", "actual_seconds", "reference_seconds", "difference_seconds", "triggers", "status"},
+	}
+	for _, c := range r.Cues {
+		row := []string{c.Name, c.ID, c.Data, "", "", formatSeconds(c.ActualSeconds), "", formatSeconds(c.DifferenceSeconds), strconv.Itoa(len(c.Triggered)), c.Status()}
+		if len(c.Triggered) > 0 {
+			row[3] = c.Triggered[0].Format(time.RFC3339Nano)
+			row[4] = strconv.FormatFloat(c.OffsetSeconds, 'f', -1, 64)
+		}
+		if c.ReferenceSeconds > 0 {
+			row[6] = strconv.FormatInt(c.ReferenceSeconds, 10)
+		}
+		records = append(records, row)
+	}
+
+	if len(r.Unexpected) > 0 {
+		records = append(records, []string{}, []string{"unexpected_data", "triggered", "origin"})
+		for _, t := range r.Unexpected {
+			records = append(records, []string{t.Cue, t.Received.Format(time.RFC3339Nano), t.Origin})
+		}
+	}
+
+	if r.Audience != nil {
+		peakTime := ""
+		if !r.Audience.PeakTime.IsZero() {
+			peakTime = r.Audience.PeakTime.Format(time.RFC3339Nano)
+		}
+
+		records = append(records,
+			[]string{},
+			[]string{"peak_listeners", "peak_time"},
+			[]string{strconv.Itoa(r.Audience.PeakListeners), peakTime},
+			[]string{},
+			[]string{"room", "visits", "peak_listeners", "peak_time"},
+		)
+		for _, o := range r.Rooms {
+			peakTime := ""
+			if !o.PeakTime.IsZero() {
+				peakTime = o.PeakTime.Format(time.RFC3339Nano)
+			}
+			records = append(records, []string{o.Room, strconv.Itoa(o.Visits), strconv.Itoa(o.PeakListeners), peakTime})
+		}
+	}
+
+	if err := csv.NewWriter(w).WriteAll(records); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+package showtime
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+func TestNewReport(t *testing.T) {
+	a := &agenda.Agenda{
+		Title: "Show",
+		Cues: []*agenda.Cue{
+			{ID: "1", Name: "intro", Data: "blah", ReferenceSeconds: 60},
+			{ID: "2", Name: "erste", Data: "blah, blah\n", ReferenceSeconds: 120},
+			{ID: "3", Name: "zweite", Data: "blah, blah, blah"},
+		},
+	}
+
+	start := time.Date(2024, 5, 4, 19, 30, 0, 0, time.UTC)
+	at := func(cue string, seconds float64) *Time {
+		return &Time{Cue: cue, Received: start.Add(time.Duration(seconds * float64(time.Second)))}
+	}
+
+	// cue describes the expected report of a cue: its status, and its
+	// actual and difference seconds, if known
+	type cue struct {
+		status     string
+		offset     float64
+		actual     *float64
+		difference *float64
+	}
+	seconds := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name           string
+		times          []*Time
+		wantCues       []cue
+		wantMissed     int
+		wantRepeated   int
+		wantUnexpected []string
+	}{
+		{
+			name: "no cues",
+			wantCues: []cue{
+				{status: "missed"},
+				{status: "missed"},
+				{status: "missed"},
+			},
+			wantMissed:     3,
+			wantUnexpected: []string{},
+		},
+		{
+			name:  "every cue on time",
+			times: []*Time{at("blah", 0), at("blah, blah", 60), at("blah, blah, blah", 180)},
+			wantCues: []cue{
+				{status: "ok", offset: 0, actual: seconds(60), difference: seconds(0)},
+				{status: "ok", offset: 60, actual: seconds(120), difference: seconds(0)},
+				{status: "ok", offset: 180},
+			},
+			wantUnexpected: []string{},
+		},
+		{
+			name:  "early and late",
+			times: []*Time{at("blah", 0), at("blah, blah", 55.5), at("blah, blah, blah", 190)},
+			wantCues: []cue{
+				{status: "ok", offset: 0, actual: seconds(55.5), difference: seconds(-4.5)},
+				{status: "ok", offset: 55.5, actual: seconds(134.5), difference: seconds(14.5)},
+				{status: "ok", offset: 190},
+			},
+			wantUnexpected: []string{},
+		},
+		{
+			name:  "missed cue",
+			times: []*Time{at("blah", 0), at("blah, blah, blah", 200)},
+			wantCues: []cue{
+				{status: "ok", offset: 0, actual: seconds(200), difference: seconds(140)},
+				{status: "missed"},
+				{status: "ok", offset: 200},
+			},
+			wantMissed:     1,
+			wantUnexpected: []string{},
+		},
+		{
+			name:  "repeated cue lasts from its first trigger",
+			times: []*Time{at("blah", 0), at("blah", 5), at("blah, blah", 65)},
+			wantCues: []cue{
+				{status: "repeated", offset: 0, actual: seconds(65), difference: seconds(5)},
+				{status: "ok", offset: 65},
+				{status: "missed"},
+			},
+			wantMissed:     1,
+			wantRepeated:   1,
+			wantUnexpected: []string{},
+		},
+		{
+			name:  "unexpected cue ends the one before",
+			times: []*Time{at("bla", 0), at("blah", 10), at("oops", 40), at("blah, blah", 70)},
+			wantCues: []cue{
+				{status: "ok", offset: 10, actual: seconds(30), difference: seconds(-30)},
+				{status: "ok", offset: 70},
+				{status: "missed"},
+			},
+			wantMissed:     1,
+			wantUnexpected: []string{"bla", "oops"},
+		},
+	}
+
+	equal := func(a, b *float64) bool {
+		return a == nil && b == nil || a != nil && b != nil && *a == *b
+	}
+	format := func(v *float64) any {
+		if v == nil {
+			return nil
+		}
+		return *v
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReport(a, tt.times, nil)
+
+			if r.Title != "Show" || len(r.Cues) != len(tt.wantCues) {
+				t.Fatalf("got %q with %d cues, want %q with %d", r.Title, len(r.Cues), "Show", len(tt.wantCues))
+			}
+			if r.Missed != tt.wantMissed || r.Repeated != tt.wantRepeated {
+				t.Errorf("got %d missed and %d repeated, want %d and %d", r.Missed, r.Repeated, tt.wantMissed, tt.wantRepeated)
+			}
+
+			for i, want := range tt.wantCues {
+				c := r.Cues[i]
+				if c.Status() != want.status || c.OffsetSeconds != want.offset || !equal(c.ActualSeconds, want.actual) || !equal(c.DifferenceSeconds, want.difference) {
+					t.Errorf("cue %s is %s at %v lasting %v (difference %v), want %s at %v lasting %v (difference %v)",
+						c.Name, c.Status(), c.OffsetSeconds, format(c.ActualSeconds), format(c.DifferenceSeconds),
+						want.status, want.offset, format(want.actual), format(want.difference))
+				}
+			}
+
+			if got := cueNames(r.Unexpected); !slices.Equal(got, tt.wantUnexpected) {
+				t.Errorf("got unexpected %v, want %v", got, tt.wantUnexpected)
+			}
+
+			if len(tt.times) > 0 && (!r.Start.Equal(tt.times[0].Received) || !r.End.Equal(tt.times[len(tt.times)-1].Received)) {
+				t.Errorf("got performance from %s to %s, want from the first cue to the last", r.Start, r.End)
+			}
+		})
+	}
+}
+
+func TestNewReportRooms(t *testing.T) {
+	a := &agenda.Agenda{
+		Rooms: []*agenda.Room{{Name: "stage"}, {Name: "lobby"}},
+	}
+	aud := &Audience{
+		PeakListeners: 12,
+		Rooms: []*RoomOccupancy{
+			{Room: "balcony", Visits: 2, PeakListeners: 1},
+			{Room: "stage", Visits: 20, PeakListeners: 9},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		audience  *Audience
+		wantRooms []string
+	}{
+		{"unknown audience", nil, nil},
+		{"agenda rooms first, then others", aud, []string{"stage", "lobby", "balcony"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReport(a, nil, tt.audience)
+
+			var rooms []string
+			for _, o := range r.Rooms {
+				rooms = append(rooms, o.Room)
+			}
+			if !slices.Equal(rooms, tt.wantRooms) {
+				t.Errorf("got rooms %v, want %v", rooms, tt.wantRooms)
+			}
+
+			if tt.audience != nil && (r.Rooms[0].Visits != 20 || r.Rooms[1].Visits != 0) {
+				t.Errorf("got visits %d and %d, want 20 and 0", r.Rooms[0].Visits, r.Rooms[1].Visits)
+			}
+		})
+	}
+}
+
+func TestReportWriteCSV(t *testing.T) {
+	a := &agenda.Agenda{
+		Cues: []*agenda.Cue{
+			{ID: "1", Name: "intro", Data: "blah", ReferenceSeconds: 60},
+			{ID: "2", Name: "erste", Data: "blah, blah"},
+		},
+	}
+	start := time.Date(2024, 5, 4, 19, 30, 0, 0, time.UTC)
+	times := []*Time{
+		{Cue: "blah", Received: start},
+		{Cue: "oops", Received: start.Add(62500 * time.Millisecond), Origin: "udp:10.0.0.5:53000"},
+	}
+
+	var buf bytes.Buffer
+	if err := NewReport(a, times, nil).WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r := csv.NewReader(&buf)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"cue", "id", "data", "triggered", "offset_seconds", "actual_seconds", "reference_seconds", "difference_seconds", "triggers", "status"},
+		{"intro", "1", "blah", "2024-05-04T19:30:00Z", "0", "62.5", "60", "2.5", "1", "ok"},
+		{"erste", "2", "blah, blah", "", "", "", "", "", "0", "missed"},
+		{"unexpected_data", "triggered", "origin"},
+		{"oops", "2024-05-04T19:31:02.5Z", "udp:10.0.0.5:53000"},
+	}
+	if !slices.EqualFunc(records, want, slices.Equal[[]string]) {
+		t.Errorf("got\n%q\nwant\n%q", records, want)
+	}
+}
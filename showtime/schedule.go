@@ -0,0 +1,366 @@
+package showtime
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+// SchedulerOrigin is the origin of cues triggered by their schedules
+const SchedulerOrigin = "scheduler"
+
+// Kinds of ScheduledCue
+const (
+
+	// ScheduledAt is a cue scheduled at a time of day or date and time
+	ScheduledAt = "at"
+
+	// ScheduledAfter is a cue scheduled after another cue
+	ScheduledAfter = "after"
+)
+
+// ErrNotScheduled indicates that the given scheduled cue does not exist, or
+// has already been triggered
+var ErrNotScheduled = errors.New("no such scheduled cue")
+
+// ScheduledCue is a pending, automatic trigger of a cue (see
+// agenda.Schedule)
+type ScheduledCue struct {
+
+	// ID identifies the scheduled cue, so that it may be cancelled
+	ID string `json:"id"`
+
+	// Cue is the name of the cue to be triggered
+	Cue string `json:"cue"`
+
+	// Data is the data of the cue to be triggered
+	Data string `json:"data"`
+
+	// At is the time at which the cue will be triggered
+	At time.Time `json:"at"`
+
+	// Kind is the kind of schedule: "at" or "after"
+	Kind string `json:"kind"`
+
+	// Reason describes the schedule, such as "90s after intro"
+	Reason string `json:"reason"`
+
+	// daily indicates that the cue is scheduled again for the next day once
+	// it is triggered or cancelled
+	daily bool
+
+	timer *time.Timer
+}
+
+// scheduler holds the pending scheduled cues of the Service
+type scheduler struct {
+	pending []*ScheduledCue
+
+	// running indicates that the service is running, so that cues are
+	// scheduled
+	running bool
+
+	// cancelled holds the times of the occurrences of each cue scheduled at
+	// a time which have been cancelled, by name, so that they are not
+	// scheduled again when the agenda changes
+	cancelled map[string][]time.Time
+
+	lastID int
+
+	mu sync.Mutex
+}
+
+// startSchedule schedules the cues of the agenda which are scheduled at a
+// time, and any cues scheduled after a cue in the history which are still
+// due, as after a restart.
+func (s *Service) startSchedule() {
+	s.schedule.mu.Lock()
+	s.schedule.running = true
+	s.schedule.mu.Unlock()
+
+	s.planScheduledAt()
+
+	a := s.agenda.Load()
+	if a == nil {
+		return
+	}
+
+	history := s.History()
+	for i, t := range history {
+		for _, c := range followers(a, t.Cue) {
+			// Skip followers which have been triggered since
+			if slices.ContainsFunc(history[i+1:], func(later *Time) bool { return NormalizeCueData(later.Cue) == NormalizeCueData(c.Data) }) {
+				continue
+			}
+
+			s.scheduleAfter(c, t.Received, true)
+		}
+	}
+}
+
+// stopSchedule cancels all scheduled cues
+func (s *Service) stopSchedule() {
+	s.schedule.mu.Lock()
+	defer s.schedule.mu.Unlock()
+
+	s.schedule.running = false
+	for _, sc := range s.schedule.pending {
+		sc.timer.Stop()
+	}
+	s.schedule.pending = nil
+}
+
+// planScheduledAt (re)schedules the cues of the agenda which are scheduled at
+// a time, as when the agenda changes.
+func (s *Service) planScheduledAt() {
+	a := s.agenda.Load()
+
+	s.schedule.mu.Lock()
+	defer s.schedule.mu.Unlock()
+
+	if !s.schedule.running {
+		return
+	}
+
+	s.schedule.pending = slices.DeleteFunc(s.schedule.pending, func(sc *ScheduledCue) bool {
+		if sc.Kind == ScheduledAt {
+			sc.timer.Stop()
+			return true
+		}
+		return false
+	})
+
+	if a == nil {
+		return
+	}
+
+	now := time.Now()
+	for name, times := range s.schedule.cancelled {
+		s.schedule.cancelled[name] = slices.DeleteFunc(times, func(at time.Time) bool { return at.Before(now) })
+	}
+	maps.DeleteFunc(s.schedule.cancelled, func(_ string, times []time.Time) bool { return len(times) < 1 })
+
+	for _, c := range a.Cues {
+		at, ok := c.Schedule.Next(now)
+		if !ok {
+			continue
+		}
+
+		// Occurrences which were cancelled stay cancelled, unless the cue's
+		// schedule has changed
+		cancelled := func(at time.Time) bool {
+			return slices.ContainsFunc(s.schedule.cancelled[c.Name], at.Equal)
+		}
+		if cancelled(at) && !c.Schedule.Daily() {
+			continue
+		}
+		for cancelled(at) {
+			at = at.AddDate(0, 0, 1)
+		}
+
+		s.addScheduled(&ScheduledCue{
+			Cue:    c.Name,
+			Data:   c.Data,
+			At:     at,
+			Kind:   ScheduledAt,
+			Reason: "at " + c.Schedule.At,
+			daily:  c.Schedule.Daily(),
+		})
+	}
+}
+
+// followers returns the cues of the agenda which are scheduled after the cue
+// with the given data
+func followers(a *agenda.Agenda, data string) (out []*agenda.Cue) {
+	data = NormalizeCueData(data)
+
+	var name string
+	for _, c := range a.Cues {
+		if NormalizeCueData(c.Data) == data {
+			name = c.Name
+			break
+		}
+	}
+	if name == "" {
+		return nil
+	}
+
+	for _, c := range a.Cues {
+		if c.Schedule != nil && c.Schedule.After == name {
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
+
+// scheduleFollowers schedules the cues which follow the cue with the given
+// data, which was triggered at the given time
+func (s *Service) scheduleFollowers(data string, triggered time.Time) {
+	a := s.agenda.Load()
+	if a == nil {
+		return
+	}
+
+	for _, c := range followers(a, data) {
+		s.scheduleAfter(c, triggered, false)
+	}
+}
+
+// scheduleAfter schedules a cue which follows another which was triggered at
+// the given time.  When the cue history is replayed, as after a restart, a cue
+// which is already overdue is skipped; otherwise, one with no delay is
+// triggered at once.
+func (s *Service) scheduleAfter(c *agenda.Cue, triggered time.Time, replay bool) {
+	delay, err := c.Schedule.DelayDuration()
+	if err != nil {
+		s.Echo.Logger.Errorf("not scheduling cue %q: %s", c.Name, err.Error())
+		return
+	}
+
+	at := triggered.Add(delay)
+	if replay && at.Before(time.Now()) {
+		return
+	}
+
+	s.schedule.mu.Lock()
+	defer s.schedule.mu.Unlock()
+
+	if !s.schedule.running {
+		return
+	}
+
+	s.addScheduled(&ScheduledCue{
+		Cue:    c.Name,
+		Data:   c.Data,
+		At:     at,
+		Kind:   ScheduledAfter,
+		Reason: fmt.Sprintf("%s after %s", delay, c.Schedule.After),
+	})
+}
+
+// addScheduled adds a scheduled cue and starts its timer.  The caller must
+// hold the scheduler lock.
+func (s *Service) addScheduled(sc *ScheduledCue) {
+	s.schedule.lastID++
+	sc.ID = strconv.Itoa(s.schedule.lastID)
+
+	sc.timer = time.AfterFunc(time.Until(sc.At), func() {
+		s.fireScheduled(sc)
+	})
+
+	s.schedule.pending = append(s.schedule.pending, sc)
+	slices.SortFunc(s.schedule.pending, func(a, b *ScheduledCue) int {
+		return a.At.Compare(b.At)
+	})
+
+	s.Echo.Logger.Infof("scheduled cue %q for %s (%s)", sc.Cue, sc.At.Format(time.DateTime), sc.Reason)
+}
+
+// fireScheduled triggers a scheduled cue, unless it has been cancelled
+func (s *Service) fireScheduled(sc *ScheduledCue) {
+	if !s.unschedule(sc) {
+		return
+	}
+
+	s.Echo.Logger.Infof("triggering scheduled cue %q (%s)", sc.Cue, sc.Reason)
+	s.Trigger(sc.Data, SchedulerOrigin) //nolint: errcheck
+}
+
+// unschedule removes the given scheduled cue, scheduling the next occurrence
+// of a daily cue.  It returns false if the cue was no longer scheduled.
+func (s *Service) unschedule(sc *ScheduledCue) bool {
+	s.schedule.mu.Lock()
+	defer s.schedule.mu.Unlock()
+
+	return s.unscheduleLocked(sc)
+}
+
+// unscheduleLocked is unschedule for a caller which holds the scheduler lock
+func (s *Service) unscheduleLocked(sc *ScheduledCue) bool {
+	i := slices.Index(s.schedule.pending, sc)
+	if i < 0 {
+		return false
+	}
+
+	sc.timer.Stop()
+	s.schedule.pending = slices.Delete(s.schedule.pending, i, i+1)
+
+	if sc.daily && s.schedule.running {
+		s.addScheduled(&ScheduledCue{
+			Cue:    sc.Cue,
+			Data:   sc.Data,
+			At:     sc.At.AddDate(0, 0, 1),
+			Kind:   sc.Kind,
+			Reason: sc.Reason,
+			daily:  true,
+		})
+	}
+
+	return true
+}
+
+// cancelFollowers cancels all cues scheduled after other cues, as when the
+// cue history is reset
+func (s *Service) cancelFollowers() {
+	s.schedule.mu.Lock()
+	defer s.schedule.mu.Unlock()
+
+	s.schedule.pending = slices.DeleteFunc(s.schedule.pending, func(sc *ScheduledCue) bool {
+		if sc.Kind == ScheduledAfter {
+			sc.timer.Stop()
+			return true
+		}
+		return false
+	})
+}
+
+// Scheduled returns the pending scheduled cues, soonest first
+func (s *Service) Scheduled() []*ScheduledCue {
+	s.schedule.mu.Lock()
+	defer s.schedule.mu.Unlock()
+
+	out := make([]*ScheduledCue, 0, len(s.schedule.pending))
+	for _, sc := range s.schedule.pending {
+		copied := *sc
+		copied.timer = nil
+		out = append(out, &copied)
+	}
+
+	return out
+}
+
+// CancelScheduled cancels the scheduled cue with the given ID, returning it.
+// A cue scheduled daily is scheduled again for the next day.  The occurrence
+// stays cancelled when the agenda changes, unless the cue's schedule does.
+func (s *Service) CancelScheduled(id string) (*ScheduledCue, error) {
+	s.schedule.mu.Lock()
+	i := slices.IndexFunc(s.schedule.pending, func(sc *ScheduledCue) bool { return sc.ID == id })
+	if i < 0 {
+		s.schedule.mu.Unlock()
+		return nil, fmt.Errorf("%w: %q", ErrNotScheduled, id)
+	}
+	sc := s.schedule.pending[i]
+	s.unscheduleLocked(sc)
+
+	if sc.Kind == ScheduledAt {
+		if s.schedule.cancelled == nil {
+			s.schedule.cancelled = make(map[string][]time.Time)
+		}
+		s.schedule.cancelled[sc.Cue] = append(s.schedule.cancelled[sc.Cue], sc.At)
+	}
+	s.schedule.mu.Unlock()
+
+	s.Echo.Logger.Infof("cancelled scheduled cue %q (%s)", sc.Cue, sc.Reason)
+
+	copied := *sc
+	copied.timer = nil
+
+	return &copied, nil
+}
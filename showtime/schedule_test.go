@@ -0,0 +1,221 @@
+package showtime
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+// scheduledCues returns the names of the pending scheduled cues of the given
+// kind
+func scheduledCues(s *Service, kind string) (out []string) {
+	for _, sc := range s.Scheduled() {
+		if sc.Kind == kind {
+			out = append(out, sc.Cue)
+		}
+	}
+	return out
+}
+
+// newScheduleTestService returns a Service whose schedule is running, with
+// an agenda of the given cues
+func newScheduleTestService(t *testing.T, cues ...*agenda.Cue) *Service {
+	t.Helper()
+
+	s := newTestService(&agenda.Agenda{Cues: cues})
+	s.startSchedule()
+	t.Cleanup(s.stopSchedule)
+
+	return s
+}
+
+func TestScheduleAt(t *testing.T) {
+	future := time.Now().Add(time.Hour).Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+	s := newScheduleTestService(t,
+		&agenda.Cue{Name: "doors", Data: "1", Schedule: &agenda.Schedule{At: future}},
+		&agenda.Cue{Name: "missed", Data: "2", Schedule: &agenda.Schedule{At: past}},
+		&agenda.Cue{Name: "manual", Data: "3"},
+	)
+
+	if got := scheduledCues(s, ScheduledAt); !slices.Equal(got, []string{"doors"}) {
+		t.Fatalf("got %v scheduled, want [doors]", got)
+	}
+
+	id := s.Scheduled()[0].ID
+	sc, err := s.CancelScheduled(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Cue != "doors" {
+		t.Errorf("got %q cancelled, want doors", sc.Cue)
+	}
+	if got := s.Scheduled(); len(got) > 0 {
+		t.Errorf("got %d scheduled after cancelling, want none", len(got))
+	}
+
+	if _, err := s.CancelScheduled(id); !errors.Is(err, ErrNotScheduled) {
+		t.Errorf("got %v cancelling again, want %v", err, ErrNotScheduled)
+	}
+}
+
+func TestScheduleAfter(t *testing.T) {
+	s := newScheduleTestService(t,
+		&agenda.Cue{Name: "intro", Data: "1"},
+		&agenda.Cue{Name: "music", Data: "2", Schedule: &agenda.Schedule{After: "intro", Delay: "50ms"}},
+		&agenda.Cue{Name: "lights", Data: "3", Schedule: &agenda.Schedule{After: "intro", Delay: "1h"}},
+	)
+
+	if err := s.Trigger("1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := scheduledCues(s, ScheduledAfter); !slices.Equal(got, []string{"music", "lights"}) {
+		t.Fatalf("got %v scheduled, want [music lights]", got)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.History()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	history := s.History()
+	if got := cueNames(history); !slices.Equal(got, []string{"1", "2"}) {
+		t.Fatalf("got history %v, want [1 2]", got)
+	}
+	if history[1].Origin != SchedulerOrigin {
+		t.Errorf("got origin %q, want %q", history[1].Origin, SchedulerOrigin)
+	}
+	if got := scheduledCues(s, ScheduledAfter); !slices.Equal(got, []string{"lights"}) {
+		t.Errorf("got %v scheduled, want [lights]", got)
+	}
+
+	// Resetting the cue history cancels the cues scheduled after others
+	if err := s.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Scheduled(); len(got) > 0 {
+		t.Errorf("got %d scheduled after a reset, want none", len(got))
+	}
+}
+
+func TestScheduleAfterNoDelay(t *testing.T) {
+	s := newScheduleTestService(t,
+		&agenda.Cue{Name: "intro", Data: "1"},
+		&agenda.Cue{Name: "music", Data: "2", Schedule: &agenda.Schedule{After: "intro"}},
+		&agenda.Cue{Name: "lights", Data: "3", Schedule: &agenda.Schedule{After: "music", Delay: "0s"}},
+	)
+
+	if err := s.Trigger("1", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each follows the cue before it at once
+	history := waitForHistory(t, s, 3)
+	if got := cueNames(history); !slices.Equal(got, []string{"1", "2", "3"}) {
+		t.Fatalf("got history %v, want [1 2 3]", got)
+	}
+	for _, tm := range history[1:] {
+		if tm.Origin != SchedulerOrigin {
+			t.Errorf("got origin %q, want %q", tm.Origin, SchedulerOrigin)
+		}
+	}
+}
+
+func TestScheduleAfterRestart(t *testing.T) {
+	s := newTestService(&agenda.Agenda{Cues: []*agenda.Cue{
+		{Name: "intro", Data: "1"},
+		{Name: "music", Data: "2", Schedule: &agenda.Schedule{After: "intro"}},
+		{Name: "lights", Data: "3", Schedule: &agenda.Schedule{After: "intro", Delay: "1m"}},
+		{Name: "bows", Data: "4", Schedule: &agenda.Schedule{After: "intro", Delay: "1h"}},
+	}})
+	s.Times = []*Time{{Cue: "1", Received: time.Now().Add(-30 * time.Minute)}}
+
+	// Restarting, the cues which fell due while the service was down are
+	// skipped, and the rest are scheduled as before
+	s.startSchedule()
+	defer s.stopSchedule()
+
+	if got := scheduledCues(s, ScheduledAfter); !slices.Equal(got, []string{"bows"}) {
+		t.Errorf("got %v scheduled, want [bows]", got)
+	}
+	if got := cueNames(s.History()); !slices.Equal(got, []string{"1"}) {
+		t.Errorf("got history %v, want [1]", got)
+	}
+}
+
+func TestScheduleAtCancelledAcrossAgendas(t *testing.T) {
+	now := time.Now()
+	once := &agenda.Cue{Name: "doors", Data: "1", Schedule: &agenda.Schedule{At: now.Add(time.Hour).Format(time.RFC3339)}}
+	daily := &agenda.Cue{Name: "preshow", Data: "2", Schedule: &agenda.Schedule{At: now.Add(2 * time.Hour).Format("15:04:05")}}
+	a := &agenda.Agenda{Cues: []*agenda.Cue{once, daily}}
+
+	s := newScheduleTestService(t, once, daily)
+
+	first, ok := daily.Schedule.Next(now)
+	if !ok {
+		t.Fatal("daily cue has no next occurrence")
+	}
+
+	// scheduledAt returns the times at which the given cue is scheduled
+	scheduledAt := func(cue string) (out []time.Time) {
+		for _, sc := range s.Scheduled() {
+			if sc.Cue == cue {
+				out = append(out, sc.At)
+			}
+		}
+		return out
+	}
+
+	cancel := func(cue string) {
+		t.Helper()
+
+		i := slices.IndexFunc(s.Scheduled(), func(sc *ScheduledCue) bool { return sc.Cue == cue })
+		if i < 0 {
+			t.Fatalf("cue %q is not scheduled", cue)
+		}
+		if _, err := s.CancelScheduled(s.Scheduled()[i].ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cancel("doors")
+	cancel("preshow")
+
+	steps := []struct {
+		name        string
+		agenda      *agenda.Agenda
+		cancel      string
+		wantDoors   int
+		wantPreshow time.Time
+	}{
+		{"reloaded", a, "", 0, first.AddDate(0, 0, 1)},
+		{"reloaded again", a, "", 0, first.AddDate(0, 0, 1)},
+		{"next day cancelled too", a, "preshow", 0, first.AddDate(0, 0, 2)},
+		{
+			name: "schedule changed",
+			agenda: &agenda.Agenda{Cues: []*agenda.Cue{
+				{Name: "doors", Data: "1", Schedule: &agenda.Schedule{At: now.Add(90 * time.Minute).Format(time.RFC3339)}},
+				daily,
+			}},
+			wantDoors:   1,
+			wantPreshow: first.AddDate(0, 0, 2),
+		},
+	}
+
+	for _, step := range steps {
+		if step.cancel != "" {
+			cancel(step.cancel)
+		}
+		s.SetAgenda(step.agenda)
+
+		if got := scheduledAt("doors"); len(got) != step.wantDoors {
+			t.Errorf("%s: got doors scheduled at %v, want %d", step.name, got, step.wantDoors)
+		}
+		if got := scheduledAt("preshow"); len(got) != 1 || !got[0].Equal(step.wantPreshow) {
+			t.Errorf("%s: got preshow scheduled at %v, want %s", step.name, got, step.wantPreshow)
+		}
+	}
+}
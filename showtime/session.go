@@ -0,0 +1,312 @@
+package showtime
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxSessionIDLength is the length of the longest acceptable session ID
+const maxSessionIDLength = 64
+
+// maxSessions is the greatest number of listener sessions which may have
+// private timelines at once
+const maxSessions = 1000
+
+// maxSessionCues is the greatest number of cues which may be triggered in the
+// private timeline of a listener session before it is reset
+const maxSessionCues = 500
+
+// DefaultSessionTimeout is the time for which the private timeline of a
+// listener session is kept after its last subscriber has gone, if the
+// SessionTimeout is not given
+const DefaultSessionTimeout = time.Hour
+
+var (
+	// ErrInvalidSession indicates that a session ID is empty, too long, or
+	// contains characters other than letters, digits, '-', and '_'
+	ErrInvalidSession = errors.New("invalid session ID")
+
+	// ErrNoSession indicates that no listener is subscribed with the given
+	// session ID
+	ErrNoSession = errors.New("no listener is subscribed with this session")
+
+	// ErrSessionLimit indicates that there are too many listener sessions
+	// with private timelines, or too many cues in the private timeline of a
+	// session
+	ErrSessionLimit = errors.New("too many private cues")
+
+	// ErrUnknownCue indicates that cue data for a listener session matches no
+	// cue in the agenda
+	ErrUnknownCue = errors.New("matches no cue in the agenda")
+)
+
+var (
+	metricSessionsCount   *prometheus.GaugeVec
+	metricSessionCueCount prometheus.Counter
+)
+
+func init() {
+	metricSessionsCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "audimance_sessions_count",
+		Help: "Current number of listener sessions with private cues",
+	}, []string{"show"})
+
+	metricSessionCueCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "audimance_session_cue_total",
+		Help: "Total number of cues triggered privately for listener sessions",
+	})
+}
+
+// session is the private timeline of a listener session: cues triggered for
+// its subscribers alone, such as by a visitor advancing through a self-paced
+// tour.  Its subscribers see its cues along with those of the shared cue
+// history.
+type session struct {
+	times []*Time
+
+	// seq counts the changes to the private timeline.  The sequence number of
+	// an announcement to a subscriber of the session is the sum of it and the
+	// sequence number of the shared cue history, so that either change is
+	// seen as the next in sequence.
+	seq uint64
+
+	// idle is the time at which the session was last used
+	idle time.Time
+}
+
+// ValidSessionID indicates whether the given session ID is acceptable
+func ValidSessionID(id string) bool {
+	if id == "" || len(id) > maxSessionIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// TriggerSession activates the given cue for the subscribers of the listener
+// session with the given ID alone.  The origin describes where the cue came
+// from (see CueSource).  A listener must be subscribed with the session ID
+// (see SubscribeOptions); otherwise it fails with ErrNoSession.  It fails with
+// ErrSessionLimit if there are already too many sessions with private
+// timelines, or too many cues in this one, with ErrUnknownCue if the cue is
+// not in the agenda, and with ErrStopped if the service has been shut down.
+// Like Trigger, it records the attempt in the cue log.
+func (s *Service) TriggerSession(id string, cue string, origin string) error {
+	start := time.Now()
+
+	err := s.triggerSession(id, cue, origin)
+	switch {
+	case errors.Is(err, ErrUnknownCue):
+		s.Echo.Logger.Warnf("dropping cue %q for session %s from %s: %s", cue, id, origin, err)
+		s.logAttempt(start, cue, origin, CueDropped, err.Error())
+	case err != nil:
+		s.Echo.Logger.Warnf("refusing cue %q for session %s from %s: %s", cue, id, origin, err)
+		s.logAttempt(start, cue, origin, CueRefused, err.Error())
+	default:
+		s.logAttempt(start, cue, origin, CueTriggered, "")
+	}
+
+	return err
+}
+
+// triggerSession adds the given cue to the private timeline of the listener
+// session with the given ID
+func (s *Service) triggerSession(id string, cue string, origin string) error {
+	if !ValidSessionID(id) {
+		return ErrInvalidSession
+	}
+
+	if !s.matches(NormalizeCueData(cue)) {
+		return ErrUnknownCue
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return ErrStopped
+	}
+
+	if !s.subscribedSession(id) {
+		return ErrNoSession
+	}
+
+	if s.sessions == nil {
+		s.sessions = make(map[string]*session)
+	}
+
+	sess := s.sessions[id]
+	switch {
+	case sess == nil && len(s.sessions) >= maxSessions:
+		return ErrSessionLimit
+	case sess != nil && len(sess.times) >= maxSessionCues:
+		return ErrSessionLimit
+	case sess == nil:
+		sess = new(session)
+		s.sessions[id] = sess
+		metricSessionsCount.WithLabelValues(s.Name).Set(float64(len(s.sessions)))
+	}
+
+	now := time.Now()
+	sess.idle = now
+	sess.times = append(sess.times, &Time{
+		Cue:      NormalizeCueData(cue),
+		Received: now,
+		Origin:   origin,
+	})
+	sess.seq++
+
+	s.announceSession(id, CueNotification)
+
+	s.Echo.Logger.Debugf("triggering cue %q for session %s from %s", cue, id, origin)
+
+	metricSessionCueCount.Inc()
+
+	return nil
+}
+
+// ResetSession clears the private timeline of the listener session with the
+// given ID, as when a visitor starts a tour again.  Like TriggerSession, it
+// fails with ErrNoSession unless a listener is subscribed with the session ID.
+func (s *Service) ResetSession(id string) error {
+	if !ValidSessionID(id) {
+		return ErrInvalidSession
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return ErrStopped
+	}
+
+	if !s.subscribedSession(id) {
+		return ErrNoSession
+	}
+
+	sess := s.sessions[id]
+	if sess == nil {
+		return nil
+	}
+
+	sess.idle = time.Now()
+	sess.times = nil
+	sess.seq++
+
+	s.announceSession(id, ResetNotification)
+
+	return nil
+}
+
+// subscribedSession indicates whether a listener is subscribed with the given
+// session ID.  The caller must hold the service lock.
+func (s *Service) subscribedSession(id string) bool {
+	for _, sub := range s.subs {
+		if sub.sessionID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// announceSession sends an announcement of a change to the private timeline
+// of the given session to its subscribers.  The caller must hold the service
+// lock.
+func (s *Service) announceSession(id string, cause string) {
+	var evicted []*Subscription
+
+	for _, sub := range s.subs {
+		if sub.sessionID != id {
+			continue
+		}
+
+		ann := sub.snapshot(cause)
+		if sub.Protocol == ProtocolDelta {
+			ann = sub.delta(cause)
+		}
+
+		if !s.send(sub, ann) {
+			evicted = append(evicted, sub)
+		}
+	}
+
+	for _, sub := range evicted {
+		s.evict(sub)
+	}
+}
+
+// expireSessions discards the private timelines of listener sessions which
+// have had no subscribers for the SessionTimeout, or the
+// DefaultSessionTimeout if it is not given.
+func (s *Service) expireSessions() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.sessions) < 1 {
+		return
+	}
+
+	timeout := s.SessionTimeout
+	if timeout <= 0 {
+		timeout = DefaultSessionTimeout
+	}
+
+	now := time.Now()
+	for _, sub := range s.subs {
+		if sess := s.sessions[sub.sessionID]; sess != nil {
+			sess.idle = now
+		}
+	}
+
+	for id, sess := range s.sessions {
+		if now.Sub(sess.idle) > timeout {
+			delete(s.sessions, id)
+		}
+	}
+
+	metricSessionsCount.WithLabelValues(s.Name).Set(float64(len(s.sessions)))
+}
+
+// times returns the cue history followed by the subscriber: the shared cue
+// history (or its own replay of the Playback timeline) along with the private
+// timeline of its session, if it has one.  The caller must hold the service
+// lock.
+func (s *Subscription) times() []*Time {
+	base := s.svc.Times
+	if s.playback != nil {
+		base = s.playback.times
+	}
+
+	sess := s.svc.sessions[s.sessionID]
+	if sess == nil || len(sess.times) < 1 {
+		return base
+	}
+
+	merged := make([]*Time, 0, len(base)+len(sess.times))
+	merged = append(merged, base...)
+	merged = append(merged, sess.times...)
+	slices.SortStableFunc(merged, func(a, b *Time) int {
+		return a.Received.Compare(b.Received)
+	})
+
+	return merged
+}
+
+// private indicates that the subscriber follows a cue history of its own,
+// rather than exactly the shared one.  The caller must hold the service lock.
+func (s *Subscription) private() bool {
+	return s.playback != nil || s.svc.sessions[s.sessionID] != nil
+}
@@ -0,0 +1,279 @@
+package showtime
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+func TestValidSessionID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"abc", true},
+		{"0f3c9a2e-5b1d-4c8e-9f7a-2d6b1e8c4a90", true},
+		{"A_b-9", true},
+		{strings.Repeat("a", maxSessionIDLength), true},
+		{"", false},
+		{strings.Repeat("a", maxSessionIDLength+1), false},
+		{"a b", false},
+		{"a/b", false},
+		{"a.b", false},
+		{"a%20b", false},
+		{"café", false},
+	}
+
+	for _, tt := range tests {
+		if got := ValidSessionID(tt.id); got != tt.want {
+			t.Errorf("ValidSessionID(%q) = %v, want %v", tt.id, got, tt.want)
+		}
+	}
+}
+
+func TestSubscriptionTimes(t *testing.T) {
+	start := time.Date(2024, 5, 4, 19, 30, 0, 0, time.UTC)
+	at := func(cue string, seconds int) *Time {
+		return &Time{Cue: cue, Received: start.Add(time.Duration(seconds) * time.Second)}
+	}
+
+	tests := []struct {
+		name    string
+		shared  []*Time
+		private []*Time
+		want    []string
+	}{
+		{
+			name:   "no private cues",
+			shared: []*Time{at("a", 0), at("b", 10)},
+			want:   []string{"a", "b"},
+		},
+		{
+			name:    "no shared cues",
+			private: []*Time{at("x", 5)},
+			want:    []string{"x"},
+		},
+		{
+			name:    "interleaved",
+			shared:  []*Time{at("a", 0), at("b", 10), at("c", 20)},
+			private: []*Time{at("x", 5), at("y", 15)},
+			want:    []string{"a", "x", "b", "y", "c"},
+		},
+		{
+			name:    "private after shared",
+			shared:  []*Time{at("a", 0)},
+			private: []*Time{at("x", 5), at("y", 6)},
+			want:    []string{"a", "x", "y"},
+		},
+		{
+			name:    "shared first at the same time",
+			shared:  []*Time{at("a", 0), at("b", 10)},
+			private: []*Time{at("x", 10)},
+			want:    []string{"a", "b", "x"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(nil)
+			s.Times = tt.shared
+			if tt.private != nil {
+				s.sessions = map[string]*session{"abc": {times: tt.private}}
+			}
+
+			sub := s.SubscribeWith(&SubscribeOptions{Protocol: ProtocolDelta, Session: "abc"})
+			defer sub.Cancel()
+
+			s.mu.Lock()
+			got := cueNames(sub.times())
+			s.mu.Unlock()
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+
+			// The shared cue history is unchanged
+			if shared := cueNames(s.History()); !slices.Equal(shared, cueNames(tt.shared)) {
+				t.Errorf("got shared history %v, want %v", shared, cueNames(tt.shared))
+			}
+		})
+	}
+}
+
+func TestTriggerSession(t *testing.T) {
+	s := newTestService(nil)
+
+	sub := s.SubscribeWith(&SubscribeOptions{Protocol: ProtocolDelta, Session: "abc"})
+	defer sub.Cancel()
+	other := s.SubscribeProtocol(ProtocolDelta)
+	defer other.Cancel()
+
+	announcements(sub)
+	announcements(other)
+
+	steps := []struct {
+		name     string
+		do       func() error
+		wantType string
+		wantSeq  uint64
+		wantCues []string
+	}{
+		{"shared cue", func() error { return s.Trigger("a", "test") }, CueAnnouncement, 1, []string{"a"}},
+		{"private cue", func() error { return s.TriggerSession("abc", "x\n", "test") }, CueAnnouncement, 2, []string{"a", "x"}},
+		{"another shared cue", func() error { return s.Trigger("b", "test") }, CueAnnouncement, 3, []string{"a", "x", "b"}},
+		{"reset", func() error { return s.ResetSession("abc") }, SnapshotAnnouncement, 4, []string{"a", "b"}},
+	}
+
+	for _, step := range steps {
+		if err := step.do(); err != nil {
+			t.Fatalf("%s: %s", step.name, err)
+		}
+
+		got := announcements(sub)
+		if len(got) != 1 {
+			t.Fatalf("%s: got %d announcements, want 1", step.name, len(got))
+		}
+		if got[0].Type != step.wantType || got[0].Seq != step.wantSeq {
+			t.Errorf("%s: got %s seq %d, want %s seq %d", step.name, got[0].Type, got[0].Seq, step.wantType, step.wantSeq)
+		}
+
+		s.mu.Lock()
+		cues := cueNames(sub.times())
+		s.mu.Unlock()
+		if !slices.Equal(cues, step.wantCues) {
+			t.Errorf("%s: got %v, want %v", step.name, cues, step.wantCues)
+		}
+	}
+
+	// Only the session's subscribers hear of its private cues
+	for _, ann := range announcements(other) {
+		for _, p := range ann.TimePoints {
+			if p.Cue == "x" {
+				t.Errorf("got private cue in an announcement to another subscriber")
+			}
+		}
+	}
+}
+
+func TestTriggerSessionErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(s *Service)
+		id          string
+		wantErr     error
+		wantOutcome string
+	}{
+		{
+			name:        "subscribed",
+			id:          "abc",
+			wantOutcome: CueTriggered,
+		},
+		{
+			name:        "invalid",
+			id:          "a b",
+			wantErr:     ErrInvalidSession,
+			wantOutcome: CueRefused,
+		},
+		{
+			name:        "not subscribed",
+			id:          "xyz",
+			wantErr:     ErrNoSession,
+			wantOutcome: CueRefused,
+		},
+		{
+			name: "too many cues",
+			setup: func(s *Service) {
+				s.sessions = map[string]*session{"abc": {times: make([]*Time, maxSessionCues)}}
+			},
+			id:          "abc",
+			wantErr:     ErrSessionLimit,
+			wantOutcome: CueRefused,
+		},
+		{
+			name: "too many sessions",
+			setup: func(s *Service) {
+				s.sessions = make(map[string]*session)
+				for i := 0; i < maxSessions; i++ {
+					s.sessions[fmt.Sprintf("session%d", i)] = new(session)
+				}
+			},
+			id:          "abc",
+			wantErr:     ErrSessionLimit,
+			wantOutcome: CueRefused,
+		},
+		{
+			name:        "stopped",
+			setup:       func(s *Service) { s.stopped = true },
+			id:          "abc",
+			wantErr:     ErrStopped,
+			wantOutcome: CueRefused,
+		},
+		{
+			name: "in the agenda",
+			setup: func(s *Service) {
+				s.agenda.Store(&agenda.Agenda{Cues: []*agenda.Cue{{Name: "x", Data: "x\n"}}})
+			},
+			id:          "abc",
+			wantOutcome: CueTriggered,
+		},
+		{
+			name: "not in the agenda",
+			setup: func(s *Service) {
+				s.agenda.Store(&agenda.Agenda{Cues: []*agenda.Cue{{Name: "y", Data: "y"}}})
+			},
+			id:          "abc",
+			wantErr:     ErrUnknownCue,
+			wantOutcome: CueDropped,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(nil)
+
+			sub := s.SubscribeWith(&SubscribeOptions{Protocol: ProtocolDelta, Session: "abc"})
+			defer sub.Cancel()
+
+			if tt.setup != nil {
+				tt.setup(s)
+			}
+
+			err := s.TriggerSession(tt.id, "x", "session:"+tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+
+			// Every attempt is logged, with the reason it failed
+			attempts := s.CueAttempts(new(CueLogQuery))
+			if len(attempts) != 1 {
+				t.Fatalf("got %d cue attempts, want 1", len(attempts))
+			}
+			if a := attempts[0]; a.Outcome != tt.wantOutcome || a.Origin != "session:"+tt.id || (err != nil) != (a.Reason != "") {
+				t.Errorf("got %+v, want %s from session:%s", a, tt.wantOutcome, tt.id)
+			}
+		})
+	}
+}
+
+func TestResetSessionRequiresSubscriber(t *testing.T) {
+	s := newTestService(nil)
+
+	if err := s.ResetSession("abc"); !errors.Is(err, ErrNoSession) {
+		t.Errorf("got %v, want %v", err, ErrNoSession)
+	}
+	if err := s.ResetSession(""); !errors.Is(err, ErrInvalidSession) {
+		t.Errorf("got %v, want %v", err, ErrInvalidSession)
+	}
+
+	sub := s.SubscribeWith(&SubscribeOptions{Session: "abc"})
+	defer sub.Cancel()
+
+	if err := s.ResetSession("abc"); err != nil {
+		t.Errorf("got %v, want success", err)
+	}
+}
@@ -0,0 +1,244 @@
+package showtime
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A CueSource delivers cues to the showtime Service from somewhere outside
+// it, such as QLab.  Sources are added to Service.Sources before the service
+// is Run, which runs each of them concurrently.
+//
+// A source passes the cue data it receives to Service.Receive (or, if it has
+// already identified the cue, to Service.Trigger) along with its origin: a
+// description of where the cue came from, such as "udp:192.168.1.20:53000",
+// which is recorded in the cue history.
+type CueSource interface {
+
+	// Run receives cues and delivers them to the given service until the
+	// context is cancelled.  It returns any error which prevents it from
+	// continuing.  A source which has no more cues to deliver may return nil.
+	Run(ctx context.Context, svc *Service) error
+
+	// String describes the source, for logging
+	String() string
+}
+
+// UDPSource receives cues as plain text datagrams, one cue per datagram, as
+// sent by QLab network cues.
+type UDPSource struct {
+
+	// Addr is the UDP address on which to listen
+	Addr string
+}
+
+// String implements CueSource
+func (u *UDPSource) String() string {
+	return "UDP " + u.Addr
+}
+
+// Run implements CueSource
+func (u *UDPSource) Run(ctx context.Context, svc *Service) error {
+	addr, err := net.ResolveUDPAddr("udp", u.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to parse cue listener address: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on UDP port: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() }) //nolint: errcheck
+	defer stop()
+
+	for {
+		buf := make([]byte, maxUDPMessageSize)
+		n, peer, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to read from UDP port: %w", err)
+		}
+
+		recv := string(buf[0:n])
+		svc.Echo.Logger.Debugf("received message from QLab: %q", recv)
+
+		// Update the showtime Time
+		svc.Receive(recv, "udp:"+peer.String())
+
+		metricCueQLabCount.Add(1)
+	}
+}
+
+// TCPSource receives cues over TCP connections, one cue per line.  It suits
+// senders which need to know that a cue was delivered.
+type TCPSource struct {
+
+	// Addr is the TCP address on which to listen
+	Addr string
+}
+
+// String implements CueSource
+func (t *TCPSource) String() string {
+	return "TCP " + t.Addr
+}
+
+// Run implements CueSource
+func (t *TCPSource) Run(ctx context.Context, svc *Service) error {
+	l, err := net.Listen("tcp", t.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on TCP port: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { l.Close() }) //nolint: errcheck
+	defer stop()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to accept TCP connection: %w", err)
+		}
+
+		go t.serve(ctx, svc, conn)
+	}
+}
+
+func (t *TCPSource) serve(ctx context.Context, svc *Service, conn net.Conn) {
+	defer conn.Close() //nolint: errcheck
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() }) //nolint: errcheck
+	defer stop()
+
+	origin := "tcp:" + conn.RemoteAddr().String()
+
+	s := bufio.NewScanner(conn)
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+
+		svc.Echo.Logger.Debugf("received line from %s: %q", origin, s.Text())
+		svc.Receive(s.Text(), origin)
+	}
+
+	if err := s.Err(); err != nil && ctx.Err() == nil {
+		svc.Echo.Logger.Warnf("failed to read from %s: %s", origin, err.Error())
+	}
+}
+
+// TimelineStep is a cue of a TimelineSource
+type TimelineStep struct {
+
+	// Offset is the time after the start of the timeline at which the cue is
+	// delivered
+	Offset time.Duration
+
+	// Cue is the cue data
+	Cue string
+}
+
+// TimelineSource delivers a scripted sequence of cues at fixed offsets from
+// the time it is run.
+type TimelineSource struct {
+
+	// Name identifies the timeline in the origin of its cues
+	Name string
+
+	// Steps lists the cues of the timeline, in order of their offsets
+	Steps []*TimelineStep
+}
+
+// LoadTimeline reads a TimelineSource from the given file.  Each line of the
+// file gives the offset of a cue, in seconds, followed by its data:
+//
+//	# preshow
+//	0     5-minute warning
+//	300   intro
+//	312.5 erste
+//
+// The offset and the data may be separated by any spaces or tabs.  Blank lines
+// and lines beginning with '#' are ignored.  The steps are sorted by offset, so
+// that lines out of order are triggered in time; steps with the same offset
+// keep the order of their lines.
+func LoadTimeline(filename string) (*TimelineSource, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read timeline: %w", err)
+	}
+
+	src := &TimelineSource{
+		Name: filename,
+	}
+
+	var errs []error
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		offset, cue := line, ""
+		if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+			offset, cue = line[:i], line[i:]
+		}
+		seconds, err := strconv.ParseFloat(offset, 64)
+		if err != nil || seconds < 0 {
+			errs = append(errs, fmt.Errorf("%s:%d: invalid offset %q", filename, n+1, offset))
+			continue
+		}
+		if cue = strings.TrimSpace(cue); cue == "" {
+			errs = append(errs, fmt.Errorf("%s:%d: missing cue", filename, n+1))
+			continue
+		}
+
+		src.Steps = append(src.Steps, &TimelineStep{
+			Offset: time.Duration(seconds * float64(time.Second)),
+			Cue:    cue,
+		})
+	}
+
+	slices.SortStableFunc(src.Steps, func(a, b *TimelineStep) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+
+	return src, errors.Join(errs...)
+}
+
+// String implements CueSource
+func (t *TimelineSource) String() string {
+	return "timeline " + t.Name
+}
+
+// Run implements CueSource
+func (t *TimelineSource) Run(ctx context.Context, svc *Service) error {
+	start := time.Now()
+
+	for _, step := range t.Steps {
+		timer := time.NewTimer(time.Until(start.Add(step.Offset)))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		svc.Receive(step.Cue, "timeline:"+t.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package showtime
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadTimeline(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeline string
+		want     []*TimelineStep
+		wantErr  string
+	}{
+		{
+			name:     "steps",
+			timeline: "# preshow\n0     5-minute warning\n\n300   intro\n312.5 erste\n",
+			want: []*TimelineStep{
+				{Offset: 0, Cue: "5-minute warning"},
+				{Offset: 300 * time.Second, Cue: "intro"},
+				{Offset: 312500 * time.Millisecond, Cue: "erste"},
+			},
+		},
+		{
+			name:     "separated by tabs",
+			timeline: "0\tintro\n12.5 \t erste  teil\n",
+			want: []*TimelineStep{
+				{Offset: 0, Cue: "intro"},
+				{Offset: 12500 * time.Millisecond, Cue: "erste  teil"},
+			},
+		},
+		{
+			name:     "out of order",
+			timeline: "300 intro\n0 5-minute warning\n300 erste\n10 doors\n",
+			want: []*TimelineStep{
+				{Offset: 0, Cue: "5-minute warning"},
+				{Offset: 10 * time.Second, Cue: "doors"},
+				{Offset: 300 * time.Second, Cue: "intro"},
+				{Offset: 300 * time.Second, Cue: "erste"},
+			},
+		},
+		{
+			name:     "invalid offset",
+			timeline: "0 intro\nsoon erste\n",
+			want:     []*TimelineStep{{Offset: 0, Cue: "intro"}},
+			wantErr:  `:2: invalid offset "soon"`,
+		},
+		{
+			name:     "negative offset",
+			timeline: "-5 intro\n",
+			wantErr:  `:1: invalid offset "-5"`,
+		},
+		{
+			name:     "missing cue",
+			timeline: "0 intro\n10\n",
+			want:     []*TimelineStep{{Offset: 0, Cue: "intro"}},
+			wantErr:  ":2: missing cue",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := filepath.Join(t.TempDir(), "timeline.txt")
+			if err := os.WriteFile(fn, []byte(tt.timeline), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			src, err := LoadTimeline(fn)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("got %v, want success", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("got %v, want an error containing %q", err, tt.wantErr)
+			}
+
+			if !slices.EqualFunc(src.Steps, tt.want, func(a, b *TimelineStep) bool { return *a == *b }) {
+				t.Errorf("got steps %v, want %v", src.Steps, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimelineSourceRun(t *testing.T) {
+	s := newTestService(nil)
+
+	src := &TimelineSource{
+		Name: "rehearsal",
+		Steps: []*TimelineStep{
+			{Offset: 0, Cue: "intro\n"},
+			{Offset: 10 * time.Millisecond, Cue: "erste"},
+		},
+	}
+
+	if err := src.Run(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	times := s.History()
+	if got := cueNames(times); !slices.Equal(got, []string{"intro", "erste"}) {
+		t.Fatalf("got %v, want [intro erste]", got)
+	}
+	if times[0].Origin != "timeline:rehearsal" {
+		t.Errorf("got origin %q, want %q", times[0].Origin, "timeline:rehearsal")
+	}
+	if d := times[1].Received.Sub(times[0].Received); d < 10*time.Millisecond {
+		t.Errorf("got cues %s apart, want at least 10ms", d)
+	}
+}
@@ -1,11 +1,15 @@
 package showtime
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"net"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/CyCoreSystems/audimance/agenda"
 	"github.com/labstack/echo/v4"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -18,6 +22,60 @@ const (
 
 	// PeriodicNotification indicates that the periodic timer has elapsed.
 	PeriodicNotification = "periodic"
+
+	// AgendaNotification indicates that the agenda has been reloaded, and
+	// clients should fetch it again.
+	AgendaNotification = "agenda"
+
+	// UndoNotification indicates that the most recent cue has been removed
+	// from the cue history.
+	UndoNotification = "undo"
+
+	// RewindNotification indicates that the cue history has been truncated
+	// back to an earlier cue.
+	RewindNotification = "rewind"
+
+	// ResetNotification indicates that the cue history has been cleared.
+	ResetNotification = "reset"
+
+	// RestartNotification indicates that the service is shutting down, as
+	// for a restart, and the subscription is about to be closed.  Clients
+	// should show that they are reconnecting.
+	RestartNotification = "restart"
+
+	// SyncNotification indicates a snapshot of the cue history sent to a
+	// new or resynchronizing subscriber, rather than because of a change.
+	SyncNotification = "sync"
+)
+
+// A Protocol is a version of the announcement protocol spoken by a subscriber
+type Protocol int
+
+const (
+
+	// ProtocolFull sends a snapshot of the whole cue history with every
+	// announcement.  It is spoken by clients which do not ask for another.
+	ProtocolFull Protocol = 1
+
+	// ProtocolDelta sends a snapshot on subscription and afterwards only the
+	// changes: each new cue, and a lightweight tick carrying only the latest
+	// cue.  Any other change to the cue history is sent as a snapshot.  A
+	// subscriber which finds a gap in the sequence numbers should ask for a
+	// new snapshot (see Service.Snapshot).
+	ProtocolDelta Protocol = 2
+)
+
+// Announcement types
+const (
+
+	// SnapshotAnnouncement carries the whole cue history
+	SnapshotAnnouncement = "snapshot"
+
+	// CueAnnouncement carries only the newly-triggered cue
+	CueAnnouncement = "cue"
+
+	// TickAnnouncement carries only the latest cue, to correct drift
+	TickAnnouncement = "tick"
 )
 
 const subscriptionBufferSize = 5
@@ -27,31 +85,43 @@ const maxUDPMessageSize = 512
 var minUpdateInterval = time.Duration(2) * time.Second
 
 var (
-	metricCueCount prometheus.Counter
-	metricCueQLabCount prometheus.Counter
-	metricSubsCount prometheus.Gauge
-	metricTimeSinceLastCue prometheus.Gauge
+	metricCueCount         prometheus.Counter
+	metricCueQLabCount     prometheus.Counter
+	metricSubsCount        *prometheus.GaugeVec
+	metricTimeSinceLastCue *prometheus.GaugeVec
+	metricDroppedCount     prometheus.Counter
+	metricEvictedCount     prometheus.Counter
 )
 
 func init() {
 	metricCueQLabCount = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "audimance_cue_qlab_total",
 		Help: "Total number of cues received from QLab",
-		})
+	})
 
 	metricCueCount = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "audimance_cue_total",
 		Help: "Total number of cues processed",
 	})
 
-	metricSubsCount = promauto.NewGauge(prometheus.GaugeOpts{
+	metricSubsCount = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "audimance_subs_count",
 		Help: "Current number of active subscriptions",
-	})
+	}, []string{"show"})
 
-	metricTimeSinceLastCue = promauto.NewGauge(prometheus.GaugeOpts{
+	metricTimeSinceLastCue = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "audimance_time_since_last_cue_s",
 		Help: "Number of seconds since the last-received cue",
+	}, []string{"show"})
+
+	metricDroppedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "audimance_announcements_dropped_total",
+		Help: "Total number of announcements dropped because a subscriber was not keeping up",
+	})
+
+	metricEvictedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "audimance_subs_evicted_total",
+		Help: "Total number of subscriptions cancelled because the subscriber was not keeping up",
 	})
 }
 
@@ -59,20 +129,49 @@ func init() {
 type Time struct {
 
 	// Cue indicates the last-triggered performance cue
-	Cue string
+	Cue string `json:"cue"`
 
 	// Received indicates the timestamp at which the last-triggered cue was received
-	Received time.Time
+	Received time.Time `json:"received"`
+
+	// Origin describes where the cue came from, such as the address of the
+	// QLab host or the user who triggered it from the admin console
+	Origin string `json:"origin,omitempty"`
 }
 
 // An Announcement is a notification of a change in the showtime.  It can be an incremental time notification or a cue notification
 type Announcement struct {
 
-	// Cause indicates the reason for the announcement.  Valid reasons are "periodic" and "cue"
+	// Cause indicates the reason for the announcement.  Valid reasons are "periodic", "cue", "agenda", "undo", "rewind", "reset", and "sync"
 	Cause string `json:"cause"`
 
+	// Type indicates what the TimePoints contain: the whole cue history
+	// ("snapshot"), only a new cue ("cue"), or only the latest cue ("tick").
+	// Subscribers speaking ProtocolFull only receive snapshots.
+	Type string `json:"type"`
+
+	// Seq is the sequence number of the announcement.  It increases by one
+	// with every announcement except ticks, which carry the sequence number
+	// of the last announcement, so that a subscriber can tell if it has
+	// missed one.
+	Seq uint64 `json:"seq"`
+
+	// ServerTime is the time at which the announcement was made, in
+	// milliseconds since the UNIX epoch by the server's clock.  The Offsets
+	// of the TimePoints are relative to it, so a client which knows the
+	// offset of its own clock (see the ping exchange of /ws/performanceTime)
+	// can place the cues exactly, however long the announcement took to
+	// arrive.
+	ServerTime float64 `json:"server_time"`
+
 	// TimePoints lists the TimePoints (cues and their time offsets) which have been received so far, in order of appearance.
 	TimePoints []*TimePoint `json:"time_points"`
+
+	// Start is, for a subscriber to its own replay of a recorded performance
+	// (see Service.Playback), the time at which its replay began, in
+	// milliseconds since the UNIX epoch.  A client which gives it when
+	// reconnecting continues its replay where it left off.
+	Start float64 `json:"start,omitempty"`
 }
 
 // TimePoint describes a point in performance time, which can be exported
@@ -87,6 +186,12 @@ type TimePoint struct {
 	Offset float64 `json:"offset"`
 }
 
+// UnixMillis returns the given time in milliseconds since the UNIX epoch, as
+// used by Javascript
+func UnixMillis(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
+
 // OffsetSeconds returns the time elapsed since the associated cue was triggered.
 func (t *Time) OffsetSeconds() float64 {
 	return time.Since(t.Received).Seconds()
@@ -94,9 +199,14 @@ func (t *Time) OffsetSeconds() float64 {
 
 // Now returns the current point in performance time
 func (t *Time) Now() *TimePoint {
+	return t.At(time.Now())
+}
+
+// At returns the point in performance time at the given time
+func (t *Time) At(now time.Time) *TimePoint {
 	return &TimePoint{
 		Cue:    t.Cue,
-		Offset: t.OffsetSeconds(),
+		Offset: now.Sub(t.Received).Seconds(),
 	}
 }
 
@@ -104,31 +214,218 @@ func (t *Time) Now() *TimePoint {
 type Service struct {
 	Echo *echo.Echo
 
+	// Name identifies the show, where a server hosts several, in the "show"
+	// label of the service's gauges
+	Name string
+
 	// Times records the Cues as they are received
 	Times []*Time
 
+	// Sources are the sources of cues which are run by Run
+	Sources []CueSource
+
+	// Playback, if set, is a recorded performance which is replayed to each
+	// subscriber separately, from the time at which it subscribes (see
+	// SubscribePlayback), in place of the cue history.  It must be set before
+	// any subscriptions are made.
+	Playback *TimelineSource
+
 	subs []*Subscription
 
+	// seq is the sequence number of the last announcement
+	seq uint64
+
+	// MaxDrops is the number of consecutive announcements which may be
+	// dropped for a subscriber which is not keeping up before its
+	// subscription is cancelled (closing its channel), so that it reconnects
+	// and resynchronizes.  If it is zero, subscribers are never evicted.
+	MaxDrops int
+
+	// SessionTimeout is the time for which the private timeline of a listener
+	// session (see TriggerSession) is kept after its last subscriber has gone.
+	// If it is zero, the DefaultSessionTimeout is used.
+	SessionTimeout time.Duration
+
+	// UnmatchedPolicy determines what happens to received cue data which does
+	// not match any cue in the agenda.  The default is UnmatchedWarn.
+	UnmatchedPolicy UnmatchedPolicy
+
+	// ReplicaAddr, if set, is the TCP address on which the service serves
+	// its followers, standbys and web nodes (see Primary), sending each its
+	// cue history and then every change to it.
+	ReplicaAddr string
+
+	// Primary, if set, is the TCP address of the ReplicaAddr of another
+	// service of which this is a hot standby.  A standby keeps the same cue
+	// history as its primary, announcing it to its own subscribers, but runs
+	// none of its Sources or schedule, and accepts no cues or changes of its
+	// own, until it is promoted (see Promote).
+	Primary string
+
+	// ReplicationKey is the secret shared by a primary and its followers.
+	// Each proves to the other that it has it when a follower connects, and
+	// the link is refused if they differ.  The cue history is not encrypted,
+	// so the link should still be kept to a trusted network.
+	ReplicationKey string
+
+	// HeartbeatTimeout is the time without a message from the primary after
+	// which a standby considers it lost.  The default is
+	// DefaultHeartbeatTimeout.
+	HeartbeatTimeout time.Duration
+
+	// AutoPromote makes a standby promote itself when it loses its primary,
+	// having once been connected to it
+	AutoPromote bool
+
+	// Edge makes a service which follows a Primary a web node rather than a
+	// standby: one of several serving the primary's cue history to their own
+	// subscribers, as behind a load balancer, which is never promoted.  If it
+	// loses its primary, it keeps reconnecting, serving the last cue history
+	// it was sent meanwhile.
+	Edge bool
+
+	// journal is the file to which triggered cues are recorded
+	journal *os.File
+
+	// cueLog records every attempt to trigger a cue
+	cueLog cueLog
+
+	// audience counts the audience of the current performance
+	audience audience
+
+	// presenceWatchers are notified of changes to who is listening, and in
+	// which room
+	presenceWatchers []*presenceWatcher
+
+	// agenda is the agenda against whose cues received data is matched
+	agenda atomic.Pointer[agenda.Agenda]
+
+	// sessions holds the private timelines of listener sessions, by ID
+	sessions map[string]*session
+
+	// unmatched records recently-received cue data which did not match
+	unmatched unmatchedCues
+
+	// schedule holds the cues scheduled to be triggered automatically
+	schedule scheduler
+
+	// autopilot steps through the agenda's cues for rehearsals
+	autopilot autopilot
+
+	// standby indicates that the service is following the cue history of its
+	// Primary
+	standby bool
+
+	// replication holds the standbys of the service and, for a standby, the
+	// state of its link to the primary
+	replication replication
+
+	// stopped indicates that the service has been shut down and accepts no
+	// more cues
+	stopped bool
+
 	mu sync.Mutex
 }
 
-// Subscribe registers a subscription to receive showtime announcements
+// Subscribe registers a subscription to receive showtime announcements,
+// using ProtocolFull
 func (s *Service) Subscribe() *Subscription {
-	sub := newSubscription(s)
-	s.add(sub)
-	return sub
+	return s.SubscribeProtocol(ProtocolFull)
+}
+
+// SubscribeProtocol registers a subscription to receive showtime
+// announcements using the given protocol.  Under ProtocolDelta, the first
+// announcement is a snapshot.
+func (s *Service) SubscribeProtocol(p Protocol) *Subscription {
+	return s.SubscribeWith(&SubscribeOptions{Protocol: p})
+}
+
+// Resume registers a ProtocolDelta subscription for a subscriber which has
+// already received the announcements up to the given sequence number, as when
+// a client reconnects.  It begins with a snapshot only if the subscriber has
+// missed any announcements in the meantime.
+func (s *Service) Resume(seq uint64) *Subscription {
+	return s.SubscribeWith(&SubscribeOptions{
+		Protocol: ProtocolDelta,
+		Resume:   true,
+		Seq:      seq,
+	})
 }
 
-func (s *Service) add(sub *Subscription) {
+// SubscribeOptions describe a subscription (see SubscribeWith)
+type SubscribeOptions struct {
+
+	// Protocol is the announcement protocol spoken by the subscriber
+	Protocol Protocol
+
+	// Resume indicates that the subscriber is reconnecting, having received
+	// the announcements up to Seq, so that under ProtocolDelta it begins with
+	// a snapshot only if it has missed any in the meantime
+	Resume bool
+	Seq    uint64
+
+	// Start is, if the service has a Playback timeline, the time at which the
+	// subscriber's replay began, as for a listener who reconnects.  If it is
+	// zero or in the future, the replay begins now.
+	Start time.Time
+
+	// Session identifies the listener session whose private timeline the
+	// subscriber follows along with the shared one (see TriggerSession), if
+	// any.  An invalid session ID (see ValidSessionID) is ignored.
+	Session string
+
+	// Room is the name of the room the listener is in, if any (see Presence)
+	Room string
+
+	// Client describes the client, such as its address, for listing who is
+	// listening (see Presence)
+	Client string
+}
+
+// SubscribeWith registers a subscription to receive showtime announcements,
+// as described by the given options.  Under ProtocolDelta, the first
+// announcement is a snapshot, unless the subscriber is resuming and has
+// missed nothing.
+func (s *Service) SubscribeWith(opts *SubscribeOptions) *Subscription {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := newSubscription(s, opts.Protocol)
+	sub.room = opts.Room
+	sub.client = opts.Client
 	s.subs = append(s.subs, sub)
-	s.mu.Unlock()
+
+	s.audience.listeners(len(s.subs), sub.since)
+
+	if ValidSessionID(opts.Session) {
+		sub.sessionID = opts.Session
+	}
+
+	if s.Playback != nil {
+		s.startPlayback(sub, opts.Start)
+	}
+
+	if opts.Protocol == ProtocolDelta && !(opts.Resume && opts.Seq == sub.seq()) {
+		sub.C <- sub.snapshot(SyncNotification)
+	}
+
+	s.updatePresence()
+
+	return sub
 }
 
 func (s *Service) remove(sub *Subscription) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.removeLocked(sub)
+}
+
+// removeLocked removes the given subscription.  The caller must hold the
+// service lock.
+func (s *Service) removeLocked(sub *Subscription) {
+	sub.stopPlayback()
+
 	for i, si := range s.subs {
 		if sub == si {
 			// Subs are pointers, so we have to explicitly remove them
@@ -136,25 +433,61 @@ func (s *Service) remove(sub *Subscription) {
 			s.subs[i] = s.subs[len(s.subs)-1] // replace the current with the end
 			s.subs[len(s.subs)-1] = nil       // remove the end
 			s.subs = s.subs[:len(s.subs)-1]   // lop off the end
+
+			s.updatePresence()
 			return
 		}
 	}
 }
 
-// Run executes the showtime service
-func (s *Service) Run(qlabAddr string) error {
-	addr, err := net.ResolveUDPAddr("udp", qlabAddr)
-	if err != nil {
-		return fmt.Errorf("failed to parse cue listener address: %w", err)
+// evict cancels a subscription whose subscriber is not keeping up.  The
+// caller must hold the service lock.
+func (s *Service) evict(sub *Subscription) {
+	s.Echo.Logger.Warnf("evicting subscriber after %d consecutive dropped announcements", sub.consecutiveDrops)
+
+	s.removeLocked(sub)
+
+	sub.mu.Lock()
+	closed := sub.closed
+	sub.closed = true
+	sub.evicted = true
+	sub.mu.Unlock()
+
+	// If the subscriber has cancelled the subscription itself, it will close
+	// the channel.
+	if !closed {
+		close(sub.C)
 	}
-	conn, err := net.ListenUDP("udp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on UDP port: %w", err)
+
+	metricEvictedCount.Inc()
+}
+
+// Run executes the showtime service, running each of its Sources, until the
+// context is cancelled or a source fails.
+func (s *Service) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	defer s.stopSchedule()
+
+	s.mu.Lock()
+	if s.audience.since.IsZero() {
+		s.audience.reset(time.Now())
 	}
+	s.mu.Unlock()
+
+	errs := make(chan error, len(s.Sources)+1)
 
-	defer conn.Close() //nolint: errcheck
+	if s.ReplicaAddr != "" {
+		go func() {
+			if err := s.serveReplicas(ctx); err != nil {
+				errs <- err
+			}
+		}()
+	}
 
-	go s.processUDP(conn)
+	// A standby receives no cues of its own until it is promoted
+	active := s.follow(ctx)
 
 	// Tick on a periodic interval
 	ticker := time.NewTicker(minUpdateInterval)
@@ -162,91 +495,408 @@ func (s *Service) Run(qlabAddr string) error {
 
 	// Notify each subscriber when an update occurs
 	for {
-		<-ticker.C
-		s.notify(PeriodicNotification)
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			return err
+		case <-active:
+			active = nil
+
+			s.startSchedule()
+
+			for _, src := range s.Sources {
+				go func(src CueSource) {
+					s.Echo.Logger.Infof("receiving cues from %s", src)
+
+					if err := src.Run(ctx, s); err != nil {
+						errs <- fmt.Errorf("cue source %s failed: %w", src, err)
+					}
+				}(src)
+			}
+		case <-ticker.C:
+			s.notify(PeriodicNotification)
+			s.expireSessions()
+
+			s.mu.Lock()
+			err := s.saveAudience()
+			s.mu.Unlock()
+			if err != nil {
+				s.Echo.Logger.Error(err)
+			}
+		}
 	}
 }
 
+// Announce sends an announcement with the given cause to all subscribers
+func (s *Service) Announce(cause string) {
+	s.notify(cause)
+}
+
+// Snapshot returns an announcement of the whole cue history with the given
+// cause, for a subscriber which needs to resynchronize.
+func (s *Service) Snapshot(cause string) *Announcement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.snapshot(cause)
+}
+
 func (s *Service) notify(cause string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Construct announcement
-	var points []*TimePoint
-	for _, t := range s.Times {
-		points = append(points, t.Now())
+	s.announce(cause)
+}
+
+// announce sends an announcement with the given cause to all subscribers.
+// The caller must hold the service lock, so that the announcement reflects
+// exactly the change which caused it.
+func (s *Service) announce(cause string) {
+	if cause != PeriodicNotification {
+		s.seq++
 	}
 
 	if len(s.Times) > 0 {
-		metricTimeSinceLastCue.Set(s.Times[len(s.Times)-1].OffsetSeconds())
+		metricTimeSinceLastCue.WithLabelValues(s.Name).Set(s.Times[len(s.Times)-1].OffsetSeconds())
 	}
 
-	ann := &Announcement{
-		Cause:      cause,
-		TimePoints: points,
-	}
+	metricSubsCount.WithLabelValues(s.Name).Set(float64(len(s.subs)))
 
-	metricSubsCount.Set(float64(len(s.subs)))
+	// Construct the announcements lazily, since each protocol may have no
+	// subscribers
+	var full, delta *Announcement
+
+	var evicted []*Subscription
 
 	for _, sub := range s.subs {
-		select {
-		case sub.C <- ann:
-		default: // never block
+		var ann *Announcement
+		switch {
+		case sub.private():
+			// A replay is unaffected by changes to the cue history
+			if sub.playback != nil {
+				switch cause {
+				case CueNotification, UndoNotification, RewindNotification, ResetNotification:
+					continue
+				}
+			}
+			if sub.Protocol == ProtocolDelta {
+				ann = sub.delta(cause)
+			} else {
+				ann = sub.snapshot(cause)
+			}
+		case sub.Protocol == ProtocolDelta:
+			if delta == nil {
+				delta = s.delta(cause)
+			}
+			ann = delta
+		default:
+			if full == nil {
+				full = s.snapshot(cause)
+			}
+			ann = full
+		}
+
+		if !s.send(sub, ann) {
+			evicted = append(evicted, sub)
 		}
 	}
+
+	for _, sub := range evicted {
+		s.evict(sub)
+	}
 }
 
-func (s *Service) processUDP(conn *net.UDPConn) {
-	for {
-		buf := make([]byte, maxUDPMessageSize)
-		n, err := conn.Read(buf)
-		if err != nil {
-			// TODO: handle failure; reconnect
-			s.Echo.Logger.Error(fmt.Errorf("failed to read from UDP port: %w", err))
-			return
+// send delivers an announcement to a subscriber without blocking.  It returns
+// false if the subscriber has fallen so far behind that it should be evicted.
+// The caller must hold the service lock.
+func (s *Service) send(sub *Subscription, ann *Announcement) bool {
+	select {
+	case sub.C <- ann:
+		sub.consecutiveDrops = 0
+	default: // never block
+		metricDroppedCount.Inc()
+		sub.dropped.Add(1)
+		sub.consecutiveDrops++
+
+		if s.MaxDrops > 0 && sub.consecutiveDrops >= s.MaxDrops {
+			return false
 		}
+	}
 
-		recv := string(buf[0:n])
-		s.Echo.Logger.Debugf("received message from QLab: %s", recv)
+	return true
+}
 
-		// Update the showtime Time
-		s.Trigger(recv)
+// snapshot constructs an announcement of the whole cue history.  The caller
+// must hold the service lock.
+func (s *Service) snapshot(cause string) *Announcement {
+	return newSnapshot(cause, s.seq, s.Times)
+}
 
-		metricCueQLabCount.Add(1)
+// delta constructs the ProtocolDelta announcement for the given cause.  The
+// caller must hold the service lock.
+func (s *Service) delta(cause string) *Announcement {
+	return newDelta(cause, s.seq, s.Times)
+}
+
+// newSnapshot constructs an announcement of the whole of the given cue
+// history
+func newSnapshot(cause string, seq uint64, times []*Time) *Announcement {
+	now := time.Now()
+
+	points := make([]*TimePoint, 0, len(times))
+	for _, t := range times {
+		points = append(points, t.At(now))
+	}
+
+	return &Announcement{
+		Cause:      cause,
+		Type:       SnapshotAnnouncement,
+		Seq:        seq,
+		ServerTime: UnixMillis(now),
+		TimePoints: points,
 	}
 }
 
-// Trigger activates the given cue
-func (s *Service) Trigger(cue string) {
+// newDelta constructs the ProtocolDelta announcement for the given cause and
+// cue history
+func newDelta(cause string, seq uint64, times []*Time) *Announcement {
+	var typ string
+	switch cause {
+	case CueNotification:
+		typ = CueAnnouncement
+	case PeriodicNotification:
+		typ = TickAnnouncement
+	default:
+		return newSnapshot(cause, seq, times)
+	}
+
+	now := time.Now()
+
+	ann := &Announcement{
+		Cause:      cause,
+		Type:       typ,
+		Seq:        seq,
+		ServerTime: UnixMillis(now),
+		TimePoints: []*TimePoint{},
+	}
+	if len(times) > 0 {
+		ann.TimePoints = append(ann.TimePoints, times[len(times)-1].At(now))
+	}
+
+	return ann
+}
+
+// Trigger activates the given cue.  The origin describes where the cue came
+// from (see CueSource).  It fails with ErrStopped if the service has been
+// shut down.
+func (s *Service) Trigger(cue string, origin string) error {
+	start := time.Now()
+
 	s.mu.Lock()
-	s.Times = append(s.Times, &Time{
-		Cue:      cue,
-		Received: time.Now(),
-	})
+	if s.stopped {
+		s.mu.Unlock()
+		s.Echo.Logger.Warnf("refusing cue %q from %s: %s", cue, origin, ErrStopped)
+		s.logAttempt(start, cue, origin, CueRefused, ErrStopped.Error())
+		return ErrStopped
+	}
+	if s.standby {
+		s.mu.Unlock()
+		s.Echo.Logger.Warnf("refusing cue %q from %s: %s", cue, origin, ErrStandby)
+		s.logAttempt(start, cue, origin, CueRefused, ErrStandby.Error())
+		return ErrStandby
+	}
+	now := time.Now()
+	s.record(&journalEntry{Cue: cue, Received: now, Origin: origin})
+	s.announce(CueNotification)
 	s.mu.Unlock()
 
-	s.Echo.Logger.Info("triggering cue:", cue)
-	s.notify(CueNotification)
+	s.Echo.Logger.Infof("triggering cue %q from %s", cue, origin)
+	s.logAttempt(start, cue, origin, CueTriggered, "")
+
+	s.scheduleFollowers(cue, now)
 
 	metricCueCount.Add(1)
+
+	return nil
+}
+
+// Shutdown stops the service accepting cues and changes to the cue history,
+// sends each subscriber a final announcement (RestartNotification), cancels
+// every subscription, saves the audience, and closes the journal and cue log.
+// Its sources are stopped by cancelling the context passed to Run.
+func (s *Service) Shutdown() error {
+	s.StopAutopilot()
+	s.stopSchedule()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return nil
+	}
+	s.stopped = true
+
+	s.announce(RestartNotification)
+
+	for _, sub := range s.subs {
+		sub.stopPlayback()
+
+		sub.mu.Lock()
+		closed := sub.closed
+		sub.closed = true
+		sub.mu.Unlock()
+
+		// If the subscriber has cancelled the subscription itself, it will
+		// close the channel.
+		if !closed {
+			close(sub.C)
+		}
+	}
+	s.subs = nil
+
+	metricSubsCount.WithLabelValues(s.Name).Set(0)
+
+	s.updatePresence()
+	s.stopPresence()
+
+	logErr := errors.Join(s.saveAudience(), s.closeCueLog())
+
+	if s.journal == nil {
+		return logErr
+	}
+
+	err := s.journal.Sync()
+	if cerr := s.journal.Close(); err == nil {
+		err = cerr
+	}
+	s.journal = nil
+
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to close journal: %w", err), logErr)
+	}
+
+	return logErr
 }
 
 // Subscription represents a subscription to showtime announcements
 type Subscription struct {
-	C      chan *Announcement
-	closed bool
-	mu     sync.Mutex
+	C chan *Announcement
+
+	// Protocol is the announcement protocol spoken by the subscriber
+	Protocol Protocol
+
+	// dropped counts the announcements dropped because C was full
+	dropped atomic.Uint64
+
+	// consecutiveDrops counts the announcements dropped since the last one
+	// delivered.  It is protected by the service lock.
+	consecutiveDrops int
+
+	// playback is the subscriber's own replay of the service's Playback
+	// timeline, if it has one.  It is protected by the service lock.
+	playback *playback
+
+	// sessionID identifies the listener session whose private timeline the
+	// subscriber follows, if any (see JoinSession).  It is protected by the
+	// service lock.
+	sessionID string
+
+	// room is the name of the room the subscriber is in, if any, and client
+	// describes it (see SubscribeOptions)
+	room   string
+	client string
+
+	// since is the time at which the subscription was made
+	since time.Time
+
+	closed  bool
+	evicted bool
+	mu      sync.Mutex
 
 	svc *Service
 }
 
-func newSubscription(svc *Service) *Subscription {
+func newSubscription(svc *Service, p Protocol) *Subscription {
 	return &Subscription{
-		C:   make(chan *Announcement, subscriptionBufferSize),
-		svc: svc,
+		C:        make(chan *Announcement, subscriptionBufferSize),
+		Protocol: p,
+		since:    time.Now(),
+		svc:      svc,
 	}
 }
 
+// Snapshot returns an announcement of the whole cue history followed by the
+// subscriber, with the given cause, for a subscriber which needs to
+// resynchronize.
+func (s *Subscription) Snapshot(cause string) *Announcement {
+	s.svc.mu.Lock()
+	defer s.svc.mu.Unlock()
+
+	return s.snapshot(cause)
+}
+
+// seq returns the sequence number of the last announcement of the cue history
+// followed by the subscriber.  The caller must hold the service lock.
+func (s *Subscription) seq() uint64 {
+	seq := s.svc.seq
+	if s.playback != nil {
+		seq = uint64(len(s.playback.times))
+	}
+
+	if sess := s.svc.sessions[s.sessionID]; sess != nil {
+		seq += sess.seq
+	}
+
+	return seq
+}
+
+// snapshot constructs an announcement of the whole cue history followed by
+// the subscriber.  The caller must hold the service lock.
+func (s *Subscription) snapshot(cause string) *Announcement {
+	if !s.private() {
+		return s.svc.snapshot(cause)
+	}
+
+	ann := newSnapshot(cause, s.seq(), s.times())
+	if s.playback != nil {
+		ann.Start = UnixMillis(s.playback.start)
+	}
+
+	return ann
+}
+
+// delta constructs the ProtocolDelta announcement for the given cause of the
+// cue history followed by the subscriber.  The caller must hold the service
+// lock.
+func (s *Subscription) delta(cause string) *Announcement {
+	if !s.private() {
+		return s.svc.delta(cause)
+	}
+
+	ann := newDelta(cause, s.seq(), s.times())
+	if s.playback != nil {
+		ann.Start = UnixMillis(s.playback.start)
+	}
+
+	return ann
+}
+
+// Dropped returns the number of announcements which have been dropped
+// because the subscriber was not keeping up
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Evicted indicates whether the subscription was cancelled by the service
+// because the subscriber was not keeping up (see Service.MaxDrops)
+func (s *Subscription) Evicted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.evicted
+}
+
 // Cancel cancels the subscription and removes it from
 // the service
 func (s *Subscription) Cancel() {
@@ -0,0 +1,149 @@
+package showtime
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestEvict(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxDrops      int
+		announcements []int
+		wantDropped   uint64
+		wantEvicted   bool
+	}{
+		{
+			name:          "keeping up",
+			maxDrops:      3,
+			announcements: []int{subscriptionBufferSize},
+		},
+		{
+			name:          "never evicted",
+			maxDrops:      0,
+			announcements: []int{20},
+			wantDropped:   20 - subscriptionBufferSize,
+		},
+		{
+			name:          "below the limit",
+			maxDrops:      3,
+			announcements: []int{subscriptionBufferSize + 2},
+			wantDropped:   2,
+		},
+		{
+			name:          "evicted",
+			maxDrops:      3,
+			announcements: []int{20},
+			wantDropped:   3,
+			wantEvicted:   true,
+		},
+		{
+			name:          "drops forgiven once caught up",
+			maxDrops:      3,
+			announcements: []int{subscriptionBufferSize + 2, subscriptionBufferSize + 2},
+			wantDropped:   4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(nil)
+			s.MaxDrops = tt.maxDrops
+
+			sub := s.Subscribe()
+
+			// The subscriber reads nothing until it has been sent each batch
+			// of announcements
+			var closed bool
+			for _, n := range tt.announcements {
+				for i := 0; i < n; i++ {
+					s.Announce(PeriodicNotification)
+				}
+
+				for pending := len(sub.C); pending > 0; pending-- {
+					<-sub.C
+				}
+				select {
+				case _, ok := <-sub.C:
+					closed = !ok
+				default:
+				}
+			}
+
+			if got := sub.Dropped(); got != tt.wantDropped {
+				t.Errorf("got %d dropped, want %d", got, tt.wantDropped)
+			}
+			if got := sub.Evicted(); got != tt.wantEvicted || closed != tt.wantEvicted {
+				t.Errorf("got evicted %v and closed %v, want %v", got, closed, tt.wantEvicted)
+			}
+
+			s.mu.Lock()
+			subs := len(s.subs)
+			s.mu.Unlock()
+			if tt.wantEvicted == (subs > 0) {
+				t.Errorf("got %d subscribers, want evicted %v", subs, tt.wantEvicted)
+			}
+
+			// Cancelling an evicted subscription does nothing
+			sub.Cancel()
+		})
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	s := newTestService(nil)
+	if err := s.Trigger("a", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	full := s.Subscribe()
+	delta := s.SubscribeProtocol(ProtocolDelta)
+	announcements(delta)
+
+	cancelled := s.Subscribe()
+	cancelled.Cancel()
+
+	if err := s.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each subscriber is told of the restart, and then its subscription is
+	// cancelled
+	for _, sub := range []*Subscription{full, delta} {
+		ann, ok := <-sub.C
+		if !ok || ann.Cause != RestartNotification || len(ann.TimePoints) != 1 {
+			t.Errorf("got %v, want a restart with the cue history", ann)
+		}
+		if _, ok := <-sub.C; ok {
+			t.Errorf("got an announcement after the restart, want the subscription closed")
+		}
+
+		// Cancelling it afterwards does nothing
+		sub.Cancel()
+	}
+
+	// Nothing changes the cue history once it has shut down
+	changes := []struct {
+		name string
+		do   func() error
+	}{
+		{"trigger", func() error { return s.Trigger("b", "test") }},
+		{"undo", func() error { _, err := s.Undo(); return err }},
+		{"rewind", func() error { _, err := s.Rewind("a"); return err }},
+		{"reset", s.Reset},
+	}
+	for _, c := range changes {
+		if err := c.do(); !errors.Is(err, ErrStopped) {
+			t.Errorf("%s: got %v, want %v", c.name, err, ErrStopped)
+		}
+	}
+	if got := cueNames(s.History()); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("got %v, want [a]", got)
+	}
+
+	// Shutting down again does nothing
+	if err := s.Shutdown(); err != nil {
+		t.Errorf("got %v shutting down again", err)
+	}
+}
@@ -1,13 +1,39 @@
 import {PerformanceTime} from './performanceTime.js';
+import {ShowPath} from './show.js';
 
 let performanceTime = new PerformanceTime()
 
 export function TriggerCue(id) {
-   fetch('/cues/'+id, {
+   fetch(ShowPath('/cues/')+id, {
       method: 'PUT'
    })
 }
 
+// UndoCue removes the most recently-triggered cue from the cue history
+export function UndoCue() {
+   fetch(ShowPath('/timeline/last'), {
+      method: 'DELETE'
+   })
+}
+
+// RewindTo truncates the cue history back to the given cue
+export function RewindTo(id) {
+   fetch(ShowPath('/timeline/rewind/')+id, {
+      method: 'POST'
+   })
+}
+
+// ResetTimeline clears the cue history, as between performances
+export function ResetTimeline() {
+   if (!window.confirm("Clear the entire cue history?")) {
+      return
+   }
+
+   fetch(ShowPath('/timeline'), {
+      method: 'DELETE'
+   })
+}
+
 function formatMinuteSeconds(sec) {
    let min = 0
 
@@ -30,7 +56,186 @@ export function BindCueStatus(lastCueId, sinceLastCueId) {
          document.getElementById(lastCueId).innerHTML = cue.cue
 
          document.getElementById(sinceLastCueId).innerHTML = formatMinuteSeconds(performanceTime.sinceCue(cue.cue))
+      } else {
+         document.getElementById(lastCueId).innerHTML = "-none-"
+         document.getElementById(sinceLastCueId).innerHTML = "-:--"
       }
 
    }, 1000)
 }
+
+// CancelScheduled cancels the scheduled cue with the given ID
+export function CancelScheduled(id) {
+   return fetch(ShowPath('/schedule/')+id, {
+      method: 'DELETE'
+   })
+}
+
+// BindSchedule periodically lists the cues scheduled to be triggered
+// automatically in the element with the given ID, each with a button to
+// cancel it.
+export function BindSchedule(listId) {
+   function update() {
+      fetch(ShowPath('/schedule'))
+      .then(function(resp) {
+         return resp.json()
+      })
+      .then(function(scheduled) {
+         let list = document.getElementById(listId)
+         list.innerHTML = ""
+
+         scheduled.forEach(function(sc) {
+            let item = document.createElement("li")
+            item.textContent = `${sc.cue} at ${new Date(sc.at).toLocaleTimeString()} (${sc.reason}) `
+
+            let cancel = document.createElement("button")
+            cancel.textContent = "Cancel"
+            cancel.addEventListener('click', function() {
+               CancelScheduled(sc.id).then(update)
+            })
+            item.appendChild(cancel)
+
+            list.appendChild(item)
+         })
+      })
+   }
+
+   update()
+   setInterval(update, 5000)
+}
+
+// Autopilot controls the rehearsal autopilot.  The action is one of "start",
+// "pause", "resume", "skip", "speed", or "stop"; params may give the "from"
+// cue ID and "speed".
+export function Autopilot(action, params) {
+   return fetch(ShowPath('/autopilot/')+action, {
+      method: 'POST',
+      body: new URLSearchParams(params || {})
+   })
+}
+
+// BindAutopilot periodically shows the status of the autopilot in the
+// element with the given ID.
+export function BindAutopilot(statusId) {
+   setInterval(function() {
+      fetch(ShowPath('/autopilot'))
+      .then(function(resp) {
+         return resp.json()
+      })
+      .then(function(status) {
+         let el = document.getElementById(statusId)
+
+         if (!status.running) {
+            el.textContent = "Off"
+            return
+         }
+
+         let state = status.paused ? "Paused" : "Running"
+         el.textContent = `${state} at ${status.speed}x; next: ${status.next || "-none-"} in ${formatMinuteSeconds(status.remaining)}`
+      })
+   }, 1000)
+}
+
+// Promote makes a standby server take over receiving cues from its primary
+export function Promote() {
+   return fetch(ShowPath('/replication/promote'), {
+      method: 'POST'
+   })
+}
+
+// BindReplication periodically shows whether the server is a primary or a
+// standby, and the state of its link to the other, in the element with the
+// given ID.
+export function BindReplication(statusId) {
+   function update() {
+      fetch(ShowPath('/replication'))
+      .then(function(resp) {
+         return resp.json()
+      })
+      .then(function(status) {
+         let el = document.getElementById(statusId)
+
+         if (status.edge) {
+            let link = status.connected ? "connected to" : "NOT connected to"
+            el.textContent = `Web node, ${link} cue publisher ${status.primary}`
+            return
+         }
+
+         if (status.standby) {
+            let link = status.connected ? "connected to" : "NOT connected to"
+            el.textContent = `Standby, ${link} primary ${status.primary}`
+            return
+         }
+
+         let standbys = status.replicas.length > 0 ? status.replicas.join(", ") : "none"
+         el.textContent = `Primary; standbys: ${standbys}`
+      })
+   }
+
+   update()
+   setInterval(update, 2000)
+}
+
+// BindConnectionStatus shows in the element with the given ID when the server
+// is restarting and we are waiting to reconnect.
+export function BindConnectionStatus(statusId) {
+   performanceTime.addEventListener('serverRestart', function() {
+      document.getElementById(statusId).innerHTML = "Server restarting; reconnecting&hellip;"
+   })
+
+   performanceTime.addEventListener('reconnect', function() {
+      document.getElementById(statusId).innerHTML = ""
+   })
+}
+
+// BindUnmatched periodically lists the received cue data which did not match
+// any cue in the agenda in the element with the given ID.
+export function BindUnmatched(listId) {
+   function update() {
+      fetch(ShowPath('/cues/unmatched'))
+      .then(function(resp) {
+         return resp.json()
+      })
+      .then(function(unmatched) {
+         let list = document.getElementById(listId)
+         list.innerHTML = ""
+
+         unmatched.forEach(function(u) {
+            let item = document.createElement("li")
+            let action = u.triggered ? "triggered" : "dropped"
+            item.textContent = `${JSON.stringify(u.data)}: ${u.count}x, last ${new Date(u.last).toLocaleTimeString()} (${action})`
+            list.appendChild(item)
+         })
+      })
+   }
+
+   update()
+   setInterval(update, 5000)
+}
+
+// BindPresence lists, in the element with the given ID, the number of
+// listeners in each room right now, updated as they come and go.  Rooms with
+// no listeners are marked, so that front-of-house can see them before a cue.
+export function BindPresence(listId) {
+   let es = new EventSource(ShowPath('/admin/presence/events'))
+
+   es.addEventListener('message', function(ev) {
+      let presence = JSON.parse(ev.data)
+
+      let list = document.getElementById(listId)
+      list.innerHTML = ""
+
+      presence.rooms.forEach(function(r) {
+         let item = document.createElement("li")
+         let name = r.room || "(not in a room)"
+         let sessions = r.listeners.map(function(l) {
+            return l.session || l.client
+         }).join(", ")
+         item.textContent = `${name}: ${r.count}` + (sessions ? ` (${sessions})` : "")
+         if (r.room && r.count == 0) {
+            item.textContent += " — EMPTY"
+         }
+         list.appendChild(item)
+      })
+   })
+}
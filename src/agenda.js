@@ -1,3 +1,5 @@
+import {ShowPath} from './show.js'
+
 // LoadAgenda loads the agenda data from the server, executing the provided
 // callback with the agenda data as its argument.
 export function LoadAgenda(cb) {
@@ -6,7 +8,7 @@ export function LoadAgenda(cb) {
       return
    }
 
-   fetch('/agenda.json')
+   fetch(ShowPath('/agenda.json'))
    .then(function(resp) {
       return resp.json();
    })
@@ -2,10 +2,12 @@ import {PerformanceTime} from './performanceTime.js'
 import {SpatialRoom} from './room.js'
 import {LoadAgenda} from './agenda.js'
 import {TrackRoom} from './tracks.js'
+import {ShowPath} from './show.js'
 
 export {
    LoadAgenda as LoadAgenda,
    PerformanceTime as PerformanceTime,
+   ShowPath as ShowPath,
    SpatialRoom as SpatialRoom,
    TrackRoom as TrackRoom,
 }
@@ -2,8 +2,31 @@
 //import * as EventEmitter from './eventemitter3.js'
 //const EventEmitter = await import('./eventemitter3.js');
 
+import {ShowPath} from './show.js'
+
 export {PerformanceTime as PerformanceTime};
 
+// playbackKey is the session storage key for the start of our replay of a
+// recorded performance.  Each show on the server has its own.
+const playbackKey = 'audimance.playbackStart:'+ ShowPath('/')
+
+// roomFromLocation returns the ID of the room whose page is loaded, if any, so
+// that the server knows which room we are listening in
+function roomFromLocation() {
+   let m = window.location.pathname.match(/\/(room|tracks)\/([^\/]+)$/)
+   return m ? m[2] : undefined
+}
+
+// newSessionID generates a random ID for a listener session
+function newSessionID() {
+   let bytes = new Uint8Array(16)
+   crypto.getRandomValues(bytes)
+
+   return Array.from(bytes, function(b) {
+      return b.toString(16).padStart(2, '0')
+   }).join('')
+}
+
 class PerformanceTime extends EventTarget {
 
    constructor() {
@@ -20,6 +43,52 @@ class PerformanceTime extends EventTarget {
       // }
       this.cues = []
 
+      // seq is the sequence number of the last announcement applied to cues.
+      // The server sends only changes, so a gap in the sequence means a
+      // message was missed and a snapshot must be requested.
+      this.seq = undefined
+
+      // clockOffset is the estimated offset of the server's clock from ours,
+      // in milliseconds, and latency the estimated one-way delay of messages
+      // from the server.  They are measured by exchanging pings with the
+      // server, keeping the sample with the shortest round trip of the last
+      // few, which is the least distorted by queueing.
+      this.clockOffset = undefined
+      this.latency = undefined
+      this.clockSamples = []
+      this.pingID = 0
+
+      // wsFailures counts the consecutive attempts to connect by websocket
+      // which failed before the connection opened.  Some networks break
+      // websockets, so after a few we fall back to server-sent events.
+      this.wsFailures = 0
+
+      // restarting is set when the server announces that it is restarting,
+      // until we have reconnected.  Failures to connect meanwhile are
+      // expected, and not a sign that websockets do not work.
+      this.restarting = false
+
+      // playbackStart is, when the server is replaying a recorded
+      // performance to each listener separately, the time (by the server's
+      // clock) at which our replay began.  It is kept for the session, so that
+      // reconnecting or reloading the page continues the replay where it left
+      // off.
+      this.playbackStart = sessionStorage.getItem(playbackKey)
+
+      // session identifies this listener to the server, which may keep a
+      // private timeline of cues for it alone (see trigger).  It is kept for
+      // the browser session, and shared by every page and PerformanceTime in
+      // it.
+      this.session = sessionStorage.getItem('audimance.session')
+      if (!this.session) {
+         this.session = newSessionID()
+         sessionStorage.setItem('audimance.session', this.session)
+      }
+
+      // room is the ID of the room we are listening in, if any, by which the
+      // server counts the listeners in each room
+      this.room = roomFromLocation()
+
       this.connectWS()
 
    }
@@ -93,20 +162,53 @@ class PerformanceTime extends EventTarget {
    connectWS() {
       var self = this
 
+      if (typeof WebSocket === "undefined") {
+         this.connectSSE()
+         return
+      }
+
       console.log("connecting to server")
+      let path = ShowPath('/ws/performanceTime?v=2&'+ this.query())
+
       var ws = {}
       if(window.location.protocol =="https:") {
-         ws = new WebSocket("wss://"+ location.host +'/ws/performanceTime')
+         ws = new WebSocket("wss://"+ location.host + path)
       } else{
-         ws = new WebSocket("ws://"+ location.host +'/ws/performanceTime')
+         ws = new WebSocket("ws://"+ location.host + path)
       }
 
+      let pinger = undefined
+      let opened = false
+
       ws.addEventListener('open', function(ev) {
          console.log("connected to server")
+         opened = true
+         self.wsFailures = 0
+         if (self.restarting) {
+            self.restarting = false
+            self.dispatchEvent(new Event('reconnect'))
+         }
+
+         // Measure the clock offset quickly at first, then keep it current
+         let pings = 0
+         let ping = function() {
+            self.ping(ws)
+            pings++
+            pinger = setTimeout(ping, pings < 5 ? 250 : 15000)
+         }
+         ping()
       })
 
       ws.addEventListener('close', function(ev) {
          console.log("server connection closed")
+         clearTimeout(pinger)
+
+         if (!opened && !self.restarting && ++self.wsFailures >= 3) {
+            console.log("websockets are not working; falling back to server-sent events")
+            self.connectSSE()
+            return
+         }
+
          setTimeout(function() {
             self.connectWS()
          }, 1000)
@@ -119,42 +221,232 @@ class PerformanceTime extends EventTarget {
 
       ws.addEventListener('message', function(ev) {
          console.log("received performanceTime message from server")
-         
-         // Milliseconds since UNIX epoch
-         let now = Date.now()
 
          let t = JSON.parse(ev.data)
 
-         if (t === undefined) {
+         if (!t) {
             return
          }
 
-         var cues = []
-         t.time_points && t.time_points.forEach(function(tp) {
-
-            cues.push({
-               cue: tp.cue,
-               at: now - (tp.offset * 1000)
-            })
+         if (t.type == "pong") {
+            self.pong(t)
+            return
+         }
 
+         self.receive(t, function() {
+            console.log("missed an announcement; resynchronizing")
+            ws.send(JSON.stringify({type: "resync"}))
          })
-         if (cues.length > 0) {
-            self.cues = cues
+      })
+   }
+
+   // connectSSE follows the performance using server-sent events, for
+   // networks which break websockets.  The browser reconnects by itself,
+   // resuming from the last announcement received.
+   connectSSE() {
+      var self = this
+
+      console.log("connecting to server using server-sent events")
+      let es = new EventSource(ShowPath('/events/performanceTime?'+ this.query()))
+
+      es.addEventListener('open', function(ev) {
+         if (self.restarting) {
+            self.restarting = false
+            self.dispatchEvent(new Event('reconnect'))
          }
+      })
 
-         if (t.cause == "cue") {
-            self.dispatchEvent(new Event(cues[cues.length-1].cue))
-            self.dispatchEvent(new Event('cueChange'))
+      es.addEventListener('message', function(ev) {
+         let t = JSON.parse(ev.data)
 
-            //self.ee.emit(cues[cues.length-1].cue)
-            //self.ee.emit('cueChange')
-            console.log("received cue: "+ cues[cues.length-1].cue)
-         } else {
-            //self.ee.emit("timeSync")
-            self.dispatchEvent(new Event('timeSync'))
+         if (!t) {
+            return
+         }
+
+         self.receive(t, function() {
+            // There is no way to ask for a snapshot over this connection,
+            // but a fresh one begins with one.
+            console.log("missed an announcement; reconnecting")
+            es.close()
+            self.connectSSE()
+         })
+      })
+
+      es.addEventListener('error', function(ev) {
+         console.log("error receiving server-sent events")
+
+         // The browser gives up reconnecting if the server refuses
+         if (es.readyState == EventSource.CLOSED) {
+            setTimeout(function() {
+               self.connectSSE()
+            }, 1000)
          }
       })
    }
-}
 
+   // query returns the query parameters which identify us to the server
+   query() {
+      let q = new URLSearchParams({session: this.session})
+      if (this.playbackStart) {
+         q.set('start', this.playbackStart)
+      }
+      if (this.room) {
+         q.set('room', this.room)
+      }
+
+      return q.toString()
+   }
 
+   // trigger triggers the cue with the given ID for this listener alone, as
+   // when a visitor advances through a self-paced tour.  The cue is announced
+   // like any other, to every PerformanceTime of the session.
+   trigger(cueID) {
+      return fetch(ShowPath('/session/cues/'+ cueID +'?'+ new URLSearchParams({session: this.session})), {
+         method: 'PUT'
+      })
+   }
+
+   // resetSession clears the cues triggered for this listener alone
+   resetSession() {
+      return fetch(ShowPath('/session/timeline?'+ new URLSearchParams({session: this.session})), {
+         method: 'DELETE'
+      })
+   }
+
+   // ping sends a ping to the server to measure the clock offset
+   ping(ws) {
+      this.pingID++
+      ws.send(JSON.stringify({
+         type: "ping",
+         id: this.pingID,
+         client_time: Date.now()
+      }))
+   }
+
+   // pong processes the server's answer to a ping, updating the estimated
+   // clock offset and latency
+   pong(t) {
+      let now = Date.now()
+
+      this.clockSamples.push({
+         offset: ((t.received - t.client_time) + (t.sent - now)) / 2,
+         delay: (now - t.client_time) - (t.sent - t.received)
+      })
+      if (this.clockSamples.length > 8) {
+         this.clockSamples.shift()
+      }
+
+      let best = this.clockSamples.reduce(function(a, b) {
+         return b.delay < a.delay ? b : a
+      })
+
+      let previous = this.clockOffset
+      this.clockOffset = best.offset
+      this.latency = best.delay / 2
+
+      // Move the cues by the change in the estimate
+      if (previous !== undefined && Math.abs(this.clockOffset - previous) > 5) {
+         let shift = previous - this.clockOffset
+         this.cues.forEach(function(c) {
+            c.at += shift
+         })
+         this.dispatchEvent(new Event('timeSync'))
+      }
+   }
+
+   // receive applies an announcement from the server.  resync is called if
+   // the announcement shows that an earlier one was missed.
+   receive(t, resync) {
+      if (t.start && t.start != this.playbackStart) {
+         this.playbackStart = t.start
+         sessionStorage.setItem(playbackKey, t.start)
+      }
+
+      // The time of the announcement, in milliseconds since the UNIX epoch
+      // by our clock.  Until the clock offset is known, the time of receipt
+      // is the best estimate.
+      let now = Date.now()
+      if (this.clockOffset !== undefined && t.server_time) {
+         now = t.server_time - this.clockOffset
+      }
+
+      let points = (t.time_points || []).map(function(tp) {
+         return {
+            cue: tp.cue,
+            at: now - (tp.offset * 1000)
+         }
+      })
+
+      if (t.type == "cue") {
+         if (this.seq !== undefined && t.seq <= this.seq) {
+            return // already applied, from a snapshot
+         }
+         if (this.seq === undefined || t.seq != this.seq + 1) {
+            resync()
+            return
+         }
+         this.seq = t.seq
+
+         this.cues = this.cues.concat(points)
+
+         this.dispatchEvent(new Event(points[points.length-1].cue))
+         this.dispatchEvent(new Event('cueChange'))
+         console.log("received cue: "+ points[points.length-1].cue)
+         return
+      }
+
+      if (t.type == "tick") {
+         if (this.seq === undefined || t.seq != this.seq) {
+            resync()
+            return
+         }
+
+         // Correct every cue by the drift of the latest, since their
+         // relative offsets do not change.
+         let latest = this.cues[this.cues.length-1]
+         if (latest && points.length > 0 && latest.cue == points[0].cue) {
+            let drift = points[0].at - latest.at
+            this.cues.forEach(function(c) {
+               c.at += drift
+            })
+         }
+
+         this.dispatchEvent(new Event('timeSync'))
+         return
+      }
+
+      // Otherwise, this is a snapshot of the whole cue history
+      this.seq = t.seq
+      this.cues = points
+
+      if (t.cause == "restart") {
+         // The server is going away briefly.  Keep playing what we have, and
+         // let listeners show that we are reconnecting.
+         console.log("server restarting")
+         this.restarting = true
+         this.dispatchEvent(new Event('serverRestart'))
+         return
+      }
+
+      if (t.cause == "cue") {
+         this.dispatchEvent(new Event(points[points.length-1].cue))
+         this.dispatchEvent(new Event('cueChange'))
+
+         console.log("received cue: "+ points[points.length-1].cue)
+      } else if (t.cause == "undo" || t.cause == "rewind" || t.cause == "reset" || t.cause == "sync") {
+         // An administrator has gone back in time, or we have missed
+         // something; re-evaluate which tracks should be playing.
+         console.log("cue history changed: "+ t.cause)
+         this.dispatchEvent(new Event('cueChange'))
+         this.dispatchEvent(new Event('timelineChange'))
+      } else if (t.cause == "agenda") {
+         // The agenda has been reloaded on the server; listeners should
+         // fetch /agenda.json again.
+         console.log("agenda changed")
+         this.dispatchEvent(new Event('agendaChange'))
+         this.dispatchEvent(new Event('timeSync'))
+      } else {
+         this.dispatchEvent(new Event('timeSync'))
+      }
+   }
+}
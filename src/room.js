@@ -11,6 +11,7 @@ import Environment from './environment.js';
 // Local dependencies
 import {LoadAgenda} from './agenda.js';
 import {PerformanceTime} from './performanceTime.js';
+import {ShowPath} from './show.js';
 
 var performanceTime = new PerformanceTime()
 var noSleep = new NoSleep()
@@ -444,7 +445,7 @@ class Track {
 
       for ( let i = 0; i < srcTrack.audioFiles.length; i++ ) {
          console.log("updating source for "+ self.src.id +" to "+ srcTrack.audioFiles[i])
-         self.el.getElementsByTagName("source")[i].src = srcTrack.audioFiles[i]
+         self.el.getElementsByTagName("source")[i].src = ShowPath(srcTrack.audioFiles[i])
       }
 
       self.loaded = true
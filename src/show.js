@@ -0,0 +1,15 @@
+export {ShowPath as ShowPath};
+
+// showPrefix is the URL prefix of the show whose page is loaded, where the
+// server hosts several, or empty otherwise
+const showPrefix = (window.location.pathname.match(/^\/shows\/[^\/]+/) || [''])[0]
+
+// ShowPath returns the URL of the given path of the show whose page is loaded.
+// Paths which are not absolute on this server are returned unchanged.
+function ShowPath(path) {
+   if (!path.startsWith('/') || path.startsWith('//') || path.startsWith(showPrefix +'/')) {
+      return path
+   }
+
+   return showPrefix + path
+}
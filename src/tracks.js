@@ -1,4 +1,5 @@
 import {PerformanceTime} from './performanceTime.js'
+import {ShowPath} from './show.js'
 
 let performanceTime = new PerformanceTime()
 
@@ -6,7 +7,7 @@ export let SyncTolerance = 3.0 // sec
 export let WakeCheckInterval = 6000.0 // ms
 
 function urlFor(t) {
-   return t.audioFiles[1]
+   return ShowPath(t.audioFiles[1])
 }
 
 // TrackRoom creates a track-based performance room for legacy browsers which do not support spatialised audio.
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/CyCoreSystems/audimance/auth"
+	"github.com/labstack/gommon/log"
+)
+
+// newAuthenticator loads the users file and audit log for the web service.
+// There must be a users file, unless the -insecure flag is given, in which case
+// authentication is disabled without one.
+func newAuthenticator() (*auth.Authenticator, error) {
+	authn := &auth.Authenticator{
+		Sessions: new(auth.Sessions),
+	}
+
+	store, err := auth.Load(usersFile)
+	switch {
+	case errors.Is(err, auth.ErrNotExist) && !insecure:
+		return nil, fmt.Errorf("no users file (%s): add an administrator with \"%s user -role admin <name>\", or pass -insecure to leave the admin console and cue API unprotected", usersFile, os.Args[0])
+	case errors.Is(err, auth.ErrNotExist):
+		log.Warnf("no users file (%s); the admin console and cue API are NOT protected", usersFile)
+	case err != nil:
+		return nil, err
+	default:
+		authn.Store = store
+	}
+
+	if auditFile != "" {
+		if authn.Audit, err = auth.NewAuditLog(auditFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return authn, nil
+}
+
+// setUser adds a user to the users file or changes an existing user's
+// password.  The password is read from standard input.
+func setUser(args []string) int {
+	flags := flag.NewFlagSet("user", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s user [flags] <name>\n\nThe password is read from standard input.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&usersFile, "users", "users.yaml", "users file to modify")
+	role := flags.String("role", "", "role of the user (admin, stageManager, technician, viewer, or any role defined in the users file); a new user without one is a viewer")
+	flags.Parse(args) //nolint: errcheck
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	store, err := loadOrCreateUsers()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		fmt.Fprintf(os.Stderr, "failed to read password: %s\n", err.Error())
+		return 1
+	}
+
+	if err := store.SetPassword(flags.Arg(0), strings.TrimRight(password, "\r\n")); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	// Only change the role when asked, so that changing a password does not
+	// also change what the user may do.
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "role" {
+			err = store.SetRole(flags.Arg(0), *role)
+		}
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	if err := store.Save(usersFile); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+// newToken generates an API token, adding it to the users file and printing
+// it.
+func newToken(args []string) int {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s token [flags] <name>\n\nThe token is printed once and cannot be recovered later.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&usersFile, "users", "users.yaml", "users file to modify")
+	role := flags.String("role", auth.DefaultRole, "role of the token (admin, stageManager, technician, viewer, or any role defined in the users file)")
+	flags.Parse(args) //nolint: errcheck
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	store, err := loadOrCreateUsers()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	token, err := store.NewToken(flags.Arg(0), *role)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	if err := store.Save(usersFile); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	fmt.Println(token)
+
+	return 0
+}
+
+func loadOrCreateUsers() (*auth.Store, error) {
+	store, err := auth.Load(usersFile)
+	if errors.Is(err, auth.ErrNotExist) {
+		return new(auth.Store), nil
+	}
+
+	return store, err
+}
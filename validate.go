@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/CyCoreSystems/audimance/agenda"
+)
+
+// requiredViews are the templates rendered by the web service
+var requiredViews = []string{"index.html", "admin.html", "live.html", "room.html", "tracks.html"}
+
+// requiredBundle are the files and directories of the embedded app bundle
+// which the web service and views depend upon
+var requiredBundle = []string{"app/app.js", "app/admin.js", "app/_snowpack/pkg"}
+
+// validation is the result of the validate command
+type validation struct {
+
+	// Valid indicates that no errors (or, in strict mode, no warnings) were
+	// found
+	Valid bool `json:"valid"`
+
+	// Errors is the number of problems of error severity
+	Errors int `json:"errors"`
+
+	// Warnings is the number of problems of warning severity
+	Warnings int `json:"warnings"`
+
+	// Problems lists every problem found
+	Problems []*problem `json:"problems"`
+}
+
+// problem is an agenda.Problem qualified by the file in which it was found
+type problem struct {
+	File string `json:"file"`
+
+	agenda.Problem
+}
+
+func (v *validation) add(file string, severity agenda.Severity, err error) {
+	v.Problems = append(v.Problems, &problem{
+		File: file,
+		Problem: agenda.Problem{
+			Severity: severity,
+			Message:  err.Error(),
+		},
+	})
+}
+
+// validate checks a show directory without starting any services
+func validate(args []string) int {
+	var jsonOutput bool
+	var strict bool
+
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s validate [flags] [show directory]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.BoolVar(&jsonOutput, "json", false, "write results as JSON")
+	flags.BoolVar(&strict, "strict", false, "treat warnings as errors")
+	flags.Parse(args) //nolint: errcheck
+
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	v := new(validation)
+
+	// Agenda paths (media, in particular) are relative to the show directory
+	if dir := flags.Arg(0); dir != "" {
+		if err := os.Chdir(dir); err != nil {
+			v.add(dir, agenda.SeverityError, fmt.Errorf("failed to enter show directory: %w", err))
+			return v.finish(jsonOutput, strict)
+		}
+	}
+
+	a, report := agenda.Validate("agenda.yaml")
+	for _, p := range report.Problems {
+		v.Problems = append(v.Problems, &problem{
+			File:    report.File,
+			Problem: *p,
+		})
+	}
+
+	if views, err := parseViews(".", ""); err != nil {
+		v.add("views", agenda.SeverityError, fmt.Errorf("failed to parse templates: %w", err))
+	} else {
+		v.checkViews(views, a)
+	}
+
+	v.checkBundle(content)
+
+	return v.finish(jsonOutput, strict)
+}
+
+// checkViews renders each required view with the data it would receive from
+// the web service.
+func (v *validation) checkViews(t *template.Template, a *agenda.Agenda) {
+	for _, name := range requiredViews {
+		file := filepath.Join("views", name)
+
+		if t.Lookup(name) == nil {
+			v.add(file, agenda.SeverityError, fmt.Errorf("missing required view %s", name))
+			continue
+		}
+
+		// Without an agenda, there is nothing with which to render
+		if a == nil {
+			continue
+		}
+
+		var data []interface{}
+		switch name {
+		case "room.html", "tracks.html":
+			for _, r := range a.Rooms {
+				data = append(data, &roomData{
+					Announcements: a.Announcements,
+					Room:          r,
+				})
+			}
+		default:
+			data = append(data, a)
+		}
+
+		for _, d := range data {
+			if err := t.ExecuteTemplate(io.Discard, name, d); err != nil {
+				v.add(file, agenda.SeverityError, fmt.Errorf("failed to render view: %w", err))
+				break
+			}
+		}
+	}
+}
+
+// checkBundle makes sure the app bundle was built before it was embedded
+func (v *validation) checkBundle(bundle fs.FS) {
+	for _, name := range requiredBundle {
+		if _, err := fs.Stat(bundle, name); err != nil {
+			v.add(name, agenda.SeverityError, fmt.Errorf("embedded app bundle is incomplete; was `npm run build` run before building?: %w", err))
+		}
+	}
+}
+
+// finish writes the results and returns the exit code
+func (v *validation) finish(jsonOutput bool, strict bool) int {
+	for _, p := range v.Problems {
+		switch p.Severity {
+		case agenda.SeverityError:
+			v.Errors++
+		case agenda.SeverityWarning:
+			v.Warnings++
+		}
+	}
+
+	v.Valid = v.Errors == 0 && (!strict || v.Warnings == 0)
+
+	if v.Problems == nil {
+		v.Problems = []*problem{}
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write results: %s\n", err.Error())
+			return 1
+		}
+	} else {
+		for _, p := range v.Problems {
+			fmt.Println(p.Describe(p.File))
+		}
+		fmt.Printf("%d error(s), %d warning(s)\n", v.Errors, v.Warnings)
+	}
+
+	if !v.Valid {
+		return 1
+	}
+
+	return 0
+}